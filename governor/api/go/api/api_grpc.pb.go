@@ -0,0 +1,113 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             v3.21.8
+// source: api/api.proto
+
+package api
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// GovernorClient is the client API for Governor service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type GovernorClient interface {
+	// InternalAllocateGPU is a governor-governor gRPC call which attempts to
+	// allocate remote resources. This cannot be called by the plugin.
+	//
+	// TODO(minkezhang): Consider exporting to an internal-only service.
+	InternalAllocateGPU(ctx context.Context, in *InternalAllocateGPURequest, opts ...grpc.CallOption) (*InternalAllocateGPUResponse, error)
+}
+
+type governorClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewGovernorClient(cc grpc.ClientConnInterface) GovernorClient {
+	return &governorClient{cc}
+}
+
+func (c *governorClient) InternalAllocateGPU(ctx context.Context, in *InternalAllocateGPURequest, opts ...grpc.CallOption) (*InternalAllocateGPUResponse, error) {
+	out := new(InternalAllocateGPUResponse)
+	err := c.cc.Invoke(ctx, "/governor.api.Governor/InternalAllocateGPU", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GovernorServer is the server API for Governor service.
+// All implementations must embed UnimplementedGovernorServer
+// for forward compatibility
+type GovernorServer interface {
+	// InternalAllocateGPU is a governor-governor gRPC call which attempts to
+	// allocate remote resources. This cannot be called by the plugin.
+	//
+	// TODO(minkezhang): Consider exporting to an internal-only service.
+	InternalAllocateGPU(context.Context, *InternalAllocateGPURequest) (*InternalAllocateGPUResponse, error)
+	mustEmbedUnimplementedGovernorServer()
+}
+
+// UnimplementedGovernorServer must be embedded to have forward compatible implementations.
+type UnimplementedGovernorServer struct {
+}
+
+func (UnimplementedGovernorServer) InternalAllocateGPU(context.Context, *InternalAllocateGPURequest) (*InternalAllocateGPUResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method InternalAllocateGPU not implemented")
+}
+func (UnimplementedGovernorServer) mustEmbedUnimplementedGovernorServer() {}
+
+// UnsafeGovernorServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to GovernorServer will
+// result in compilation errors.
+type UnsafeGovernorServer interface {
+	mustEmbedUnimplementedGovernorServer()
+}
+
+func RegisterGovernorServer(s grpc.ServiceRegistrar, srv GovernorServer) {
+	s.RegisterService(&Governor_ServiceDesc, srv)
+}
+
+func _Governor_InternalAllocateGPU_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(InternalAllocateGPURequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GovernorServer).InternalAllocateGPU(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/governor.api.Governor/InternalAllocateGPU",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GovernorServer).InternalAllocateGPU(ctx, req.(*InternalAllocateGPURequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Governor_ServiceDesc is the grpc.ServiceDesc for Governor service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Governor_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "governor.api.Governor",
+	HandlerType: (*GovernorServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "InternalAllocateGPU",
+			Handler:    _Governor_InternalAllocateGPU_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "api/api.proto",
+}
@@ -0,0 +1,223 @@
+// journal.proto
+// Specifies the on-disk lease journal record format.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.28.1
+// 	protoc        v3.21.8
+// source: api/journal.proto
+
+package journal
+
+import (
+	gpu "github.com/kevmo314/fedtorch/governor/api/go/gpu"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Entry_Op int32
+
+const (
+	Entry_OP_UNKNOWN Entry_Op = 0
+	// OP_PUT records a lease held on a GPU, replacing any previous
+	// lease on the same GPU under the same token.
+	Entry_OP_PUT Entry_Op = 1
+	// OP_DELETE records that the lease no longer holds the GPU.
+	Entry_OP_DELETE Entry_Op = 2
+)
+
+// Enum value maps for Entry_Op.
+var (
+	Entry_Op_name = map[int32]string{
+		0: "OP_UNKNOWN",
+		1: "OP_PUT",
+		2: "OP_DELETE",
+	}
+	Entry_Op_value = map[string]int32{
+		"OP_UNKNOWN": 0,
+		"OP_PUT":     1,
+		"OP_DELETE":  2,
+	}
+)
+
+func (x Entry_Op) Enum() *Entry_Op {
+	p := new(Entry_Op)
+	*p = x
+	return p
+}
+
+func (x Entry_Op) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Entry_Op) Descriptor() protoreflect.EnumDescriptor {
+	return file_api_journal_proto_enumTypes[0].Descriptor()
+}
+
+func (Entry_Op) Type() protoreflect.EnumType {
+	return &file_api_journal_proto_enumTypes[0]
+}
+
+func (x Entry_Op) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Entry_Op.Descriptor instead.
+func (Entry_Op) EnumDescriptor() ([]byte, []int) {
+	return file_api_journal_proto_rawDescGZIP(), []int{0, 0}
+}
+
+type Entry struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Op    Entry_Op   `protobuf:"varint,1,opt,name=op,proto3,enum=governor.journal.Entry_Op" json:"op,omitempty"`
+	Lease *gpu.Lease `protobuf:"bytes,2,opt,name=lease,proto3" json:"lease,omitempty"`
+}
+
+func (x *Entry) Reset() {
+	*x = Entry{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_api_journal_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Entry) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Entry) ProtoMessage() {}
+
+func (x *Entry) ProtoReflect() protoreflect.Message {
+	mi := &file_api_journal_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Entry.ProtoReflect.Descriptor instead.
+func (*Entry) Descriptor() ([]byte, []int) {
+	return file_api_journal_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Entry) GetOp() Entry_Op {
+	if x != nil {
+		return x.Op
+	}
+	return Entry_OP_UNKNOWN
+}
+
+func (x *Entry) GetLease() *gpu.Lease {
+	if x != nil {
+		return x.Lease
+	}
+	return nil
+}
+
+var File_api_journal_proto protoreflect.FileDescriptor
+
+var file_api_journal_proto_rawDesc = []byte{
+	0x0a, 0x11, 0x61, 0x70, 0x69, 0x2f, 0x6a, 0x6f, 0x75, 0x72, 0x6e, 0x61, 0x6c, 0x2e, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x12, 0x10, 0x67, 0x6f, 0x76, 0x65, 0x72, 0x6e, 0x6f, 0x72, 0x2e, 0x6a, 0x6f,
+	0x75, 0x72, 0x6e, 0x61, 0x6c, 0x1a, 0x0d, 0x61, 0x70, 0x69, 0x2f, 0x67, 0x70, 0x75, 0x2e, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x22, 0x8f, 0x01, 0x0a, 0x05, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x12, 0x2a,
+	0x0a, 0x02, 0x6f, 0x70, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x76,
+	0x65, 0x72, 0x6e, 0x6f, 0x72, 0x2e, 0x6a, 0x6f, 0x75, 0x72, 0x6e, 0x61, 0x6c, 0x2e, 0x45, 0x6e,
+	0x74, 0x72, 0x79, 0x2e, 0x4f, 0x70, 0x52, 0x02, 0x6f, 0x70, 0x12, 0x29, 0x0a, 0x05, 0x6c, 0x65,
+	0x61, 0x73, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x13, 0x2e, 0x67, 0x6f, 0x76, 0x65,
+	0x72, 0x6e, 0x6f, 0x72, 0x2e, 0x67, 0x70, 0x75, 0x2e, 0x4c, 0x65, 0x61, 0x73, 0x65, 0x52, 0x05,
+	0x6c, 0x65, 0x61, 0x73, 0x65, 0x22, 0x2f, 0x0a, 0x02, 0x4f, 0x70, 0x12, 0x0e, 0x0a, 0x0a, 0x4f,
+	0x50, 0x5f, 0x55, 0x4e, 0x4b, 0x4e, 0x4f, 0x57, 0x4e, 0x10, 0x00, 0x12, 0x0a, 0x0a, 0x06, 0x4f,
+	0x50, 0x5f, 0x50, 0x55, 0x54, 0x10, 0x01, 0x12, 0x0d, 0x0a, 0x09, 0x4f, 0x50, 0x5f, 0x44, 0x45,
+	0x4c, 0x45, 0x54, 0x45, 0x10, 0x02, 0x42, 0x36, 0x5a, 0x34, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62,
+	0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x6b, 0x65, 0x76, 0x6d, 0x6f, 0x33, 0x31, 0x34, 0x2f, 0x66, 0x65,
+	0x64, 0x74, 0x6f, 0x72, 0x63, 0x68, 0x2f, 0x67, 0x6f, 0x76, 0x65, 0x72, 0x6e, 0x6f, 0x72, 0x2f,
+	0x61, 0x70, 0x69, 0x2f, 0x67, 0x6f, 0x2f, 0x6a, 0x6f, 0x75, 0x72, 0x6e, 0x61, 0x6c, 0x62, 0x06,
+	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_api_journal_proto_rawDescOnce sync.Once
+	file_api_journal_proto_rawDescData = file_api_journal_proto_rawDesc
+)
+
+func file_api_journal_proto_rawDescGZIP() []byte {
+	file_api_journal_proto_rawDescOnce.Do(func() {
+		file_api_journal_proto_rawDescData = protoimpl.X.CompressGZIP(file_api_journal_proto_rawDescData)
+	})
+	return file_api_journal_proto_rawDescData
+}
+
+var file_api_journal_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_api_journal_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_api_journal_proto_goTypes = []interface{}{
+	(Entry_Op)(0),     // 0: governor.journal.Entry.Op
+	(*Entry)(nil),     // 1: governor.journal.Entry
+	(*gpu.Lease)(nil), // 2: governor.gpu.Lease
+}
+var file_api_journal_proto_depIdxs = []int32{
+	0, // 0: governor.journal.Entry.op:type_name -> governor.journal.Entry.Op
+	2, // 1: governor.journal.Entry.lease:type_name -> governor.gpu.Lease
+	2, // [2:2] is the sub-list for method output_type
+	2, // [2:2] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
+}
+
+func init() { file_api_journal_proto_init() }
+func file_api_journal_proto_init() {
+	if File_api_journal_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_api_journal_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Entry); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_api_journal_proto_rawDesc,
+			NumEnums:      1,
+			NumMessages:   1,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_api_journal_proto_goTypes,
+		DependencyIndexes: file_api_journal_proto_depIdxs,
+		EnumInfos:         file_api_journal_proto_enumTypes,
+		MessageInfos:      file_api_journal_proto_msgTypes,
+	}.Build()
+	File_api_journal_proto = out.File
+	file_api_journal_proto_rawDesc = nil
+	file_api_journal_proto_goTypes = nil
+	file_api_journal_proto_depIdxs = nil
+}
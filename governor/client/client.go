@@ -0,0 +1,59 @@
+// Package client is a Go client for the governor gRPC service.
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	gpb "github.com/kevmo314/fedtorch/governor/api/go/api"
+	gpupb "github.com/kevmo314/fedtorch/governor/api/go/gpu"
+)
+
+type O struct {
+	// Address is the host:port address of the governor gRPC service, e.g.
+	// as advertised in the governor p2p identity record.
+	Address string
+
+	// DialOptions are appended to the default dial options. By default,
+	// the client connects over an insecure channel.
+	DialOptions []grpc.DialOption
+}
+
+type C struct {
+	conn   *grpc.ClientConn
+	client gpb.GovernorClient
+}
+
+func New(ctx context.Context, o O) (*C, error) {
+	opts := append([]grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	}, o.DialOptions...)
+
+	conn, err := grpc.DialContext(ctx, o.Address, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("cannot dial governor at %v: %w", o.Address, err)
+	}
+	return &C{
+		conn:   conn,
+		client: gpb.NewGovernorClient(conn),
+	}, nil
+}
+
+// InternalAllocateGPU asks the remote governor to allocate capacity GPUs for
+// the input task. The allocation is all-or-nothing -- either all requested
+// GPUs are returned, or an error is returned and no GPUs are allocated.
+func (c *C) InternalAllocateGPU(ctx context.Context, task string, capacity int) ([]*gpupb.GPU, error) {
+	resp, err := c.client.InternalAllocateGPU(ctx, &gpb.InternalAllocateGPURequest{
+		TaskId:   task,
+		Capacity: int32(capacity),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetGpus(), nil
+}
+
+func (c *C) Close() error { return c.conn.Close() }
@@ -0,0 +1,51 @@
+//go:build cuda
+
+package gpu
+
+import (
+	"fmt"
+
+	"gorgonia.org/cu"
+
+	gpupb "github.com/kevmo314/fedtorch/governor/api/go/gpu"
+)
+
+func init() {
+	newCU = func() Discoverer { return CU{} }
+}
+
+// CU discovers GPUs via the CUDA driver API.
+type CU struct{}
+
+func (CU) Discover(host string) ([]*gpupb.GPU, error) {
+	n, err := cu.NumDevices()
+	if err != nil {
+		return nil, fmt.Errorf("cannot count CUDA devices: %w", err)
+	}
+
+	var devices []*gpupb.GPU
+	for d := 0; d < n; d++ {
+		dev := cu.Device(d)
+		name, err := dev.Name()
+		if err != nil {
+			return nil, fmt.Errorf("cannot get name of CUDA device %v: %w", d, err)
+		}
+		cr, err := dev.Attribute(cu.ClockRate)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get clock rate of CUDA device %v: %w", d, err)
+		}
+		mem, err := dev.TotalMem()
+		if err != nil {
+			return nil, fmt.Errorf("cannot get memory of CUDA device %v: %w", d, err)
+		}
+		devices = append(devices, &gpupb.GPU{
+			Host:      host,
+			Id:        int32(d),
+			Name:      name,
+			ClockRate: int32(cr),
+			Memory:    mem,
+		})
+	}
+
+	return devices, nil
+}
@@ -0,0 +1,61 @@
+package gpu
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+
+	gpupb "github.com/kevmo314/fedtorch/governor/api/go/gpu"
+)
+
+// File discovers GPUs from a static inventory file, e.g. for hosts whose GPUs
+// cannot be queried directly. The file is YAML (or JSON) of the form
+//
+//	gpus:
+//	  - id: 0
+//	    name: NVIDIA A100-SXM4-80GB
+//	    memory: 85899345920  # bytes
+//	    clock_rate: 1410000  # kHz
+type File struct {
+	Path string
+}
+
+type inventory struct {
+	GPUs []struct {
+		ID        int32  `yaml:"id"`
+		Name      string `yaml:"name"`
+		Memory    int64  `yaml:"memory"`
+		ClockRate int32  `yaml:"clock_rate"`
+	} `yaml:"gpus"`
+}
+
+func (f *File) Discover(host string) ([]*gpupb.GPU, error) {
+	data, err := os.ReadFile(f.Path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read GPU inventory: %w", err)
+	}
+
+	var inv inventory
+	if err := yaml.Unmarshal(data, &inv); err != nil {
+		return nil, fmt.Errorf("invalid GPU inventory %v: %w", f.Path, err)
+	}
+
+	ids := map[int32]bool{}
+	var devices []*gpupb.GPU
+	for _, g := range inv.GPUs {
+		if ids[g.ID] {
+			return nil, fmt.Errorf("invalid GPU inventory %v: duplicate GPU ID %v", f.Path, g.ID)
+		}
+		ids[g.ID] = true
+
+		devices = append(devices, &gpupb.GPU{
+			Host:      host,
+			Id:        g.ID,
+			Name:      g.Name,
+			Memory:    g.Memory,
+			ClockRate: g.ClockRate,
+		})
+	}
+	return devices, nil
+}
@@ -1,32 +1,99 @@
+// Package gpu discovers the GPUs attached to the local host.
+//
+// Several discovery backends are supported, and are selected at startup via
+// New. The cu backend links against the CUDA driver, and is only available
+// when built with the cuda build tag, e.g.
+//
+//	go build -tags cuda ./...
 package gpu
 
 import (
-	"gorgonia.org/cu"
+	"fmt"
 
 	gpupb "github.com/kevmo314/fedtorch/governor/api/go/gpu"
 )
 
-func Generate(host string) []*gpupb.GPU {
-	n, err := cu.NumDevices()
-	if err != nil {
-		return nil
+const (
+	BackendCU        = "cu"
+	BackendNvidiaSMI = "nvidia-smi"
+	BackendFile      = "file"
+)
+
+// Discoverer lists the GPUs attached to the local host. The input host is the
+// address of the governor, and is set on each returned GPU.
+type Discoverer interface {
+	Discover(host string) ([]*gpupb.GPU, error)
+}
+
+// newCU constructs the cu backend. newCU is nil unless built with the cuda
+// build tag.
+var newCU func() Discoverer
+
+type O struct {
+	// Backend is the discovery backend, i.e. one of BackendCU,
+	// BackendNvidiaSMI, or BackendFile. Defaults to BackendCU if the
+	// binary was built with the cuda build tag, and BackendNvidiaSMI
+	// otherwise.
+	Backend string
+
+	// Path is the path of the nvidia-smi binary for BackendNvidiaSMI, or
+	// of the inventory file for BackendFile.
+	Path string
+}
+
+// New constructs the discovery backend specified by the input options.
+func New(o O) (Discoverer, error) {
+	if o.Backend == "" {
+		o.Backend = BackendNvidiaSMI
+		if newCU != nil {
+			o.Backend = BackendCU
+		}
 	}
 
-	var devices []*gpupb.GPU
-	for d := 0; d < n; d++ {
-		dev := cu.Device(d)
-		name, _ := dev.Name()
-		cr, _ := dev.Attribute(cu.ClockRate)
-		mem, _ := dev.TotalMem()
-		g := &gpupb.GPU{
-			Addr:      host,
-			Id:        int32(d),
-			Name:      name,
-			ClockRate: int32(cr),
-			Memory:    mem,
+	switch o.Backend {
+	case BackendCU:
+		if newCU == nil {
+			return nil, fmt.Errorf("%v backend is unavailable, as the governor was not built with the cuda build tag", BackendCU)
 		}
-		devices = append(devices, g)
+		return newCU(), nil
+	case BackendNvidiaSMI:
+		return &SMI{Path: o.Path}, nil
+	case BackendFile:
+		if o.Path == "" {
+			return nil, fmt.Errorf("%v backend requires an inventory path", BackendFile)
+		}
+		return &File{Path: o.Path}, nil
+	default:
+		return nil, fmt.Errorf("unknown GPU discovery backend %v", o.Backend)
+	}
+}
+
+// Fake is a Discoverer which returns a fixed set of GPUs, for use in tests.
+type Fake struct {
+	GPUs []*gpupb.GPU
+
+	// Err, if set, is returned by Discover instead of the GPUs.
+	Err error
+}
+
+func (f *Fake) Discover(host string) ([]*gpupb.GPU, error) {
+	if f.Err != nil {
+		return nil, f.Err
 	}
+	return withHost(f.GPUs, host), nil
+}
 
+// withHost returns copies of the input GPUs with the host set.
+func withHost(gpus []*gpupb.GPU, host string) []*gpupb.GPU {
+	var devices []*gpupb.GPU
+	for _, g := range gpus {
+		devices = append(devices, &gpupb.GPU{
+			Host:      host,
+			Id:        g.GetId(),
+			Name:      g.GetName(),
+			Memory:    g.GetMemory(),
+			ClockRate: g.GetClockRate(),
+		})
+	}
 	return devices
 }
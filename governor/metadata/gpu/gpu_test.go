@@ -0,0 +1,242 @@
+package gpu
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	gpupb "github.com/kevmo314/fedtorch/governor/api/go/gpu"
+)
+
+const (
+	smiCSV = `0, NVIDIA A100-SXM4-80GB, 81920, 1410
+1, NVIDIA A100-SXM4-80GB, 81920, 1410
+`
+
+	smiXML = `<?xml version="1.0" ?>
+<nvidia_smi_log>
+	<driver_version>525.85.12</driver_version>
+	<gpu id="00000000:00:04.0">
+		<product_name>NVIDIA A100-SXM4-80GB</product_name>
+		<fb_memory_usage>
+			<total>81920 MiB</total>
+		</fb_memory_usage>
+		<max_clocks>
+			<sm_clock>1410 MHz</sm_clock>
+		</max_clocks>
+	</gpu>
+	<gpu id="00000000:00:05.0">
+		<product_name>NVIDIA A100-SXM4-80GB</product_name>
+		<fb_memory_usage>
+			<total>81920 MiB</total>
+		</fb_memory_usage>
+		<max_clocks>
+			<sm_clock>1410 MHz</sm_clock>
+		</max_clocks>
+	</gpu>
+</nvidia_smi_log>
+`
+
+	inventoryYAML = `gpus:
+  - id: 0
+    name: NVIDIA A100-SXM4-80GB
+    memory: 85899345920
+    clock_rate: 1410000
+  - id: 1
+    name: NVIDIA A100-SXM4-80GB
+    memory: 85899345920
+    clock_rate: 1410000
+`
+
+	inventoryJSON = `{"gpus": [
+	{"id": 0, "name": "NVIDIA A100-SXM4-80GB", "memory": 85899345920, "clock_rate": 1410000},
+	{"id": 1, "name": "NVIDIA A100-SXM4-80GB", "memory": 85899345920, "clock_rate": 1410000}
+]}`
+)
+
+func write(t *testing.T, name string, data string, mode os.FileMode) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), mode); err != nil {
+		t.Fatalf("WriteFile() unexpectedly failed: %v", err)
+	}
+	return path
+}
+
+// check verifies the input GPUs match the two A100s described by the test
+// fixtures.
+func check(t *testing.T, gpus []*gpupb.GPU) {
+	t.Helper()
+
+	if got := len(gpus); got != 2 {
+		t.Fatalf("len(Discover()) = %v, want = 2", got)
+	}
+	for i, g := range gpus {
+		if got, want := g.GetId(), int32(i); got != want {
+			t.Errorf("GetId() = %v, want = %v", got, want)
+		}
+		if got, want := g.GetHost(), "some-host"; got != want {
+			t.Errorf("GetHost() = %v, want = %v", got, want)
+		}
+		if got, want := g.GetName(), "NVIDIA A100-SXM4-80GB"; got != want {
+			t.Errorf("GetName() = %v, want = %v", got, want)
+		}
+		if got, want := g.GetMemory(), int64(80<<30); got != want {
+			t.Errorf("GetMemory() = %v, want = %v", got, want)
+		}
+		if got, want := g.GetClockRate(), int32(1410000); got != want {
+			t.Errorf("GetClockRate() = %v, want = %v", got, want)
+		}
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	configs := []struct {
+		name string
+		d    func(t *testing.T) Discoverer
+	}{
+		{
+			name: "SMI",
+			d: func(t *testing.T) Discoverer {
+				return &SMI{
+					Path: write(t, "nvidia-smi", fmt.Sprintf("#!/bin/sh\ncat <<EOF\n%sEOF\n", smiCSV), 0o755),
+				}
+			},
+		},
+		{
+			name: "SMI/XML",
+			d: func(t *testing.T) Discoverer {
+				return &SMI{
+					Path: write(t, "nvidia-smi", fmt.Sprintf("#!/bin/sh\ncat <<EOF\n%sEOF\n", smiXML), 0o755),
+					XML:  true,
+				}
+			},
+		},
+		{
+			name: "File/YAML",
+			d: func(t *testing.T) Discoverer {
+				return &File{Path: write(t, "gpus.yaml", inventoryYAML, 0o644)}
+			},
+		},
+		{
+			name: "File/JSON",
+			d: func(t *testing.T) Discoverer {
+				return &File{Path: write(t, "gpus.json", inventoryJSON, 0o644)}
+			},
+		},
+		{
+			name: "Fake",
+			d: func(t *testing.T) Discoverer {
+				return &Fake{
+					GPUs: []*gpupb.GPU{
+						&gpupb.GPU{Id: 0, Name: "NVIDIA A100-SXM4-80GB", Memory: 80 << 30, ClockRate: 1410000},
+						&gpupb.GPU{Id: 1, Name: "NVIDIA A100-SXM4-80GB", Memory: 80 << 30, ClockRate: 1410000},
+					},
+				}
+			},
+		},
+	}
+
+	for _, c := range configs {
+		t.Run(c.name, func(t *testing.T) {
+			gpus, err := c.d(t).Discover("some-host")
+			if err != nil {
+				t.Fatalf("Discover() unexpectedly failed: %v", err)
+			}
+			check(t, gpus)
+		})
+	}
+}
+
+func TestDiscoverError(t *testing.T) {
+	configs := []struct {
+		name string
+		d    func(t *testing.T) Discoverer
+		want string
+	}{
+		{
+			name: "SMI/Missing",
+			d: func(t *testing.T) Discoverer {
+				return &SMI{Path: filepath.Join(t.TempDir(), "nvidia-smi")}
+			},
+		},
+		{
+			name: "SMI/Failed",
+			d: func(t *testing.T) Discoverer {
+				return &SMI{
+					Path: write(t, "nvidia-smi", "#!/bin/sh\necho 'NVIDIA-SMI has failed' >&2\nexit 9\n", 0o755),
+				}
+			},
+			want: "NVIDIA-SMI has failed",
+		},
+		{
+			name: "SMI/Malformed",
+			d: func(t *testing.T) Discoverer {
+				return &SMI{
+					Path: write(t, "nvidia-smi", "#!/bin/sh\necho '0, NVIDIA A100, [N/A], 1410'\n", 0o755),
+				}
+			},
+		},
+		{
+			name: "File/Missing",
+			d: func(t *testing.T) Discoverer {
+				return &File{Path: filepath.Join(t.TempDir(), "gpus.yaml")}
+			},
+		},
+		{
+			name: "File/Duplicate",
+			d: func(t *testing.T) Discoverer {
+				return &File{Path: write(t, "gpus.yaml", "gpus:\n  - id: 0\n  - id: 0\n", 0o644)}
+			},
+			want: "duplicate",
+		},
+		{
+			name: "Fake",
+			d: func(t *testing.T) Discoverer {
+				return &Fake{Err: fmt.Errorf("some-error")}
+			},
+			want: "some-error",
+		},
+	}
+
+	for _, c := range configs {
+		t.Run(c.name, func(t *testing.T) {
+			gpus, err := c.d(t).Discover("some-host")
+			if err == nil {
+				t.Fatalf("Discover() unexpectedly succeeded: %v", gpus)
+			}
+			if !strings.Contains(err.Error(), c.want) {
+				t.Errorf("Discover() = %v, want error containing %q", err, c.want)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	configs := []struct {
+		name string
+		o    O
+		succ bool
+	}{
+		{name: "Default", o: O{}, succ: true},
+		{name: "NvidiaSMI", o: O{Backend: BackendNvidiaSMI}, succ: true},
+		{name: "File", o: O{Backend: BackendFile, Path: "gpus.yaml"}, succ: true},
+		{name: "File/NoPath", o: O{Backend: BackendFile}, succ: false},
+		{name: "CU", o: O{Backend: BackendCU}, succ: newCU != nil},
+		{name: "Unknown", o: O{Backend: "some-backend"}, succ: false},
+	}
+
+	for _, c := range configs {
+		t.Run(c.name, func(t *testing.T) {
+			d, err := New(c.o)
+			if c.succ && err != nil {
+				t.Errorf("New() unexpectedly failed: %v", err)
+			} else if !c.succ && err == nil {
+				t.Errorf("New() unexpectedly succeeded: %v", d)
+			}
+		})
+	}
+}
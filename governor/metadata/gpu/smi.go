@@ -0,0 +1,167 @@
+package gpu
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	gpupb "github.com/kevmo314/fedtorch/governor/api/go/gpu"
+)
+
+const (
+	defaultSMIPath = "nvidia-smi"
+
+	// smiQuery is the nvidia-smi query parsed by ParseCSV.
+	smiQuery = "index,name,memory.total,clocks.max.sm"
+)
+
+// SMI discovers GPUs by querying the nvidia-smi tool. Unlike CU, SMI does not
+// require the governor to link against the CUDA driver.
+//
+// Device IDs are the nvidia-smi device indices, which follow PCI bus order.
+// Set CUDA_DEVICE_ORDER=PCI_BUS_ID so that CUDA device IDs match.
+type SMI struct {
+	// Path is the nvidia-smi binary. Defaults to nvidia-smi on the PATH.
+	Path string
+
+	// XML queries the full nvidia-smi XML report instead of the CSV
+	// query output, e.g. for older drivers.
+	XML bool
+}
+
+func (s *SMI) Discover(host string) ([]*gpupb.GPU, error) {
+	path := s.Path
+	if path == "" {
+		path = defaultSMIPath
+	}
+
+	args := []string{"--query-gpu=" + smiQuery, "--format=csv,noheader,nounits"}
+	if s.XML {
+		args = []string{"-q", "-x"}
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(path, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("cannot query %v: %w: %s", path, err, strings.TrimSpace(stderr.String()))
+	}
+
+	if s.XML {
+		return ParseXML(&stdout, host)
+	}
+	return ParseCSV(&stdout, host)
+}
+
+// ParseCSV parses the output of
+//
+//	nvidia-smi --query-gpu=index,name,memory.total,clocks.max.sm --format=csv,noheader,nounits
+//
+// nvidia-smi reports memory in MiB and clock rates in MHz; these are converted
+// to bytes and kHz respectively, to match the units reported by CUDA.
+func ParseCSV(r io.Reader, host string) ([]*gpupb.GPU, error) {
+	c := csv.NewReader(r)
+	c.TrimLeadingSpace = true
+	c.FieldsPerRecord = strings.Count(smiQuery, ",") + 1
+
+	records, err := c.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid nvidia-smi output: %w", err)
+	}
+
+	var devices []*gpupb.GPU
+	for _, rec := range records {
+		id, err := strconv.ParseInt(rec[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid nvidia-smi device index %q: %w", rec[0], err)
+		}
+		mem, err := strconv.ParseInt(rec[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid nvidia-smi memory %q: %w", rec[2], err)
+		}
+		clock, err := strconv.ParseInt(rec[3], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid nvidia-smi clock rate %q: %w", rec[3], err)
+		}
+		devices = append(devices, &gpupb.GPU{
+			Host:      host,
+			Id:        int32(id),
+			Name:      rec[1],
+			Memory:    mem << 20,
+			ClockRate: int32(clock * 1000),
+		})
+	}
+	return devices, nil
+}
+
+// smiLog is the subset of the nvidia-smi -q -x report parsed by ParseXML.
+type smiLog struct {
+	GPUs []struct {
+		ProductName string `xml:"product_name"`
+		Memory      string `xml:"fb_memory_usage>total"`
+		ClockRate   string `xml:"max_clocks>sm_clock"`
+	} `xml:"gpu"`
+}
+
+// ParseXML parses the output of
+//
+//	nvidia-smi -q -x
+//
+// Devices are assigned IDs in report order, which matches the nvidia-smi
+// device index.
+func ParseXML(r io.Reader, host string) ([]*gpupb.GPU, error) {
+	var l smiLog
+	if err := xml.NewDecoder(r).Decode(&l); err != nil {
+		return nil, fmt.Errorf("invalid nvidia-smi report: %w", err)
+	}
+
+	var devices []*gpupb.GPU
+	for i, g := range l.GPUs {
+		mem, err := quantity(g.Memory, map[string]int64{
+			"MiB": 1 << 20,
+			"GiB": 1 << 30,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("invalid nvidia-smi memory %q: %w", g.Memory, err)
+		}
+		clock, err := quantity(g.ClockRate, map[string]int64{
+			"MHz": 1000,
+			"GHz": 1000000,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("invalid nvidia-smi clock rate %q: %w", g.ClockRate, err)
+		}
+		devices = append(devices, &gpupb.GPU{
+			Host:      host,
+			Id:        int32(i),
+			Name:      g.ProductName,
+			Memory:    mem,
+			ClockRate: int32(clock),
+		})
+	}
+	return devices, nil
+}
+
+// quantity parses a value of the form "<n> <unit>", e.g. "81920 MiB", and
+// returns n scaled by the unit multiplier.
+func quantity(s string, units map[string]int64) (int64, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return 0, fmt.Errorf("expected a value and a unit")
+	}
+	m, ok := units[fields[1]]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit %v", fields[1])
+	}
+	n, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return n * m, nil
+}
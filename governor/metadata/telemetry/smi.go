@@ -0,0 +1,118 @@
+package telemetry
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	gpupb "github.com/kevmo314/fedtorch/governor/api/go/gpu"
+	tpb "google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	defaultSMIPath = "nvidia-smi"
+
+	// smiQuery is the nvidia-smi query parsed by ParseCSV.
+	smiQuery = "index,memory.free,utilization.gpu,temperature.gpu,power.draw,ecc.errors.uncorrected.volatile.total"
+)
+
+// SMI samples GPU telemetry by querying the nvidia-smi tool.
+type SMI struct {
+	// Path is the nvidia-smi binary. Defaults to nvidia-smi on the PATH.
+	Path string
+}
+
+func (s *SMI) Sample() (map[int32]*gpupb.Telemetry, error) {
+	path := s.Path
+	if path == "" {
+		path = defaultSMIPath
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(path, "--query-gpu="+smiQuery, "--format=csv,noheader,nounits")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("cannot query %v: %w: %s", path, err, strings.TrimSpace(stderr.String()))
+	}
+	return ParseCSV(&stdout, time.Now())
+}
+
+// ParseCSV parses the output of
+//
+//	nvidia-smi --query-gpu=index,memory.free,utilization.gpu,temperature.gpu,power.draw,ecc.errors.uncorrected.volatile.total --format=csv,noheader,nounits
+//
+// Free memory is converted from MiB to bytes. Metrics which the device does
+// not support, e.g. ECC counters on consumer GPUs, are reported by nvidia-smi
+// as "[N/A]" and are left unset.
+func ParseCSV(r io.Reader, sampled time.Time) (map[int32]*gpupb.Telemetry, error) {
+	c := csv.NewReader(r)
+	c.TrimLeadingSpace = true
+	c.FieldsPerRecord = strings.Count(smiQuery, ",") + 1
+
+	records, err := c.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid nvidia-smi output: %w", err)
+	}
+
+	samples := map[int32]*gpupb.Telemetry{}
+	for _, rec := range records {
+		id, err := strconv.ParseInt(rec[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid nvidia-smi device index %q: %w", rec[0], err)
+		}
+		free, err := parseInt(rec[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid nvidia-smi free memory %q: %w", rec[1], err)
+		}
+		util, err := parseInt(rec[2], 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid nvidia-smi utilization %q: %w", rec[2], err)
+		}
+		temp, err := parseInt(rec[3], 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid nvidia-smi temperature %q: %w", rec[3], err)
+		}
+		power, err := parseFloat(rec[4])
+		if err != nil {
+			return nil, fmt.Errorf("invalid nvidia-smi power draw %q: %w", rec[4], err)
+		}
+		ecc, err := parseInt(rec[5], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid nvidia-smi ECC error count %q: %w", rec[5], err)
+		}
+		samples[int32(id)] = &gpupb.Telemetry{
+			FreeMemory:  free << 20,
+			Utilization: int32(util),
+			Temperature: int32(temp),
+			Power:       power,
+			EccErrors:   ecc,
+			Sampled:     tpb.New(sampled),
+		}
+	}
+	return samples, nil
+}
+
+// unsupported checks if nvidia-smi reported the metric as unavailable.
+func unsupported(s string) bool {
+	return s == "[N/A]" || s == "[Not Supported]"
+}
+
+func parseInt(s string, bits int) (int64, error) {
+	if unsupported(s) {
+		return 0, nil
+	}
+	return strconv.ParseInt(s, 10, bits)
+}
+
+func parseFloat(s string) (float64, error) {
+	if unsupported(s) {
+		return 0, nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
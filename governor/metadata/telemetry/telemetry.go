@@ -0,0 +1,197 @@
+// Package telemetry periodically samples the health and utilization of the
+// GPUs attached to the local host.
+package telemetry
+
+import (
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	gpupb "github.com/kevmo314/fedtorch/governor/api/go/gpu"
+)
+
+const (
+	defaultInterval       = 10 * time.Second
+	defaultMaxTemperature = 90
+)
+
+// Sampler reports the current telemetry of the local GPUs, keyed by device
+// ID.
+type Sampler interface {
+	Sample() (map[int32]*gpupb.Telemetry, error)
+}
+
+type O struct {
+	Sampler Sampler
+
+	// Interval is the time between samples. Defaults to 10s.
+	Interval time.Duration
+
+	// MaxAge is the age after which a sample is considered stale, e.g.
+	// if the sampler has since started failing. Stale samples are not
+	// reported. Defaults to three sampling intervals.
+	MaxAge time.Duration
+
+	// MaxTemperature is the temperature in degrees Celsius above which
+	// a GPU is considered unhealthy. Defaults to 90C.
+	MaxTemperature int32
+}
+
+// M monitors the telemetry of the local GPUs.
+type M struct {
+	sampler        Sampler
+	interval       time.Duration
+	maxAge         time.Duration
+	maxTemperature int32
+
+	l       sync.Mutex
+	samples map[int32]*gpupb.Telemetry
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func New(o O) *M {
+	if o.Interval <= 0 {
+		o.Interval = defaultInterval
+	}
+	if o.MaxAge <= 0 {
+		o.MaxAge = 3 * o.Interval
+	}
+	if o.MaxTemperature <= 0 {
+		o.MaxTemperature = defaultMaxTemperature
+	}
+	return &M{
+		sampler:        o.Sampler,
+		interval:       o.Interval,
+		maxAge:         o.MaxAge,
+		maxTemperature: o.MaxTemperature,
+		samples:        make(map[int32]*gpupb.Telemetry),
+	}
+}
+
+// Sample immediately samples the GPU telemetry. On failure, the previous
+// samples are kept until they become stale.
+func (m *M) Sample() error {
+	samples, err := m.sampler.Sample()
+	if err != nil {
+		return err
+	}
+
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	for id, t := range samples {
+		m.samples[id] = t
+	}
+	return nil
+}
+
+// Start samples the GPU telemetry once, and then periodically in the
+// background until Stop is called.
+func (m *M) Start() error {
+	err := m.Sample()
+
+	m.stop = make(chan struct{})
+	m.done = make(chan struct{})
+	go func() {
+		defer close(m.done)
+
+		t := time.NewTicker(m.interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-m.stop:
+				return
+			case <-t.C:
+				// Transient failures are tolerated; samples
+				// which are not refreshed eventually expire.
+				m.Sample()
+			}
+		}
+	}()
+	return err
+}
+
+// Stop stops the background sampling started by Start.
+func (m *M) Stop() {
+	if m.stop == nil {
+		return
+	}
+	close(m.stop)
+	<-m.done
+	m.stop = nil
+}
+
+// Get returns the latest unexpired telemetry of the input GPU, or nil if no
+// such sample exists.
+func (m *M) Get(id int32) *gpupb.Telemetry {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	t, ok := m.samples[id]
+	if !ok || time.Since(t.GetSampled().AsTime()) > m.maxAge {
+		return nil
+	}
+	return t
+}
+
+// Annotate returns copies of the input GPUs with their latest telemetry
+// attached.
+func (m *M) Annotate(gpus []*gpupb.GPU) []*gpupb.GPU {
+	annotated := make([]*gpupb.GPU, 0, len(gpus))
+	for _, g := range gpus {
+		h := proto.Clone(g).(*gpupb.GPU)
+		h.Telemetry = m.Get(g.GetId())
+		annotated = append(annotated, h)
+	}
+	return annotated
+}
+
+// Healthy checks if the input GPU should be leased out, based on its attached
+// telemetry. GPUs which are overheating or which have reported uncorrectable
+// memory errors are unhealthy. GPUs without telemetry are assumed healthy.
+func (m *M) Healthy(g *gpupb.GPU) bool {
+	t := g.GetTelemetry()
+	if t == nil {
+		return true
+	}
+	return t.GetTemperature() <= m.maxTemperature && t.GetEccErrors() == 0
+}
+
+// Available returns the healthy subset of the input GPUs, annotated with
+// their latest telemetry.
+func (m *M) Available(gpus []*gpupb.GPU) []*gpupb.GPU {
+	var available []*gpupb.GPU
+	for _, g := range m.Annotate(gpus) {
+		if m.Healthy(g) {
+			available = append(available, g)
+		}
+	}
+	return available
+}
+
+// Fake is a Sampler which returns a fixed set of samples, for use in tests.
+type Fake struct {
+	l       sync.Mutex
+	samples map[int32]*gpupb.Telemetry
+	err     error
+}
+
+// Set sets the samples and error returned by subsequent calls to Sample. The
+// sampled timestamp is left as-is.
+func (f *Fake) Set(samples map[int32]*gpupb.Telemetry, err error) {
+	f.l.Lock()
+	defer f.l.Unlock()
+
+	f.samples = samples
+	f.err = err
+}
+
+func (f *Fake) Sample() (map[int32]*gpupb.Telemetry, error) {
+	f.l.Lock()
+	defer f.l.Unlock()
+
+	return f.samples, f.err
+}
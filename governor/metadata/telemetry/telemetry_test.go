@@ -0,0 +1,119 @@
+package telemetry
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	gpupb "github.com/kevmo314/fedtorch/governor/api/go/gpu"
+	tpb "google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestParseCSV(t *testing.T) {
+	configs := []struct {
+		name string
+		data string
+		want map[int32]*gpupb.Telemetry
+		succ bool
+	}{
+		{
+			name: "Datacenter",
+			data: "0, 40960, 87, 65, 250.51, 0\n1, 81920, 0, 34, 61.20, 3\n",
+			want: map[int32]*gpupb.Telemetry{
+				0: &gpupb.Telemetry{FreeMemory: 40 << 30, Utilization: 87, Temperature: 65, Power: 250.51},
+				1: &gpupb.Telemetry{FreeMemory: 80 << 30, Temperature: 34, Power: 61.2, EccErrors: 3},
+			},
+			succ: true,
+		},
+		{
+			name: "Unsupported",
+			data: "0, 7680, 12, 50, [N/A], [N/A]\n",
+			want: map[int32]*gpupb.Telemetry{
+				0: &gpupb.Telemetry{FreeMemory: 7680 << 20, Utilization: 12, Temperature: 50},
+			},
+			succ: true,
+		},
+		{
+			name: "Malformed",
+			data: "0, 40960, 87, hot, 250.51, 0\n",
+			succ: false,
+		},
+		{
+			name: "Truncated",
+			data: "0, 40960, 87\n",
+			succ: false,
+		},
+	}
+
+	for _, c := range configs {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := ParseCSV(strings.NewReader(c.data), time.Now())
+			if !c.succ {
+				if err == nil {
+					t.Errorf("ParseCSV() unexpectedly succeeded: %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseCSV() unexpectedly failed: %v", err)
+			}
+			if len(got) != len(c.want) {
+				t.Fatalf("len(ParseCSV()) = %v, want = %v", len(got), len(c.want))
+			}
+			for id, w := range c.want {
+				g := got[id]
+				if g.GetFreeMemory() != w.GetFreeMemory() || g.GetUtilization() != w.GetUtilization() || g.GetTemperature() != w.GetTemperature() || g.GetPower() != w.GetPower() || g.GetEccErrors() != w.GetEccErrors() {
+					t.Errorf("ParseCSV()[%v] = %v, want = %v", id, g, w)
+				}
+			}
+		})
+	}
+}
+
+func TestMonitor(t *testing.T) {
+	gpus := []*gpupb.GPU{
+		&gpupb.GPU{Id: 0},
+		&gpupb.GPU{Id: 1},
+		&gpupb.GPU{Id: 2},
+		&gpupb.GPU{Id: 3},
+	}
+
+	s := &Fake{}
+	s.Set(map[int32]*gpupb.Telemetry{
+		0: &gpupb.Telemetry{Temperature: 60, Sampled: tpb.Now()},
+		1: &gpupb.Telemetry{Temperature: 95, Sampled: tpb.Now()},
+		2: &gpupb.Telemetry{Temperature: 60, EccErrors: 1, Sampled: tpb.Now()},
+		// Stale samples are ignored.
+		3: &gpupb.Telemetry{Temperature: 95, Sampled: tpb.New(time.Now().Add(-time.Hour))},
+	}, nil)
+	m := New(O{Sampler: s, Interval: time.Minute})
+	if err := m.Start(); err != nil {
+		t.Fatalf("Start() unexpectedly failed: %v", err)
+	}
+	defer m.Stop()
+
+	var got []int32
+	for _, g := range m.Available(gpus) {
+		got = append(got, g.GetId())
+	}
+	if want := []int32{0, 3}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Available() = %v, want = %v", got, want)
+	}
+
+	if m.Get(3) != nil {
+		t.Errorf("Get() = %v, want = nil", m.Get(3))
+	}
+	if gpus[0].GetTelemetry() != nil {
+		t.Errorf("Annotate() unexpectedly modified the input GPU: %v", gpus[0])
+	}
+
+	// Failed samples do not clear previous samples.
+	s.Set(nil, fmt.Errorf("some-error"))
+	if err := m.Sample(); err == nil {
+		t.Errorf("Sample() unexpectedly succeeded")
+	}
+	if got := m.Get(0).GetTemperature(); got != 60 {
+		t.Errorf("GetTemperature() = %v, want = 60", got)
+	}
+}
@@ -0,0 +1,136 @@
+package p2p
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoremem"
+	"github.com/libp2p/go-libp2p/p2p/muxer/yamux"
+	"github.com/libp2p/go-libp2p/p2p/net/swarm"
+	"github.com/libp2p/go-libp2p/p2p/net/upgrader"
+	"github.com/libp2p/go-libp2p/p2p/security/noise"
+	"github.com/libp2p/go-libp2p/p2p/transport/tcp"
+	"github.com/multiformats/go-multiaddr"
+
+	bhost "github.com/libp2p/go-libp2p/p2p/host/basic"
+	msmux "github.com/libp2p/go-libp2p/p2p/muxer/muxer-multistream"
+	csms "github.com/libp2p/go-libp2p/p2p/net/conn-security-multistream"
+)
+
+// NewHost constructs a TCP-only libp2p host listening on the input
+// multiaddr.
+//
+// We assemble the host by hand instead of calling libp2p.New, as the default
+// transport set pulls in a QUIC implementation which is pinned to specific Go
+// toolchain versions, and which governors do not need.
+func NewHost(listen string) (host.Host, error) {
+	priv, _, err := crypto.GenerateEd25519Key(nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot generate host key: %w", err)
+	}
+	return newHost(listen, priv)
+}
+
+// LoadKey reads the host identity key stored at the input path, generating and
+// storing a fresh key if the file does not exist, so that the peer ID of a
+// governor survives a restart.
+func LoadKey(path string) (crypto.PrivKey, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		priv, err := crypto.UnmarshalPrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid host key %v: %w", path, err)
+		}
+		return priv, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("cannot read host key %v: %w", path, err)
+	}
+
+	priv, _, err := crypto.GenerateEd25519Key(nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot generate host key: %w", err)
+	}
+	data, err = crypto.MarshalPrivateKey(priv)
+	if err != nil {
+		return nil, fmt.Errorf("cannot marshal host key: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return nil, fmt.Errorf("cannot write host key %v: %w", path, err)
+	}
+	return priv, nil
+}
+
+// newHost constructs a TCP-only libp2p host with the input identity key.
+func newHost(listen string, priv crypto.PrivKey) (host.Host, error) {
+	id, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		return nil, fmt.Errorf("cannot derive peer ID: %w", err)
+	}
+
+	ps, err := pstoremem.NewPeerstore()
+	if err != nil {
+		return nil, fmt.Errorf("cannot construct peerstore: %w", err)
+	}
+	if err := ps.AddPrivKey(id, priv); err != nil {
+		return nil, fmt.Errorf("cannot add private key to peerstore: %w", err)
+	}
+	if err := ps.AddPubKey(id, priv.GetPublic()); err != nil {
+		return nil, fmt.Errorf("cannot add public key to peerstore: %w", err)
+	}
+
+	s, err := swarm.NewSwarm(id, ps)
+	if err != nil {
+		return nil, fmt.Errorf("cannot construct swarm: %w", err)
+	}
+
+	n, err := noise.New(priv)
+	if err != nil {
+		s.Close()
+		return nil, fmt.Errorf("cannot construct security transport: %w", err)
+	}
+	sm := &csms.SSMuxer{}
+	sm.AddTransport(noise.ID, n)
+
+	mm := msmux.NewBlankTransport()
+	mm.AddTransport("/yamux/1.0.0", yamux.DefaultTransport)
+
+	u, err := upgrader.New(sm, mm)
+	if err != nil {
+		s.Close()
+		return nil, fmt.Errorf("cannot construct upgrader: %w", err)
+	}
+	t, err := tcp.NewTCPTransport(u, nil)
+	if err != nil {
+		s.Close()
+		return nil, fmt.Errorf("cannot construct TCP transport: %w", err)
+	}
+	if err := s.AddTransport(t); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("cannot add TCP transport: %w", err)
+	}
+
+	m, err := multiaddr.NewMultiaddr(listen)
+	if err != nil {
+		s.Close()
+		return nil, fmt.Errorf("invalid listen address %v: %w", listen, err)
+	}
+	if err := s.Listen(m); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("cannot listen on %v: %w", m, err)
+	}
+
+	h, err := bhost.NewHost(s, nil)
+	if err != nil {
+		s.Close()
+		return nil, fmt.Errorf("cannot construct host: %w", err)
+	}
+	h.Start()
+
+	return h, nil
+}
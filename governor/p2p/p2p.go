@@ -0,0 +1,434 @@
+// Package p2p tracks the other governors on the network.
+//
+// Governors find one another through the BitTorrent mainline DHT, which only
+// stores IP:port pairs for a given infohash. Since libp2p needs to know the
+// peer ID of a host before it can dial it, each governor additionally serves
+// its identity record over TCP on the same port number as the (UDP) DHT
+// node. Discovering a peer therefore consists of
+//
+//  1. looking up the rendezvous infohash in the DHT,
+//  2. exchanging identity records with each returned address, and
+//  3. dialing the libp2p host described by the record.
+package p2p
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/nictuku/dht"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+
+	gpupb "github.com/kevmo314/fedtorch/governor/api/go/gpu"
+	ppb "github.com/kevmo314/fedtorch/governor/api/go/p2p"
+)
+
+const (
+	// Rendezvous is the DHT key under which all governors announce
+	// themselves.
+	Rendezvous = "fedtorch/governor"
+
+	// maxRecordSize bounds the size of an identity record we are willing
+	// to read from a remote governor.
+	maxRecordSize = 1 << 20
+
+	defaultInterval = time.Minute
+	dialTimeout     = 10 * time.Second
+)
+
+type O struct {
+	Address string
+	Port    int
+
+	// Routers is a list of host:port DHT nodes used to bootstrap the
+	// store into the network. If empty, the store will only find peers
+	// which contact it first.
+	Routers []string
+
+	// Interval is how often the store will query the DHT for new peers.
+	// Defaults to one minute.
+	Interval time.Duration
+
+	// Key is the identity key of the libp2p host, e.g. as returned by
+	// LoadKey. If nil, a fresh key is generated on Start.
+	Key crypto.PrivKey
+}
+
+// Store is a view of the other governors on the network.
+//
+// The zero value is not usable; construct with New. A Store may be started
+// at most once.
+type Store struct {
+	o O
+
+	host  host.Host
+	dht   *dht.DHT
+	ident net.Listener
+
+	l       sync.Mutex
+	started bool
+	gpus    []*gpupb.GPU
+	addr    string
+	peers   map[peer.ID]*ppb.Peer
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// halted is closed once the DHT node has stopped.
+	halted chan struct{}
+	wg     sync.WaitGroup
+}
+
+// New constructs a Store. No network resources are acquired until Start is
+// called, so callers may safely Announce before then.
+func New(o O) *Store {
+	if o.Interval == 0 {
+		o.Interval = defaultInterval
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Store{
+		o:      o,
+		peers:  make(map[peer.ID]*ppb.Peer),
+		ctx:    ctx,
+		cancel: cancel,
+		halted: make(chan struct{}),
+	}
+}
+
+// Start binds the DHT node, identity listener and libp2p host, and begins
+// periodically searching for peers.
+func (s *Store) Start() error {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	if s.started {
+		return fmt.Errorf("p2p store has already been started")
+	}
+
+	ident, err := net.Listen("tcp", net.JoinHostPort(s.o.Address, strconv.Itoa(s.o.Port)))
+	if err != nil {
+		return fmt.Errorf("cannot listen for identity requests: %w", err)
+	}
+	port := ident.Addr().(*net.TCPAddr).Port
+
+	var h host.Host
+	if s.o.Key == nil {
+		h, err = NewHost(fmt.Sprintf("/ip4/%v/tcp/0", s.ip()))
+	} else {
+		h, err = newHost(fmt.Sprintf("/ip4/%v/tcp/0", s.ip()), s.o.Key)
+	}
+	if err != nil {
+		ident.Close()
+		return fmt.Errorf("cannot construct libp2p host: %w", err)
+	}
+
+	c := dht.NewConfig()
+	c.Address = s.o.Address
+	c.Port = port
+	c.DHTRouters = ""
+	for i, r := range s.o.Routers {
+		if i > 0 {
+			c.DHTRouters += ","
+		}
+		c.DHTRouters += r
+	}
+	c.SaveRoutingTable = false
+	c.RateLimit = -1
+
+	d, err := dht.New(c)
+	if err != nil {
+		ident.Close()
+		h.Close()
+		return fmt.Errorf("cannot construct DHT node: %w", err)
+	}
+	if err := d.Start(); err != nil {
+		ident.Close()
+		h.Close()
+		return fmt.Errorf("cannot start DHT node: %w", err)
+	}
+
+	s.ident = ident
+	s.host = h
+	s.dht = d
+	s.o.Port = port
+	s.started = true
+
+	s.wg.Add(3)
+	go s.serve()
+	go s.announce()
+	go s.discover()
+
+	return nil
+}
+
+// Stop shuts down all network resources held by the store, and blocks until
+// all background goroutines, including those of the DHT node, have exited and
+// the DHT socket has been closed.
+func (s *Store) Stop() {
+	s.l.Lock()
+	if !s.started || s.ctx.Err() != nil {
+		s.l.Unlock()
+		return
+	}
+	s.cancel()
+	s.l.Unlock()
+
+	s.ident.Close()
+
+	// dht.Stop blocks until the DHT loop has exited and closed its socket,
+	// but the loop may itself be blocked on an unbuffered send of peer
+	// results, e.g. while handling an announce from another node. discover
+	// therefore keeps draining results until halted is closed, i.e. until
+	// dht.Stop has returned.
+	s.dht.Stop()
+	close(s.halted)
+
+	s.wg.Wait()
+	s.host.Close()
+}
+
+// Announce sets the list of GPUs which this governor advertises to its
+// peers. Announce may be called before the store is started.
+func (s *Store) Announce(gpus []*gpupb.GPU) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	s.gpus = gpus
+}
+
+// AnnounceAddr sets the host:port address of the local governor gRPC service
+// advertised to peers. AnnounceAddr may be called before the store is started.
+func (s *Store) AnnounceAddr(addr string) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	s.addr = addr
+}
+
+// Host returns the underlying libp2p host. Host returns nil if the store has
+// not been started.
+func (s *Store) Host() host.Host {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	return s.host
+}
+
+// Port returns the port the store is listening on. This is only different
+// from the input port if the store was constructed with port 0.
+func (s *Store) Port() int {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	return s.o.Port
+}
+
+// Peers returns the identity records of all known remote governors.
+func (s *Store) Peers() []*ppb.Peer {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	var peers []*ppb.Peer
+	for _, p := range s.peers {
+		peers = append(peers, p)
+	}
+	return peers
+}
+
+// Connect exchanges identity records with the governor listening at the
+// input host:port address and dials its libp2p host.
+func (s *Store) Connect(ctx context.Context, addr string) (*ppb.Peer, error) {
+	if s.Host() == nil {
+		return nil, fmt.Errorf("p2p store has not been started")
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("cannot dial governor at %v: %w", addr, err)
+	}
+	defer conn.Close()
+
+	p, err := s.exchange(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.add(ctx, p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// add dials the libp2p host described by the input identity record and
+// tracks the record as a known peer.
+func (s *Store) add(ctx context.Context, p *ppb.Peer) error {
+	id, err := peer.Decode(p.GetId())
+	if err != nil {
+		return fmt.Errorf("invalid peer ID %v: %w", p.GetId(), err)
+	}
+
+	h := s.Host()
+	if id == h.ID() {
+		return fmt.Errorf("cannot connect to self")
+	}
+
+	info := peer.AddrInfo{ID: id}
+	for _, a := range p.GetAddrs() {
+		m, err := multiaddr.NewMultiaddr(a)
+		if err != nil {
+			continue
+		}
+		info.Addrs = append(info.Addrs, m)
+	}
+	if err := h.Connect(ctx, info); err != nil {
+		return fmt.Errorf("cannot connect to peer %v: %w", id, err)
+	}
+
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	s.peers[id] = p
+	return nil
+}
+
+func (s *Store) record() *ppb.Peer {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	p := &ppb.Peer{
+		Id:       s.host.ID().String(),
+		Gpus:     s.gpus,
+		GrpcAddr: s.addr,
+	}
+	for _, a := range s.host.Addrs() {
+		p.Addrs = append(p.Addrs, a.String())
+	}
+	return p
+}
+
+// exchange writes the local identity record to the connection and reads
+// back the remote identity record. The exchange is symmetric, so that the
+// governor which accepted the connection also learns of the dialer.
+func (s *Store) exchange(ctx context.Context, conn net.Conn) (*ppb.Peer, error) {
+	deadline := time.Now().Add(dialTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	data, err := proto.Marshal(s.record())
+	if err != nil {
+		return nil, fmt.Errorf("cannot marshal identity record: %w", err)
+	}
+	if _, err := conn.Write(append(protowire.AppendVarint(nil, uint64(len(data))), data...)); err != nil {
+		return nil, fmt.Errorf("cannot write identity record to %v: %w", conn.RemoteAddr(), err)
+	}
+
+	r := bufio.NewReader(conn)
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read identity record from %v: %w", conn.RemoteAddr(), err)
+	}
+	if n > maxRecordSize {
+		return nil, fmt.Errorf("identity record from %v exceeds maximum size: %v > %v", conn.RemoteAddr(), n, maxRecordSize)
+	}
+	data = make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, fmt.Errorf("cannot read identity record from %v: %w", conn.RemoteAddr(), err)
+	}
+
+	p := &ppb.Peer{}
+	if err := proto.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("invalid identity record from %v: %w", conn.RemoteAddr(), err)
+	}
+	return p, nil
+}
+
+// serve exchanges identity records with each incoming connection.
+func (s *Store) serve() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.ident.Accept()
+		if err != nil {
+			return
+		}
+
+		s.wg.Add(1)
+		go func(conn net.Conn) {
+			defer s.wg.Done()
+			defer conn.Close()
+
+			ctx, cancel := context.WithTimeout(s.ctx, dialTimeout)
+			defer cancel()
+
+			if p, err := s.exchange(ctx, conn); err == nil {
+				s.add(ctx, p)
+			}
+		}(conn)
+	}
+}
+
+// announce periodically asks the DHT for other governors, and advertises the
+// local governor as a peer of the rendezvous key.
+func (s *Store) announce() {
+	defer s.wg.Done()
+
+	ih := infohash()
+	for {
+		s.dht.PeersRequestPort(ih, true, s.Port())
+
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-time.After(s.o.Interval):
+		}
+	}
+}
+
+// discover connects to each governor address returned by the DHT.
+func (s *Store) discover() {
+	defer s.wg.Done()
+
+	for {
+		select {
+		case <-s.halted:
+			return
+		case r := <-s.dht.PeersRequestResults:
+			for _, addrs := range r {
+				for _, a := range addrs {
+					if s.ctx.Err() != nil {
+						break
+					}
+					ctx, cancel := context.WithTimeout(s.ctx, dialTimeout)
+					s.Connect(ctx, dht.DecodePeerAddress(a))
+					cancel()
+				}
+			}
+		}
+	}
+}
+
+func (s *Store) ip() string {
+	if s.o.Address == "" {
+		return "0.0.0.0"
+	}
+	return s.o.Address
+}
+
+func infohash() string {
+	h := sha1.Sum([]byte(Rendezvous))
+	return string(h[:])
+}
@@ -0,0 +1,174 @@
+package p2p
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	gpupb "github.com/kevmo314/fedtorch/governor/api/go/gpu"
+)
+
+func TestConnect(t *testing.T) {
+	a := New(O{Address: "127.0.0.1"})
+	b := New(O{Address: "127.0.0.1"})
+
+	a.Announce([]*gpupb.GPU{
+		&gpupb.GPU{
+			Id: 100,
+		},
+	})
+	a.AnnounceAddr("127.0.0.1:50051")
+
+	for _, s := range []*Store{a, b} {
+		if err := s.Start(); err != nil {
+			t.Fatalf("Start() unexpectedly failed: %v", err)
+		}
+		defer s.Stop()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	p, err := b.Connect(ctx, fmt.Sprintf("127.0.0.1:%v", a.Port()))
+	if err != nil {
+		t.Fatalf("Connect() unexpectedly failed: %v", err)
+	}
+	if got, want := p.GetId(), a.Host().ID().String(); got != want {
+		t.Errorf("GetId() = %v, want = %v", got, want)
+	}
+	if got := len(p.GetGpus()); got != 1 {
+		t.Errorf("len(GetGpus()) = %v, want = 1", got)
+	}
+	if got, want := p.GetGrpcAddr(), "127.0.0.1:50051"; got != want {
+		t.Errorf("GetGrpcAddr() = %v, want = %v", got, want)
+	}
+	if got := len(b.Host().Network().ConnsToPeer(a.Host().ID())); got == 0 {
+		t.Errorf("ConnsToPeer() unexpectedly returned no connections")
+	}
+}
+
+// TestLoadKey checks that a store started with a persisted key keeps its peer
+// ID across restarts.
+func TestLoadKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key")
+
+	var ids []string
+	for i := 0; i < 2; i++ {
+		k, err := LoadKey(path)
+		if err != nil {
+			t.Fatalf("LoadKey() unexpectedly failed: %v", err)
+		}
+		s := New(O{Address: "127.0.0.1", Key: k})
+		if err := s.Start(); err != nil {
+			t.Fatalf("Start() unexpectedly failed: %v", err)
+		}
+		ids = append(ids, s.Host().ID().String())
+		s.Stop()
+	}
+	if ids[0] != ids[1] {
+		t.Errorf("ID() = %v after restart, want = %v", ids[1], ids[0])
+	}
+}
+
+func TestConnectSelf(t *testing.T) {
+	a := New(O{Address: "127.0.0.1"})
+	if err := a.Start(); err != nil {
+		t.Fatalf("Start() unexpectedly failed: %v", err)
+	}
+	defer a.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if p, err := a.Connect(ctx, fmt.Sprintf("127.0.0.1:%v", a.Port())); err == nil {
+		t.Errorf("Connect() unexpectedly succeeded: %v", p)
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	const n = 3
+
+	root := New(O{
+		Address:  "127.0.0.1",
+		Interval: 100 * time.Millisecond,
+	})
+	if err := root.Start(); err != nil {
+		t.Fatalf("Start() unexpectedly failed: %v", err)
+	}
+	defer root.Stop()
+
+	stores := []*Store{root}
+	for i := 1; i < n; i++ {
+		s := New(O{
+			Address:  "127.0.0.1",
+			Routers:  []string{fmt.Sprintf("127.0.0.1:%v", root.Port())},
+			Interval: 100 * time.Millisecond,
+		})
+		if err := s.Start(); err != nil {
+			t.Fatalf("Start() unexpectedly failed: %v", err)
+		}
+		defer s.Stop()
+
+		stores = append(stores, s)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for _, s := range stores {
+		for len(s.Peers()) < n-1 {
+			if time.Now().After(deadline) {
+				t.Fatalf("Peers() = %v, want %v peers", s.Peers(), n-1)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+}
+
+func TestStop(t *testing.T) {
+	s := New(O{Address: "127.0.0.1"})
+
+	// Stopping an unstarted store is a no-op.
+	s.Stop()
+
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start() unexpectedly failed: %v", err)
+	}
+	if err := s.Start(); err == nil {
+		t.Errorf("Start() unexpectedly succeeded")
+	}
+
+	s.Stop()
+	s.Stop()
+}
+
+// TestStopRelease checks that the DHT socket is closed by the time Stop
+// returns, even while the DHT node is producing peer results.
+func TestStopRelease(t *testing.T) {
+	root := New(O{
+		Address:  "127.0.0.1",
+		Interval: 10 * time.Millisecond,
+	})
+	if err := root.Start(); err != nil {
+		t.Fatalf("Start() unexpectedly failed: %v", err)
+	}
+	defer root.Stop()
+
+	s := New(O{
+		Address:  "127.0.0.1",
+		Routers:  []string{fmt.Sprintf("127.0.0.1:%v", root.Port())},
+		Interval: 10 * time.Millisecond,
+	})
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start() unexpectedly failed: %v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	s.Stop()
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: s.Port()})
+	if err != nil {
+		t.Fatalf("ListenUDP() unexpectedly failed: %v", err)
+	}
+	conn.Close()
+}
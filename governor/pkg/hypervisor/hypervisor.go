@@ -5,11 +5,32 @@ import (
 	"net"
 	"os"
 	"os/exec"
+	"strconv"
+	"syscall"
+	"time"
+
+	gpupb "github.com/kevmo314/fedtorch/governor/api/go/gpu"
 )
 
+// MemoryFractionEnv is the environment variable which carries the fraction of
+// the device memory granted to the container. The fedtorch plugin caps the
+// memory the training script may allocate on the leased device to this
+// fraction, so that co-tenants of a shared device do not exceed their slice.
+//
+// The script itself is run unmodified, as e.g. __future__ imports must remain
+// at the top of the script.
+const MemoryFractionEnv = "FEDTORCH_MEMORY_FRACTION"
+
 type Hypervisor exec.Cmd
 
-func NewHypervisor(master net.Addr, id string, total int, script string) (*Hypervisor, error) {
+// NewHypervisor constructs the command which runs the input training script
+// on the device held by the input lease.
+func NewHypervisor(master net.Addr, id string, total int, script string, lease *gpupb.Lease) (*Hypervisor, error) {
+	fraction := lease.GetFraction()
+	if fraction == 0 {
+		fraction = 1
+	}
+
 	// write script to temp file
 	f, err := os.CreateTemp("", "hypervisor")
 	if err != nil {
@@ -23,7 +44,10 @@ func NewHypervisor(master net.Addr, id string, total int, script string) (*Hyper
 		return nil, fmt.Errorf("failed to close temp file: %w", err)
 	}
 	cmd := exec.Command(
-		"docker", "run", "-it", "--rm", "--gpus", "all", "--network", "host", "nvcr.io/nvidia/pytorch:22.01-py3", "torchrun",
+		"docker", "run", "-it", "--rm",
+		"--gpus", fmt.Sprintf("device=%d", lease.GetGpu().GetId()),
+		"-e", fmt.Sprintf("%s=%s", MemoryFractionEnv, strconv.FormatFloat(fraction, 'f', -1, 64)),
+		"--network", "host", "nvcr.io/nvidia/pytorch:22.01-py3", "torchrun",
 		fmt.Sprintf("--nnodes=1:%d", total),
 		"--nproc_per_node=1",
 		fmt.Sprintf("--rdzv_id=%s", id),
@@ -33,3 +57,24 @@ func NewHypervisor(master net.Addr, id string, total int, script string) (*Hyper
 	)
 	return (*Hypervisor)(cmd), nil
 }
+
+// Preempt asks the container to checkpoint and exit, and kills the container
+// if it is still running at the input deadline, e.g. the expiration of a lease
+// reported by pubsub.Allocator.Preemptions.
+//
+// docker run forwards the SIGTERM to torchrun, which in turn forwards it to
+// the training script.
+func (h *Hypervisor) Preempt(deadline time.Time) error {
+	cmd := (*exec.Cmd)(h)
+	if cmd.Process == nil {
+		return fmt.Errorf("hypervisor has not been started")
+	}
+	if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		return fmt.Errorf("failed to signal container: %w", err)
+	}
+	time.AfterFunc(time.Until(deadline), func() {
+		// Kill fails harmlessly if the container has already exited.
+		cmd.Process.Kill()
+	})
+	return nil
+}
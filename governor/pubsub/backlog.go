@@ -0,0 +1,73 @@
+package pubsub
+
+import (
+	"sync"
+
+	gpupb "github.com/kevmo314/fedtorch/governor/api/go/gpu"
+)
+
+// backlog holds the remote requests which have arrived but have not yet been
+// served, so that the daemon may choose which request to serve next rather
+// than serving requests strictly in order of arrival.
+type backlog struct {
+	l    sync.Mutex
+	reqs []*gpupb.LeaseRequest
+
+	// wake is signalled whenever a request is added. Signals are
+	// coalesced.
+	wake chan struct{}
+}
+
+func newBacklog() *backlog {
+	return &backlog{wake: make(chan struct{}, 1)}
+}
+
+// push adds the input request to the backlog, and returns false if the backlog
+// is full, in which case the request is dropped, as libp2p does once a
+// subscription buffer is full.
+func (b *backlog) push(req *gpupb.LeaseRequest) bool {
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	if len(b.reqs) >= subscriptionBufferSize {
+		return false
+	}
+	b.reqs = append(b.reqs, req)
+
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// pop removes and returns the request with the largest share, breaking ties in
+// order of arrival, or false if the backlog is empty. If share is nil, requests
+// are returned in order of arrival.
+func (b *backlog) pop(share func(requestor string) float64) (*gpupb.LeaseRequest, bool) {
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	if len(b.reqs) == 0 {
+		return nil, false
+	}
+
+	var i int
+	if share != nil {
+		shares := map[string]float64{}
+		for _, req := range b.reqs {
+			if _, ok := shares[req.GetRequestor()]; !ok {
+				shares[req.GetRequestor()] = share(req.GetRequestor())
+			}
+		}
+		for j, req := range b.reqs {
+			if shares[req.GetRequestor()] > shares[b.reqs[i].GetRequestor()] {
+				i = j
+			}
+		}
+	}
+
+	req := b.reqs[i]
+	b.reqs = append(b.reqs[:i], b.reqs[i+1:]...)
+	return req, true
+}
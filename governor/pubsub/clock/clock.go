@@ -0,0 +1,54 @@
+// Package clock abstracts the passage of time, so that allocators may be run
+// in virtual time, e.g. by tests and simulations.
+package clock
+
+import (
+	"time"
+)
+
+// Clock tells the time, and constructs timers and tickers which fire
+// according to that time.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer mirrors time.Timer.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Ticker mirrors time.Ticker.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Real is the wall clock.
+var Real Clock = real{}
+
+// Since returns the time elapsed on the input clock since t.
+func Since(c Clock, t time.Time) time.Duration { return c.Now().Sub(t) }
+
+// Until returns the time on the input clock until t.
+func Until(c Clock, t time.Time) time.Duration { return t.Sub(c.Now()) }
+
+type real struct{}
+
+func (real) Now() time.Time                   { return time.Now() }
+func (real) NewTimer(d time.Duration) Timer   { return timer{t: time.NewTimer(d)} }
+func (real) NewTicker(d time.Duration) Ticker { return ticker{t: time.NewTicker(d)} }
+
+type timer struct{ t *time.Timer }
+
+func (t timer) C() <-chan time.Time        { return t.t.C }
+func (t timer) Stop() bool                 { return t.t.Stop() }
+func (t timer) Reset(d time.Duration) bool { return t.t.Reset(d) }
+
+type ticker struct{ t *time.Ticker }
+
+func (t ticker) C() <-chan time.Time { return t.t.C }
+func (t ticker) Stop()               { t.t.Stop() }
@@ -0,0 +1,138 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVirtual(t *testing.T) {
+	start := time.Unix(0, 0)
+
+	configs := []struct {
+		name    string
+		advance time.Duration
+		want    []string
+		pending int
+	}{
+		{name: "None", advance: 0, want: nil, pending: 3},
+		{name: "Partial", advance: 2 * time.Second, want: []string{"a", "b"}, pending: 1},
+		{name: "Inclusive", advance: 3 * time.Second, want: []string{"a", "b", "c"}, pending: 0},
+	}
+
+	for _, c := range configs {
+		t.Run(c.name, func(t *testing.T) {
+			v := NewVirtual(start)
+
+			var got []string
+			f := func(s string) func() { return func() { got = append(got, s) } }
+
+			v.AfterFunc(3*time.Second, f("c"))
+			v.AfterFunc(time.Second, f("a"))
+			// Timers which fire at the same time fire in the
+			// order in which they were scheduled.
+			v.AfterFunc(time.Second, f("b"))
+
+			v.Advance(c.advance)
+			if len(got) != len(c.want) {
+				t.Fatalf("callbacks = %v, want = %v", got, c.want)
+			}
+			for i := range got {
+				if got[i] != c.want[i] {
+					t.Fatalf("callbacks = %v, want = %v", got, c.want)
+				}
+			}
+			if got, want := v.Now(), start.Add(c.advance); !got.Equal(want) {
+				t.Errorf("Now() = %v, want = %v", got, want)
+			}
+			if got := v.Len(); got != c.pending {
+				t.Errorf("Len() = %v, want = %v", got, c.pending)
+			}
+		})
+	}
+}
+
+func TestVirtualStep(t *testing.T) {
+	start := time.Unix(0, 0)
+	v := NewVirtual(start)
+
+	var fired time.Time
+	v.AfterFunc(time.Hour, func() {
+		fired = v.Now()
+		// Callbacks may schedule further timers.
+		v.AfterFunc(time.Hour, func() { fired = v.Now() })
+	})
+
+	for i := 1; i <= 2; i++ {
+		if !v.Step() {
+			t.Fatalf("Step() = false, want = true")
+		}
+		if want := start.Add(time.Duration(i) * time.Hour); !fired.Equal(want) {
+			t.Errorf("callback ran at %v, want = %v", fired, want)
+		}
+	}
+	if v.Step() {
+		t.Errorf("Step() = true, want = false")
+	}
+}
+
+func TestVirtualTimer(t *testing.T) {
+	v := NewVirtual(time.Unix(0, 0))
+
+	a := v.NewTimer(time.Second)
+	b := v.NewTimer(time.Second)
+	if !b.Stop() {
+		t.Errorf("Stop() = false, want = true")
+	}
+
+	v.Advance(time.Second)
+	select {
+	case <-a.C():
+	default:
+		t.Errorf("timer did not fire")
+	}
+	select {
+	case <-b.C():
+		t.Errorf("stopped timer unexpectedly fired")
+	default:
+	}
+	if a.Stop() {
+		t.Errorf("Stop() = true after the timer fired, want = false")
+	}
+
+	if a.Reset(time.Second) {
+		t.Errorf("Reset() = true after the timer fired, want = false")
+	}
+	v.Advance(time.Second)
+	select {
+	case <-a.C():
+	default:
+		t.Errorf("reset timer did not fire")
+	}
+}
+
+func TestVirtualTicker(t *testing.T) {
+	v := NewVirtual(time.Unix(0, 0))
+
+	tk := v.NewTicker(time.Second)
+	defer tk.Stop()
+
+	for i := 0; i < 3; i++ {
+		v.Advance(time.Second)
+		select {
+		case <-tk.C():
+		default:
+			t.Fatalf("ticker did not tick")
+		}
+	}
+
+	tk.Stop()
+	v.Advance(time.Second)
+	select {
+	case <-tk.C():
+		t.Errorf("stopped ticker unexpectedly ticked")
+	default:
+	}
+	if got := v.Len(); got != 0 {
+		t.Errorf("Len() = %v, want = 0", got)
+	}
+}
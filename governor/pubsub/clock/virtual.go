@@ -0,0 +1,245 @@
+package clock
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// vtimer is a timer, ticker or callback scheduled on a virtual clock.
+type vtimer struct {
+	v *Virtual
+
+	at  time.Time
+	seq uint64
+
+	// c receives the time whenever the timer fires, unless f is set, in
+	// which case f is run instead.
+	c chan time.Time
+	f func()
+
+	// period is the interval between ticks, and is zero for timers.
+	period time.Duration
+
+	// index is the position of the timer in the heap, and is negative if
+	// the timer is not scheduled.
+	index int
+}
+
+func (t *vtimer) C() <-chan time.Time { return t.c }
+
+func (t *vtimer) Stop() bool {
+	t.v.l.Lock()
+	defer t.v.l.Unlock()
+
+	return t.v.stop(t)
+}
+
+func (t *vtimer) Reset(d time.Duration) bool {
+	t.v.l.Lock()
+	defer t.v.l.Unlock()
+
+	ok := t.v.stop(t)
+	t.v.schedule(t, t.v.now.Add(d))
+	return ok
+}
+
+// vticker adapts a periodic vtimer to the Ticker interface.
+type vticker struct{ t *vtimer }
+
+func (t vticker) C() <-chan time.Time { return t.t.c }
+func (t vticker) Stop()               { t.t.Stop() }
+
+// timers is a min-heap of timers ordered by firing time, with ties broken by
+// the order in which the timers were scheduled.
+type timers []*vtimer
+
+func (h timers) Len() int { return len(h) }
+func (h timers) Less(i, j int) bool {
+	if h[i].at.Equal(h[j].at) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].at.Before(h[j].at)
+}
+func (h timers) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *timers) Push(x any) {
+	t := x.(*vtimer)
+	t.index = len(*h)
+	*h = append(*h, t)
+}
+
+func (h *timers) Pop() any {
+	old := *h
+	n := len(old)
+	t := old[n-1]
+	old[n-1] = nil
+	t.index = -1
+	*h = old[:n-1]
+	return t
+}
+
+// Virtual is a clock which only moves forward when advanced, firing all
+// timers in order of their firing times. Timers which fire at the same time
+// fire in the order in which they were scheduled.
+//
+// Virtual is safe for concurrent use. Note that timers deliver on buffered
+// channels, and goroutines waiting on a timer may still be running after the
+// clock has been advanced past it.
+type Virtual struct {
+	l      sync.Mutex
+	now    time.Time
+	timers timers
+	seq    uint64
+}
+
+// NewVirtual constructs a virtual clock starting at the input time.
+func NewVirtual(start time.Time) *Virtual {
+	return &Virtual{now: start}
+}
+
+func (v *Virtual) Now() time.Time {
+	v.l.Lock()
+	defer v.l.Unlock()
+
+	return v.now
+}
+
+func (v *Virtual) NewTimer(d time.Duration) Timer {
+	v.l.Lock()
+	defer v.l.Unlock()
+
+	t := &vtimer{v: v, c: make(chan time.Time, 1), index: -1}
+	v.schedule(t, v.now.Add(d))
+	return t
+}
+
+func (v *Virtual) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+
+	v.l.Lock()
+	defer v.l.Unlock()
+
+	t := &vtimer{v: v, c: make(chan time.Time, 1), period: d, index: -1}
+	v.schedule(t, v.now.Add(d))
+	return vticker{t: t}
+}
+
+// AfterFunc runs f once the clock has been advanced by d. f is run by the
+// goroutine advancing the clock, without holding any clock locks, and so may
+// itself schedule timers.
+func (v *Virtual) AfterFunc(d time.Duration, f func()) Timer {
+	v.l.Lock()
+	defer v.l.Unlock()
+
+	t := &vtimer{v: v, f: f, index: -1}
+	v.schedule(t, v.now.Add(d))
+	return t
+}
+
+// Len returns the number of scheduled timers.
+func (v *Virtual) Len() int {
+	v.l.Lock()
+	defer v.l.Unlock()
+
+	return len(v.timers)
+}
+
+// Next returns the firing time of the earliest scheduled timer, or false if
+// no timer is scheduled.
+func (v *Virtual) Next() (time.Time, bool) {
+	v.l.Lock()
+	defer v.l.Unlock()
+
+	if len(v.timers) == 0 {
+		return time.Time{}, false
+	}
+	return v.timers[0].at, true
+}
+
+// Step advances the clock to the earliest scheduled timer and fires it, and
+// returns false if no timer is scheduled.
+func (v *Virtual) Step() bool {
+	t, ok := v.Next()
+	if !ok {
+		return false
+	}
+	return v.fire(t)
+}
+
+// Advance moves the clock forward by d, firing all timers scheduled up to and
+// including the new time.
+func (v *Virtual) Advance(d time.Duration) { v.AdvanceTo(v.Now().Add(d)) }
+
+// AdvanceTo moves the clock forward to the input time, firing all timers
+// scheduled up to and including that time. The clock is never moved
+// backwards.
+func (v *Virtual) AdvanceTo(end time.Time) {
+	for v.fire(end) {
+	}
+
+	v.l.Lock()
+	defer v.l.Unlock()
+
+	if end.After(v.now) {
+		v.now = end
+	}
+}
+
+// fire fires the earliest timer scheduled up to the input time, and returns
+// false if there is no such timer.
+func (v *Virtual) fire(end time.Time) bool {
+	v.l.Lock()
+	if len(v.timers) == 0 || v.timers[0].at.After(end) {
+		v.l.Unlock()
+		return false
+	}
+
+	t := heap.Pop(&v.timers).(*vtimer)
+	if t.at.After(v.now) {
+		v.now = t.at
+	}
+	now := v.now
+	if t.period > 0 {
+		v.schedule(t, t.at.Add(t.period))
+	}
+	v.l.Unlock()
+
+	if t.f != nil {
+		t.f()
+		return true
+	}
+
+	// As with time.Timer, ticks are dropped if the receiver falls
+	// behind.
+	select {
+	case t.c <- now:
+	default:
+	}
+	return true
+}
+
+// schedule adds the input timer to the heap. The caller must hold the clock
+// lock.
+func (v *Virtual) schedule(t *vtimer, at time.Time) {
+	t.at = at
+	t.seq = v.seq
+	v.seq++
+	heap.Push(&v.timers, t)
+}
+
+// stop removes the input timer from the heap, and returns false if it was not
+// scheduled. The caller must hold the clock lock.
+func (v *Virtual) stop(t *vtimer) bool {
+	if t.index < 0 {
+		return false
+	}
+	heap.Remove(&v.timers, t.index)
+	return true
+}
@@ -0,0 +1,204 @@
+// Package expiry schedules callbacks to run when leases expire.
+//
+// All callbacks of a scheduler are run by a single goroutine, which sleeps
+// until the earliest scheduled expiration, so that a governor with many
+// outstanding leases does not need a goroutine or runtime timer per lease.
+package expiry
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+
+	"github.com/kevmo314/fedtorch/governor/pubsub/clock"
+)
+
+type entry[K comparable] struct {
+	key K
+	at  time.Time
+	f   func()
+
+	// index is the position of the entry in the heap.
+	index int
+}
+
+// entries is a min-heap of entries ordered by expiration.
+type entries[K comparable] []*entry[K]
+
+func (h entries[K]) Len() int           { return len(h) }
+func (h entries[K]) Less(i, j int) bool { return h[i].at.Before(h[j].at) }
+func (h entries[K]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *entries[K]) Push(x any) {
+	e := x.(*entry[K])
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *entries[K]) Pop() any {
+	old := *h
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return e
+}
+
+// S runs callbacks at scheduled times. Each callback is scheduled under a
+// key, e.g. a lease token, and at most one callback is scheduled per key.
+type S[K comparable] struct {
+	clock clock.Clock
+
+	l       sync.Mutex
+	entries entries[K]
+	keys    map[K]*entry[K]
+
+	// wake is signalled whenever the earliest expiration changes.
+	wake chan struct{}
+
+	done chan struct{}
+	once sync.Once
+	wg   sync.WaitGroup
+}
+
+// New constructs a scheduler which runs callbacks according to the input
+// clock.
+func New[K comparable](c clock.Clock) *S[K] {
+	s := &S[K]{
+		clock: c,
+		keys:  make(map[K]*entry[K]),
+		wake:  make(chan struct{}, 1),
+		done:  make(chan struct{}),
+	}
+	s.wg.Add(1)
+	go s.run()
+	return s
+}
+
+// Schedule runs f at the input time, replacing any callback already scheduled
+// under the same key. Times in the past run f as soon as possible.
+//
+// Callbacks are run sequentially, without holding any scheduler locks, and so
+// may themselves call Schedule or Cancel. Callbacks should not block, as they
+// delay all later callbacks.
+func (s *S[K]) Schedule(key K, at time.Time, f func()) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	if e, ok := s.keys[key]; ok {
+		e.at = at
+		e.f = f
+		heap.Fix(&s.entries, e.index)
+	} else {
+		e := &entry[K]{key: key, at: at, f: f}
+		heap.Push(&s.entries, e)
+		s.keys[key] = e
+	}
+	if s.keys[key].index == 0 {
+		s.signal()
+	}
+}
+
+// Cancel removes the callback scheduled under the input key, and returns
+// false if no callback was scheduled, e.g. because it has already run.
+func (s *S[K]) Cancel(key K) bool {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	e, ok := s.keys[key]
+	if !ok {
+		return false
+	}
+	heap.Remove(&s.entries, e.index)
+	delete(s.keys, key)
+	return true
+}
+
+// Len returns the number of scheduled callbacks.
+func (s *S[K]) Len() int {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	return len(s.entries)
+}
+
+// Close drops all scheduled callbacks, and blocks until any running callback
+// has returned.
+func (s *S[K]) Close() {
+	s.once.Do(func() {
+		close(s.done)
+		s.wg.Wait()
+
+		s.l.Lock()
+		defer s.l.Unlock()
+
+		s.entries = nil
+		s.keys = make(map[K]*entry[K])
+	})
+}
+
+// signal wakes up the scheduler. The caller must hold the scheduler lock.
+func (s *S[K]) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *S[K]) run() {
+	defer s.wg.Done()
+
+	t := s.clock.NewTimer(0)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-t.C():
+		case <-s.wake:
+			if !t.Stop() {
+				// Drain the timer if it fired concurrently.
+				select {
+				case <-t.C():
+				default:
+				}
+			}
+		}
+
+		due, next, ok := s.pop(s.clock.Now())
+		for _, e := range due {
+			select {
+			case <-s.done:
+				return
+			default:
+			}
+			e.f()
+		}
+		if ok {
+			t.Reset(next)
+		}
+	}
+}
+
+// pop removes and returns the entries which have expired as of the input
+// time, along with the time until the next expiration, if any.
+func (s *S[K]) pop(now time.Time) ([]*entry[K], time.Duration, bool) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	var due []*entry[K]
+	for len(s.entries) > 0 && !s.entries[0].at.After(now) {
+		e := heap.Pop(&s.entries).(*entry[K])
+		delete(s.keys, e.key)
+		due = append(due, e)
+	}
+	if len(s.entries) == 0 {
+		return due, 0, false
+	}
+	return due, s.entries[0].at.Sub(now), true
+}
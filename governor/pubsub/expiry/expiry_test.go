@@ -0,0 +1,238 @@
+package expiry
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kevmo314/fedtorch/governor/pubsub/clock"
+	"go.uber.org/goleak"
+)
+
+// recorder records the order in which callbacks are run.
+type recorder struct {
+	l    sync.Mutex
+	keys []string
+}
+
+func (r *recorder) f(key string) func() {
+	return func() {
+		r.l.Lock()
+		defer r.l.Unlock()
+		r.keys = append(r.keys, key)
+	}
+}
+
+func (r *recorder) get() []string {
+	r.l.Lock()
+	defer r.l.Unlock()
+	return append([]string(nil), r.keys...)
+}
+
+func TestSchedule(t *testing.T) {
+	type op struct {
+		key    string
+		after  time.Duration
+		cancel bool
+	}
+	configs := []struct {
+		name string
+		ops  []op
+		want []string
+	}{
+		{
+			name: "Order",
+			ops: []op{
+				{key: "c", after: 60 * time.Millisecond},
+				{key: "a", after: 20 * time.Millisecond},
+				{key: "b", after: 40 * time.Millisecond},
+			},
+			want: []string{"a", "b", "c"},
+		},
+		{
+			name: "Past",
+			ops: []op{
+				{key: "a", after: time.Hour},
+				{key: "b", after: -time.Hour},
+			},
+			want: []string{"b"},
+		},
+		{
+			name: "Reschedule",
+			ops: []op{
+				{key: "a", after: 20 * time.Millisecond},
+				{key: "b", after: 40 * time.Millisecond},
+				{key: "a", after: 60 * time.Millisecond},
+			},
+			want: []string{"b", "a"},
+		},
+		{
+			name: "Reschedule/Earlier",
+			ops: []op{
+				{key: "a", after: time.Hour},
+				{key: "a", after: 20 * time.Millisecond},
+			},
+			want: []string{"a"},
+		},
+		{
+			name: "Cancel",
+			ops: []op{
+				{key: "a", after: 20 * time.Millisecond},
+				{key: "b", after: 40 * time.Millisecond},
+				{key: "a", cancel: true},
+			},
+			want: []string{"b"},
+		},
+	}
+
+	for _, c := range configs {
+		t.Run(c.name, func(t *testing.T) {
+			s := New[string](clock.Real)
+			defer s.Close()
+
+			r := &recorder{}
+			now := time.Now()
+			for _, o := range c.ops {
+				if o.cancel {
+					if !s.Cancel(o.key) {
+						t.Errorf("Cancel(%v) = false, want = true", o.key)
+					}
+					continue
+				}
+				s.Schedule(o.key, now.Add(o.after), r.f(o.key))
+			}
+
+			time.Sleep(200 * time.Millisecond)
+			got := r.get()
+			if len(got) != len(c.want) {
+				t.Fatalf("callbacks = %v, want = %v", got, c.want)
+			}
+			for i := range got {
+				if got[i] != c.want[i] {
+					t.Fatalf("callbacks = %v, want = %v", got, c.want)
+				}
+			}
+		})
+	}
+}
+
+func TestCallbackSchedule(t *testing.T) {
+	s := New[string](clock.Real)
+	defer s.Close()
+
+	done := make(chan struct{})
+	s.Schedule("a", time.Now(), func() {
+		s.Schedule("b", time.Now(), func() { close(done) })
+	})
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("callback scheduled by a callback did not run")
+	}
+	if got := s.Len(); got != 0 {
+		t.Errorf("Len() = %v, want = 0", got)
+	}
+}
+
+func TestVirtual(t *testing.T) {
+	start := time.Unix(0, 0)
+	c := clock.NewVirtual(start)
+	s := New[string](c)
+	defer s.Close()
+
+	r := &recorder{}
+	s.Schedule("a", start.Add(time.Hour), r.f("a"))
+	s.Schedule("b", start.Add(2*time.Hour), r.f("b"))
+
+	// The scheduler goroutine is woken asynchronously by the virtual
+	// timer, and so the callback may be run some time after the clock is
+	// advanced.
+	c.Advance(90 * time.Minute)
+	deadline := time.Now().Add(time.Second)
+	for len(r.get()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if got := r.get(); len(got) != 1 || got[0] != "a" {
+		t.Errorf("callbacks = %v, want = [a]", got)
+	}
+	if got := s.Len(); got != 1 {
+		t.Errorf("Len() = %v, want = 1", got)
+	}
+}
+
+func TestClose(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	s := New[string](clock.Real)
+	for i := 0; i < 100; i++ {
+		s.Schedule(fmt.Sprint(i), time.Now().Add(time.Hour), func() {
+			t.Errorf("callback unexpectedly ran after Close()")
+		})
+	}
+	s.Close()
+	s.Close()
+	if got := s.Len(); got != 0 {
+		t.Errorf("Len() = %v, want = 0", got)
+	}
+}
+
+const benchmarkLeases = 100000
+
+// schedule fills the input scheduler with n callbacks expiring in an hour.
+func schedule(s *S[int], n int) {
+	at := time.Now().Add(time.Hour)
+	for i := 0; i < n; i++ {
+		s.Schedule(i, at.Add(time.Duration(i)*time.Millisecond), func() {})
+	}
+}
+
+func BenchmarkSchedule(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		s := New[int](clock.Real)
+		schedule(s, benchmarkLeases)
+		s.Close()
+	}
+}
+
+func BenchmarkReschedule(b *testing.B) {
+	s := New[int](clock.Real)
+	defer s.Close()
+	schedule(s, benchmarkLeases)
+
+	at := time.Now().Add(2 * time.Hour)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.Schedule(i%benchmarkLeases, at, func() {})
+	}
+}
+
+func BenchmarkCancel(b *testing.B) {
+	s := New[int](clock.Real)
+	defer s.Close()
+
+	for i := 0; i < b.N; i++ {
+		if i%benchmarkLeases == 0 {
+			b.StopTimer()
+			schedule(s, benchmarkLeases)
+			b.StartTimer()
+		}
+		s.Cancel(i % benchmarkLeases)
+	}
+}
+
+func BenchmarkExpire(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		s := New[int](clock.Real)
+
+		var wg sync.WaitGroup
+		wg.Add(benchmarkLeases)
+		at := time.Now()
+		for j := 0; j < benchmarkLeases; j++ {
+			s.Schedule(j, at, wg.Done)
+		}
+		wg.Wait()
+		s.Close()
+	}
+}
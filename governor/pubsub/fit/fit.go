@@ -0,0 +1,78 @@
+// Package fit matches GPUs against the constraints of a lease request.
+package fit
+
+import (
+	"sort"
+
+	gpupb "github.com/kevmo314/fedtorch/governor/api/go/gpu"
+)
+
+// Satisfies checks if the input GPU meets the hard requirements of the input
+// constraints. A nil constraint is satisfied by all GPUs.
+func Satisfies(c *gpupb.Constraints, g *gpupb.GPU) bool {
+	if g.GetMemory() < c.GetMinMemory() {
+		return false
+	}
+	if g.GetClockRate() < c.GetMinClockRate() {
+		return false
+	}
+	if free(g) < c.GetMinFreeMemory() {
+		return false
+	}
+	if c.GetMaxTemperature() > 0 && g.GetTelemetry() != nil && g.GetTelemetry().GetTemperature() > c.GetMaxTemperature() {
+		return false
+	}
+	if len(c.GetNames()) == 0 {
+		return true
+	}
+	for _, n := range c.GetNames() {
+		if n == g.GetName() {
+			return true
+		}
+	}
+	return false
+}
+
+// Rank returns the GPUs which satisfy the input constraints, ordered from
+// best to worst fit.
+//
+// GPUs on the preferred host are ranked first. Otherwise, the GPU with the
+// least excess memory, and then the least excess clock rate, is preferred, so
+// that more capable devices remain available for more demanding requests.
+// Remaining ties are broken by the lowest current utilization, and then by
+// input order.
+func Rank(c *gpupb.Constraints, gpus []*gpupb.GPU) []*gpupb.GPU {
+	var candidates []*gpupb.GPU
+	for _, g := range gpus {
+		if Satisfies(c, g) {
+			candidates = append(candidates, g)
+		}
+	}
+
+	preferred := func(g *gpupb.GPU) bool {
+		return c.GetPreferredHost() != "" && g.GetHost() == c.GetPreferredHost()
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		gi, gj := candidates[i], candidates[j]
+		if pi, pj := preferred(gi), preferred(gj); pi != pj {
+			return pi
+		}
+		if gi.GetMemory() != gj.GetMemory() {
+			return gi.GetMemory() < gj.GetMemory()
+		}
+		if gi.GetClockRate() != gj.GetClockRate() {
+			return gi.GetClockRate() < gj.GetClockRate()
+		}
+		return gi.GetTelemetry().GetUtilization() < gj.GetTelemetry().GetUtilization()
+	})
+	return candidates
+}
+
+// free returns the unallocated memory of the input GPU, as of the latest
+// telemetry sample.
+func free(g *gpupb.GPU) int64 {
+	if t := g.GetTelemetry(); t != nil {
+		return t.GetFreeMemory()
+	}
+	return g.GetMemory()
+}
@@ -0,0 +1,120 @@
+package fit
+
+import (
+	"testing"
+
+	gpupb "github.com/kevmo314/fedtorch/governor/api/go/gpu"
+)
+
+func TestRank(t *testing.T) {
+	gpus := []*gpupb.GPU{
+		&gpupb.GPU{
+			Host:      "some-host",
+			Id:        100,
+			Name:      "NVIDIA A100",
+			Memory:    80 << 30,
+			ClockRate: 1410000,
+			Telemetry: &gpupb.Telemetry{
+				FreeMemory:  64 << 30,
+				Temperature: 85,
+			},
+		},
+		&gpupb.GPU{
+			Host:      "some-host",
+			Id:        101,
+			Name:      "NVIDIA T4",
+			Memory:    16 << 30,
+			ClockRate: 1590000,
+		},
+		&gpupb.GPU{
+			Host:      "other-host",
+			Id:        200,
+			Name:      "NVIDIA A100",
+			Memory:    40 << 30,
+			ClockRate: 1410000,
+			Telemetry: &gpupb.Telemetry{
+				FreeMemory:  8 << 30,
+				Temperature: 70,
+			},
+		},
+	}
+
+	configs := []struct {
+		name string
+		c    *gpupb.Constraints
+		want []int32
+	}{
+		{
+			name: "Unconstrained",
+			c:    nil,
+			want: []int32{101, 200, 100},
+		},
+		{
+			name: "MinMemory",
+			c: &gpupb.Constraints{
+				MinMemory: 32 << 30,
+			},
+			want: []int32{200, 100},
+		},
+		{
+			name: "MinClockRate",
+			c: &gpupb.Constraints{
+				MinClockRate: 1500000,
+			},
+			want: []int32{101},
+		},
+		{
+			name: "Names",
+			c: &gpupb.Constraints{
+				Names: []string{"NVIDIA A100"},
+			},
+			want: []int32{200, 100},
+		},
+		{
+			name: "PreferredHost",
+			c: &gpupb.Constraints{
+				PreferredHost: "some-host",
+			},
+			want: []int32{101, 100, 200},
+		},
+		{
+			name: "MinFreeMemory",
+			c: &gpupb.Constraints{
+				MinFreeMemory: 32 << 30,
+			},
+			want: []int32{100},
+		},
+		{
+			name: "MaxTemperature",
+			c: &gpupb.Constraints{
+				MaxTemperature: 80,
+			},
+			want: []int32{101, 200},
+		},
+		{
+			name: "Unsatisfiable",
+			c: &gpupb.Constraints{
+				Names: []string{"NVIDIA H100"},
+			},
+			want: nil,
+		},
+	}
+
+	for _, c := range configs {
+		t.Run(c.name, func(t *testing.T) {
+			var got []int32
+			for _, g := range Rank(c.c, gpus) {
+				got = append(got, g.GetId())
+			}
+			if len(got) != len(c.want) {
+				t.Fatalf("Rank() = %v, want = %v", got, c.want)
+			}
+			for i := range got {
+				if got[i] != c.want[i] {
+					t.Errorf("Rank() = %v, want = %v", got, c.want)
+					break
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/kevmo314/fedtorch/governor/pubsub/local"
+	"github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/host"
+
+	gpupb "github.com/kevmo314/fedtorch/governor/api/go/gpu"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	dpb "google.golang.org/protobuf/types/known/durationpb"
+)
+
+// probeTopic is joined by all simulated governors, and is used to check that
+// messages are delivered between each pair of governors.
+const probeTopic = "HARNESS_PROBE"
+
+// governor is a single simulated governor on an in-memory network.
+type governor struct {
+	host  host.Host
+	local *local.Allocator
+	a     *Allocator
+
+	probe *pubsub.Topic
+	sub   *pubsub.Subscription
+}
+
+// network is an in-memory libp2p network of governors, each of which runs an
+// Allocator over a fake local GPU inventory.
+type network struct {
+	ctx       context.Context
+	mn        mocknet.Mocknet
+	governors []*governor
+}
+
+// newNetwork constructs a fully connected network with one governor per input
+// inventory. Each Allocator is constructed with the input options, and with
+// the PubSub, PeerID and Local fields filled in. newNetwork returns once
+// messages are delivered between all governors.
+func newNetwork(t testing.TB, ctx context.Context, inventories [][]*gpupb.GPU, o O) *network {
+	t.Helper()
+
+	n := &network{ctx: ctx, mn: mocknet.New()}
+	t.Cleanup(func() {
+		for _, g := range n.governors {
+			g.a.Close()
+			g.local.Close()
+			g.sub.Cancel()
+			g.probe.Close()
+		}
+		n.mn.Close()
+	})
+
+	if o.Window == 0 {
+		o.Window = 500 * time.Millisecond
+	}
+	for _, gpus := range inventories {
+		h, err := n.mn.GenPeer()
+		if err != nil {
+			t.Fatalf("GenPeer() unexpectedly failed: %v", err)
+		}
+		ps, err := pubsub.NewGossipSub(ctx, h)
+		if err != nil {
+			t.Fatalf("NewGossipSub() unexpectedly failed: %v", err)
+		}
+		probe, err := ps.Join(probeTopic)
+		if err != nil {
+			t.Fatalf("Join() unexpectedly failed: %v", err)
+		}
+		sub, err := probe.Subscribe()
+		if err != nil {
+			t.Fatalf("Subscribe() unexpectedly failed: %v", err)
+		}
+
+		l := local.New(gpus, 0)
+		o := o
+		o.PubSub = ps
+		o.PeerID = h.ID()
+		o.Local = l
+		n.governors = append(n.governors, &governor{
+			host:  h,
+			local: l,
+			a:     New(ctx, o, time.Minute),
+			probe: probe,
+			sub:   sub,
+		})
+	}
+
+	n.heal(t)
+	return n
+}
+
+// partition disconnects the governors in each input group from the governors
+// in all other groups. Governors not in any group are left untouched.
+func (n *network) partition(t testing.TB, groups ...[]int) {
+	t.Helper()
+
+	for i, g := range groups {
+		for _, h := range groups[i+1:] {
+			for _, x := range g {
+				for _, y := range h {
+					a, b := n.governors[x].host.ID(), n.governors[y].host.ID()
+					if err := n.mn.UnlinkPeers(a, b); err != nil {
+						t.Fatalf("UnlinkPeers() unexpectedly failed: %v", err)
+					}
+					if err := n.mn.DisconnectPeers(a, b); err != nil {
+						t.Fatalf("DisconnectPeers() unexpectedly failed: %v", err)
+					}
+				}
+			}
+		}
+	}
+}
+
+// heal fully connects all governors, and waits until messages published by
+// each governor are delivered to all other governors.
+//
+// GossipSub drops messages published to a newly connected peer until the
+// underlying streams are set up, which may be some time after the peer shows
+// up in the topic peer list.
+func (n *network) heal(t testing.TB) {
+	t.Helper()
+
+	if err := n.mn.LinkAll(); err != nil {
+		t.Fatalf("LinkAll() unexpectedly failed: %v", err)
+	}
+	for i, g := range n.governors {
+		for _, h := range n.governors[i+1:] {
+			if len(g.host.Network().ConnsToPeer(h.host.ID())) > 0 {
+				continue
+			}
+			if _, err := n.mn.ConnectPeers(g.host.ID(), h.host.ID()); err != nil {
+				t.Fatalf("ConnectPeers() unexpectedly failed: %v", err)
+			}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(n.ctx, 10*time.Second)
+	defer cancel()
+	for i := range n.governors {
+		if err := n.probe(ctx, i); err != nil {
+			t.Fatalf("probe() unexpectedly failed: %v", err)
+		}
+	}
+}
+
+// probe publishes messages from the input governor until one is delivered to
+// every other governor.
+func (n *network) probe(ctx context.Context, i int) error {
+	g := n.governors[i]
+	for seq := 0; ; seq++ {
+		data := []byte(fmt.Sprintf("%v/%v", g.host.ID(), seq))
+		if err := g.probe.Publish(ctx, data); err != nil {
+			return err
+		}
+
+		ok := true
+		for j, h := range n.governors {
+			if j != i && !n.drain(ctx, h.sub, string(data)) {
+				ok = false
+			}
+		}
+		if ok {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
+
+// drain consumes messages on the input probe subscription until the message
+// with the input data arrives, or until no message has arrived for a while.
+func (n *network) drain(ctx context.Context, sub *pubsub.Subscription, data string) bool {
+	for {
+		ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		msg, err := sub.Next(ctx)
+		cancel()
+		if err != nil {
+			return false
+		}
+		if string(msg.GetData()) == data {
+			return true
+		}
+	}
+}
+
+// free returns the number of unleased GPUs on the input governor. Each free
+// GPU is briefly leased, and then released.
+func (n *network) free(t testing.TB, i int) int {
+	t.Helper()
+
+	l := n.governors[i].local
+	var leases []*gpupb.Lease
+	for {
+		resp, err := l.Lease(context.Background(), &gpupb.LeaseRequest{
+			Token:    "free-check",
+			Duration: dpb.New(time.Minute),
+		})
+		if err != nil {
+			break
+		}
+		leases = append(leases, resp.GetLease())
+	}
+	for _, m := range leases {
+		if err := l.Release(m); err != nil {
+			t.Fatalf("Release() unexpectedly failed: %v", err)
+		}
+	}
+	return len(leases)
+}
@@ -0,0 +1,275 @@
+// Package journal implements an append-only on-disk lease journal, so that a
+// governor which restarts does not forget the GPUs it has leased out.
+//
+// The journal is a sequence of varint length-prefixed journal.Entry records.
+// Replaying the journal in order yields the leases currently held on each GPU.
+// As the journal grows, it is periodically compacted into a snapshot which
+// contains a single OP_PUT entry per live lease.
+package journal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+
+	gpupb "github.com/kevmo314/fedtorch/governor/api/go/gpu"
+	jpb "github.com/kevmo314/fedtorch/governor/api/go/journal"
+)
+
+const (
+	defaultCompactAfter = 1024
+
+	// maxEntrySize bounds the size of a single journal entry, so that a
+	// corrupted length prefix does not cause a huge allocation.
+	maxEntrySize = 1 << 20
+)
+
+type O struct {
+	// Path is the journal file. The file is created if it does not exist.
+	Path string
+
+	// CompactAfter is the number of entries appended since the last
+	// snapshot after which the journal should be compacted. Defaults to
+	// 1024.
+	CompactAfter int
+}
+
+// J is an append-only lease journal.
+type J struct {
+	o O
+
+	l sync.Mutex
+	f *os.File
+
+	// n is the number of entries in the journal, of which the first base
+	// entries are the live leases as of the last snapshot or replay.
+	n    int
+	base int
+}
+
+// Open opens the journal at the input path for appending.
+func Open(o O) (*J, error) {
+	if o.CompactAfter == 0 {
+		o.CompactAfter = defaultCompactAfter
+	}
+
+	f, err := os.OpenFile(o.Path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open journal %v: %w", o.Path, err)
+	}
+	return &J{
+		o: o,
+		f: f,
+	}, nil
+}
+
+// Replay reads the journal from the beginning and returns the last lease
+// recorded for each GPU and token pair. Expired leases are not filtered out.
+//
+// A truncated trailing entry, e.g. from a crash in the middle of an append,
+// is ignored and removed from the journal, so that subsequent appends are
+// not corrupted.
+func (j *J) Replay() ([]*gpupb.Lease, error) {
+	j.l.Lock()
+	defer j.l.Unlock()
+
+	if _, err := j.f.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("cannot read journal %v: %w", j.o.Path, err)
+	}
+
+	type key struct {
+		id    int32
+		token string
+	}
+	leases := map[key]*gpupb.Lease{}
+
+	r := bufio.NewReader(j.f)
+	var offset int64
+	var n int
+	for {
+		e, size, err := read(r)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			if err := j.f.Truncate(offset); err != nil {
+				return nil, fmt.Errorf("cannot truncate journal %v: %w", j.o.Path, err)
+			}
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot read journal %v at offset %v: %w", j.o.Path, offset, err)
+		}
+		offset += size
+		n++
+
+		k := key{
+			id:    e.GetLease().GetGpu().GetId(),
+			token: e.GetLease().GetToken(),
+		}
+		switch e.GetOp() {
+		case jpb.Entry_OP_PUT:
+			leases[k] = e.GetLease()
+		case jpb.Entry_OP_DELETE:
+			delete(leases, k)
+		}
+	}
+	j.n = n
+	j.base = len(leases)
+
+	var live []*gpupb.Lease
+	for _, l := range leases {
+		live = append(live, l)
+	}
+	return live, nil
+}
+
+// Put records a lease held on the leased GPU, replacing any previous lease on
+// the GPU under the same token.
+func (j *J) Put(l *gpupb.Lease) error {
+	return j.append(&jpb.Entry{
+		Op:    jpb.Entry_OP_PUT,
+		Lease: l,
+	})
+}
+
+// Delete records that the input lease no longer holds the leased GPU.
+func (j *J) Delete(l *gpupb.Lease) error {
+	return j.append(&jpb.Entry{
+		Op:    jpb.Entry_OP_DELETE,
+		Lease: l,
+	})
+}
+
+// ShouldCompact reports if enough entries have been appended since the last
+// snapshot that the journal should be compacted.
+func (j *J) ShouldCompact() bool {
+	j.l.Lock()
+	defer j.l.Unlock()
+
+	return j.n-j.base >= j.o.CompactAfter
+}
+
+// Compact atomically replaces the journal with a snapshot of the input live
+// leases.
+func (j *J) Compact(leases []*gpupb.Lease) error {
+	j.l.Lock()
+	defer j.l.Unlock()
+
+	tmp, err := os.CreateTemp(filepath.Dir(j.o.Path), filepath.Base(j.o.Path)+".snapshot-*")
+	if err != nil {
+		return fmt.Errorf("cannot create journal snapshot: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	for _, l := range leases {
+		data, err := frame(&jpb.Entry{
+			Op:    jpb.Entry_OP_PUT,
+			Lease: l,
+		})
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			tmp.Close()
+			return fmt.Errorf("cannot write journal snapshot: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("cannot write journal snapshot: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("cannot sync journal snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("cannot write journal snapshot: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), j.o.Path); err != nil {
+		return fmt.Errorf("cannot replace journal with snapshot: %w", err)
+	}
+
+	f, err := os.OpenFile(j.o.Path, os.O_RDWR|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("cannot reopen journal %v: %w", j.o.Path, err)
+	}
+	j.f.Close()
+	j.f = f
+	j.n = len(leases)
+	j.base = len(leases)
+	return nil
+}
+
+// Close closes the journal file.
+func (j *J) Close() error {
+	j.l.Lock()
+	defer j.l.Unlock()
+
+	return j.f.Close()
+}
+
+func (j *J) append(e *jpb.Entry) error {
+	data, err := frame(e)
+	if err != nil {
+		return err
+	}
+
+	j.l.Lock()
+	defer j.l.Unlock()
+
+	if _, err := j.f.Write(data); err != nil {
+		return fmt.Errorf("cannot append to journal %v: %w", j.o.Path, err)
+	}
+	if err := j.f.Sync(); err != nil {
+		return fmt.Errorf("cannot sync journal %v: %w", j.o.Path, err)
+	}
+	j.n++
+	return nil
+}
+
+// frame returns the varint length-prefixed encoding of the input entry.
+func frame(e *jpb.Entry) ([]byte, error) {
+	data, err := proto.Marshal(e)
+	if err != nil {
+		return nil, fmt.Errorf("cannot marshal journal entry: %w", err)
+	}
+	return append(protowire.AppendVarint(nil, uint64(len(data))), data...), nil
+}
+
+// read reads a single entry, and returns the entry along with the number of
+// bytes read. read returns io.ErrUnexpectedEOF if the entry is truncated.
+func read(r *bufio.Reader) (*jpb.Entry, int64, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	if n > maxEntrySize {
+		return nil, 0, fmt.Errorf("journal entry exceeds maximum size: %v > %v", n, maxEntrySize)
+	}
+
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, 0, err
+	}
+
+	e := &jpb.Entry{}
+	if err := proto.Unmarshal(data, e); err != nil {
+		return nil, 0, fmt.Errorf("invalid journal entry: %w", err)
+	}
+	return e, int64(len(protowire.AppendVarint(nil, n))) + int64(n), nil
+}
@@ -0,0 +1,174 @@
+package journal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	gpupb "github.com/kevmo314/fedtorch/governor/api/go/gpu"
+	jpb "github.com/kevmo314/fedtorch/governor/api/go/journal"
+	tpb "google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func lease(id int32, token string) *gpupb.Lease {
+	return &gpupb.Lease{
+		Gpu: &gpupb.GPU{
+			Id: id,
+		},
+		Token:      token,
+		Expiration: tpb.New(time.Now().Add(time.Hour)),
+	}
+}
+
+func TestReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+
+	j, err := Open(O{Path: path})
+	if err != nil {
+		t.Fatalf("Open() unexpectedly failed: %v", err)
+	}
+	for _, f := range []func() error{
+		func() error { return j.Put(lease(100, "some-token")) },
+		func() error { return j.Put(lease(101, "some-token")) },
+		func() error { return j.Delete(lease(100, "some-token")) },
+		// A stale delete must not free a GPU which has since been
+		// leased under another token.
+		func() error { return j.Put(lease(102, "other-token")) },
+		func() error { return j.Delete(lease(102, "some-token")) },
+		// Fractional leases may share a GPU.
+		func() error { return j.Put(lease(101, "other-token")) },
+	} {
+		if err := f(); err != nil {
+			t.Fatalf("append unexpectedly failed: %v", err)
+		}
+	}
+	j.Close()
+
+	j, err = Open(O{Path: path})
+	if err != nil {
+		t.Fatalf("Open() unexpectedly failed: %v", err)
+	}
+	defer j.Close()
+
+	leases, err := j.Replay()
+	if err != nil {
+		t.Fatalf("Replay() unexpectedly failed: %v", err)
+	}
+	if got := len(leases); got != 3 {
+		t.Errorf("len(Replay()) = %v, want = 3", got)
+	}
+	restored := map[int32]bool{}
+	for _, l := range leases {
+		restored[l.GetGpu().GetId()] = true
+	}
+	for _, id := range []int32{101, 102} {
+		if !restored[id] {
+			t.Errorf("Replay() did not restore the lease on GPU %v", id)
+		}
+	}
+}
+
+func TestReplayTruncated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+
+	j, err := Open(O{Path: path})
+	if err != nil {
+		t.Fatalf("Open() unexpectedly failed: %v", err)
+	}
+	if err := j.Put(lease(100, "some-token")); err != nil {
+		t.Fatalf("Put() unexpectedly failed: %v", err)
+	}
+	j.Close()
+
+	// Emulate a crash in the middle of an append.
+	data, err := frame(&jpb.Entry{
+		Op:    jpb.Entry_OP_PUT,
+		Lease: lease(101, "some-token"),
+	})
+	if err != nil {
+		t.Fatalf("frame() unexpectedly failed: %v", err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("OpenFile() unexpectedly failed: %v", err)
+	}
+	f.Write(data[:len(data)/2])
+	f.Close()
+
+	j, err = Open(O{Path: path})
+	if err != nil {
+		t.Fatalf("Open() unexpectedly failed: %v", err)
+	}
+	defer j.Close()
+
+	leases, err := j.Replay()
+	if err != nil {
+		t.Fatalf("Replay() unexpectedly failed: %v", err)
+	}
+	if got := len(leases); got != 1 {
+		t.Errorf("len(Replay()) = %v, want = 1", got)
+	}
+
+	// Subsequent appends should be readable.
+	if err := j.Put(lease(101, "some-token")); err != nil {
+		t.Fatalf("Put() unexpectedly failed: %v", err)
+	}
+	if leases, err = j.Replay(); err != nil {
+		t.Fatalf("Replay() unexpectedly failed: %v", err)
+	}
+	if got := len(leases); got != 2 {
+		t.Errorf("len(Replay()) = %v, want = 2", got)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+
+	j, err := Open(O{
+		Path:         path,
+		CompactAfter: 4,
+	})
+	if err != nil {
+		t.Fatalf("Open() unexpectedly failed: %v", err)
+	}
+	defer j.Close()
+
+	for i := 0; i < 2; i++ {
+		j.Put(lease(100, "some-token"))
+		j.Delete(lease(100, "some-token"))
+	}
+	if !j.ShouldCompact() {
+		t.Errorf("ShouldCompact() = false, want = true")
+	}
+
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat() unexpectedly failed: %v", err)
+	}
+	if err := j.Compact([]*gpupb.Lease{lease(101, "some-token")}); err != nil {
+		t.Fatalf("Compact() unexpectedly failed: %v", err)
+	}
+	if j.ShouldCompact() {
+		t.Errorf("ShouldCompact() = true, want = false")
+	}
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat() unexpectedly failed: %v", err)
+	}
+	if after.Size() >= before.Size() {
+		t.Errorf("Compact() did not shrink the journal: %v >= %v", after.Size(), before.Size())
+	}
+
+	// The compacted journal remains appendable.
+	if err := j.Put(lease(102, "some-token")); err != nil {
+		t.Fatalf("Put() unexpectedly failed: %v", err)
+	}
+	leases, err := j.Replay()
+	if err != nil {
+		t.Fatalf("Replay() unexpectedly failed: %v", err)
+	}
+	if got := len(leases); got != 2 {
+		t.Errorf("len(Replay()) = %v, want = 2", got)
+	}
+}
@@ -0,0 +1,355 @@
+// Package ledger keeps a reciprocity ledger of the GPU time a governor has
+// lent to and borrowed from each of its peers.
+//
+// Leases are recorded as open entries when granted, and are settled into the
+// balance with the peer once they expire or are released. Governors
+// periodically gossip signed summaries of their balances, so that a peer's
+// account of a lease may be checked against the other party's.
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/kevmo314/fedtorch/governor/pubsub/clock"
+	"google.golang.org/protobuf/proto"
+
+	gpupb "github.com/kevmo314/fedtorch/governor/api/go/gpu"
+	lpb "github.com/kevmo314/fedtorch/governor/api/go/ledger"
+	tpb "google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type O struct {
+	// Path is the file the ledger is persisted to. The ledger is loaded
+	// from the file on construction, if it exists. If empty, the ledger is
+	// kept in memory only.
+	Path string
+
+	// MaxDebt is the GPU time a peer may borrow from the local governor
+	// in excess of the GPU time it has lent to the local governor. If
+	// zero, peers may borrow without limit.
+	MaxDebt time.Duration
+
+	// Self is the peer ID of the local governor, under which peers
+	// report their balances with the local governor. If set, a peer is
+	// held to the larger of the debt recorded locally and the debt the
+	// peer itself last reported, e.g. if the local ledger was lost. If
+	// empty, reported balances are not used.
+	Self string
+
+	// Clock times lease expirations and settlements. Defaults to the
+	// wall clock.
+	Clock clock.Clock
+}
+
+type key struct {
+	id    int32
+	token string
+}
+
+// L is a reciprocity ledger.
+type L struct {
+	o O
+
+	l        sync.Mutex
+	balances map[string]*lpb.Balance
+	open     map[key]*lpb.Entry
+
+	// reports are the latest summaries gossiped by each peer.
+	reports map[string]*lpb.Summary
+
+	clock clock.Clock
+}
+
+// New constructs a ledger, and loads the ledger persisted at the input path,
+// if any.
+func New(o O) (*L, error) {
+	if o.Clock == nil {
+		o.Clock = clock.Real
+	}
+	l := &L{
+		o:        o,
+		balances: make(map[string]*lpb.Balance),
+		open:     make(map[key]*lpb.Entry),
+		reports:  make(map[string]*lpb.Summary),
+		clock:    o.Clock,
+	}
+	if o.Path == "" {
+		return l, nil
+	}
+
+	data, err := os.ReadFile(o.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read ledger: %w", err)
+	}
+	var pb lpb.State
+	if err := proto.Unmarshal(data, &pb); err != nil {
+		return nil, fmt.Errorf("invalid ledger %v: %w", o.Path, err)
+	}
+	for _, b := range pb.GetBalances() {
+		l.balances[b.GetPeer()] = b
+	}
+	for _, e := range pb.GetOpen() {
+		l.open[keyOf(e.GetLease())] = e
+	}
+	return l, nil
+}
+
+// UseClock replaces the clock of the ledger, e.g. with the clock of the
+// allocator recording leases to the ledger.
+func (l *L) UseClock(c clock.Clock) {
+	l.l.Lock()
+	defer l.l.Unlock()
+
+	l.clock = c
+}
+
+// Lend records a lease granted by the local governor to the input peer.
+func (l *L) Lend(peer string, lease *gpupb.Lease) error {
+	return l.add(peer, true, lease)
+}
+
+// Borrow records a lease granted to the local governor by the input peer.
+func (l *L) Borrow(peer string, lease *gpupb.Lease) error {
+	return l.add(peer, false, lease)
+}
+
+func (l *L) add(peer string, lent bool, lease *gpupb.Lease) error {
+	l.l.Lock()
+	defer l.l.Unlock()
+
+	l.open[keyOf(lease)] = &lpb.Entry{
+		Peer:  peer,
+		Lent:  lent,
+		Lease: lease,
+		Start: tpb.New(l.clock.Now()),
+	}
+	return l.save()
+}
+
+// Update replaces the expiration of a recorded lease with that of the input
+// lease, e.g. after the lease is renewed or preempted.
+func (l *L) Update(lease *gpupb.Lease) error {
+	l.l.Lock()
+	defer l.l.Unlock()
+
+	e, ok := l.open[keyOf(lease)]
+	if !ok {
+		return fmt.Errorf("no lease found for token %v on GPU %v", lease.GetToken(), lease.GetGpu().GetId())
+	}
+	e.Lease = lease
+	return l.save()
+}
+
+// Return settles a recorded lease which has been released before it expired.
+func (l *L) Return(lease *gpupb.Lease) error {
+	l.l.Lock()
+	defer l.l.Unlock()
+
+	e, ok := l.open[keyOf(lease)]
+	if !ok {
+		return fmt.Errorf("no lease found for token %v on GPU %v", lease.GetToken(), lease.GetGpu().GetId())
+	}
+	l.settle(e, l.clock.Now())
+	delete(l.open, keyOf(lease))
+	return l.save()
+}
+
+// Balance returns the GPU time lent to and borrowed from the input peer,
+// including the elapsed time of unexpired leases.
+func (l *L) Balance(peer string) *lpb.Balance {
+	l.l.Lock()
+	defer l.l.Unlock()
+
+	return l.balance(peer, l.clock.Now())
+}
+
+// Summary returns the balances of the local governor with all of its peers,
+// for gossiping to peers.
+func (l *L) Summary(author string) *lpb.Summary {
+	l.l.Lock()
+	defer l.l.Unlock()
+
+	now := l.clock.Now()
+	peers := map[string]bool{}
+	for peer := range l.balances {
+		peers[peer] = true
+	}
+	for _, e := range l.open {
+		peers[e.GetPeer()] = true
+	}
+
+	s := &lpb.Summary{
+		Author: author,
+		Time:   tpb.New(now),
+	}
+	for peer := range peers {
+		s.Balances = append(s.Balances, l.balance(peer, now))
+	}
+	return s
+}
+
+// Observe records a summary gossiped by a peer. Summaries older than the
+// latest summary from the same author are ignored.
+func (l *L) Observe(s *lpb.Summary) {
+	l.l.Lock()
+	defer l.l.Unlock()
+
+	if m, ok := l.reports[s.GetAuthor()]; ok && !s.GetTime().AsTime().After(m.GetTime().AsTime()) {
+		return
+	}
+	l.reports[s.GetAuthor()] = s
+}
+
+// Reported returns the balance the input author last reported with the input
+// peer, e.g. so that the author's account of the GPU time it borrowed from
+// the local governor can be checked against the local ledger.
+func (l *L) Reported(author string, peer string) (*lpb.Balance, bool) {
+	l.l.Lock()
+	defer l.l.Unlock()
+
+	for _, b := range l.reports[author].GetBalances() {
+		if b.GetPeer() == peer {
+			return b, true
+		}
+	}
+	return nil, false
+}
+
+// Serve returns an error if the input peer has borrowed more GPU time from the
+// local governor than it has lent, in excess of the allowed debt. The debt is
+// the larger of the local account and the account last reported by the peer.
+func (l *L) Serve(peer string) error {
+	if l.o.MaxDebt == 0 {
+		return nil
+	}
+
+	b := l.Balance(peer)
+	debt := b.GetLentSeconds() - b.GetBorrowedSeconds()
+	if l.o.Self != "" {
+		// The peer reports the GPU time it borrowed from and lent to
+		// the local governor; a peer may understate its debt, but not
+		// overstate it to its own detriment.
+		if r, ok := l.Reported(peer, l.o.Self); ok {
+			if reported := r.GetBorrowedSeconds() - r.GetLentSeconds(); reported > debt {
+				debt = reported
+			}
+		}
+	}
+	if debt > l.o.MaxDebt.Seconds() {
+		return fmt.Errorf("peer %v owes %v of GPU time, which exceeds the maximum debt of %v", peer, time.Duration(debt*float64(time.Second)), l.o.MaxDebt)
+	}
+	return nil
+}
+
+// balance returns the balance with the input peer as of the input time. The
+// caller must hold the lock.
+func (l *L) balance(peer string, now time.Time) *lpb.Balance {
+	b := &lpb.Balance{Peer: peer}
+	if m, ok := l.balances[peer]; ok {
+		b = proto.Clone(m).(*lpb.Balance)
+	}
+	for _, e := range l.open {
+		if e.GetPeer() != peer {
+			continue
+		}
+		if e.GetLent() {
+			b.LentSeconds += elapsed(e, now)
+		} else {
+			b.BorrowedSeconds += elapsed(e, now)
+		}
+	}
+	return b
+}
+
+// settle adds the GPU time used by the input entry as of the input time to
+// the balance with the entry peer. The caller must hold the lock.
+func (l *L) settle(e *lpb.Entry, now time.Time) {
+	b, ok := l.balances[e.GetPeer()]
+	if !ok {
+		b = &lpb.Balance{Peer: e.GetPeer()}
+		l.balances[e.GetPeer()] = b
+	}
+	if e.GetLent() {
+		b.LentSeconds += elapsed(e, now)
+	} else {
+		b.BorrowedSeconds += elapsed(e, now)
+	}
+}
+
+// save settles all expired leases, and atomically persists the ledger. The
+// caller must hold the lock.
+func (l *L) save() error {
+	now := l.clock.Now()
+	pb := &lpb.State{}
+	for k, e := range l.open {
+		if now.After(e.GetLease().GetExpiration().AsTime()) {
+			l.settle(e, now)
+			delete(l.open, k)
+			continue
+		}
+		pb.Open = append(pb.Open, e)
+	}
+	for _, b := range l.balances {
+		pb.Balances = append(pb.Balances, b)
+	}
+
+	if l.o.Path == "" {
+		return nil
+	}
+
+	data, err := proto.Marshal(pb)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(l.o.Path), filepath.Base(l.o.Path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("cannot write ledger: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("cannot write ledger: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("cannot write ledger: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("cannot write ledger: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), l.o.Path); err != nil {
+		return fmt.Errorf("cannot write ledger: %w", err)
+	}
+	return nil
+}
+
+func keyOf(l *gpupb.Lease) key {
+	return key{id: l.GetGpu().GetId(), token: l.GetToken()}
+}
+
+// elapsed returns the GPU-seconds used by the lease of the input entry as of
+// the input time.
+func elapsed(e *lpb.Entry, now time.Time) float64 {
+	end := e.GetLease().GetExpiration().AsTime()
+	if now.Before(end) {
+		end = now
+	}
+	d := end.Sub(e.GetStart().AsTime())
+	if d < 0 {
+		return 0
+	}
+
+	f := e.GetLease().GetFraction()
+	if f == 0 {
+		f = 1
+	}
+	return f * d.Seconds()
+}
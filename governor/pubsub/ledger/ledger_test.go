@@ -0,0 +1,199 @@
+package ledger
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kevmo314/fedtorch/governor/pubsub/clock"
+
+	gpupb "github.com/kevmo314/fedtorch/governor/api/go/gpu"
+	lpb "github.com/kevmo314/fedtorch/governor/api/go/ledger"
+	tpb "google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func lease(id int32, token string, fraction float64, d time.Duration) *gpupb.Lease {
+	return &gpupb.Lease{
+		Token:      token,
+		Gpu:        &gpupb.GPU{Id: id},
+		Expiration: tpb.New(time.Now().Add(d)),
+		Fraction:   fraction,
+	}
+}
+
+// near checks if the input GPU-seconds are within 100ms of the expected value.
+func near(got float64, want float64) bool { return math.Abs(got-want) < 0.1 }
+
+func TestBalance(t *testing.T) {
+	l, err := New(O{})
+	if err != nil {
+		t.Fatalf("New() unexpectedly failed: %v", err)
+	}
+
+	// Lent for the full duration of the lease.
+	l.Lend("some-peer", lease(100, "some-token", 0, 200*time.Millisecond))
+	// Lent for half the duration of the lease.
+	l.Lend("some-peer", lease(101, "other-token", 0.5, time.Hour))
+	// Borrowed until released.
+	borrowed := lease(200, "some-token", 0, time.Hour)
+	l.Borrow("some-peer", borrowed)
+	l.Borrow("other-peer", lease(300, "some-token", 0, time.Hour))
+
+	time.Sleep(400 * time.Millisecond)
+	if err := l.Return(borrowed); err != nil {
+		t.Fatalf("Return() unexpectedly failed: %v", err)
+	}
+	if err := l.Return(borrowed); err == nil {
+		t.Errorf("Return() unexpectedly succeeded")
+	}
+
+	// The fractional lease is preempted.
+	if err := l.Update(lease(101, "other-token", 0.5, 0)); err != nil {
+		t.Fatalf("Update() unexpectedly failed: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	b := l.Balance("some-peer")
+	if got, want := b.GetLentSeconds(), 0.2+0.5*0.4; !near(got, want) {
+		t.Errorf("GetLentSeconds() = %v, want = %v", got, want)
+	}
+	if got, want := b.GetBorrowedSeconds(), 0.4; !near(got, want) {
+		t.Errorf("GetBorrowedSeconds() = %v, want = %v", got, want)
+	}
+
+	s := l.Summary("some-author")
+	if got := len(s.GetBalances()); got != 2 {
+		t.Errorf("len(GetBalances()) = %v, want = 2", got)
+	}
+}
+
+func TestServe(t *testing.T) {
+	l, err := New(O{MaxDebt: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("New() unexpectedly failed: %v", err)
+	}
+
+	l.Lend("some-peer", lease(100, "some-token", 0, 300*time.Millisecond))
+	l.Borrow("other-peer", lease(200, "some-token", 0, 300*time.Millisecond))
+	time.Sleep(400 * time.Millisecond)
+
+	if err := l.Serve("some-peer"); err == nil {
+		t.Errorf("Serve() unexpectedly succeeded")
+	}
+	if err := l.Serve("other-peer"); err != nil {
+		t.Errorf("Serve() unexpectedly failed: %v", err)
+	}
+	if err := l.Serve("unknown-peer"); err != nil {
+		t.Errorf("Serve() unexpectedly failed: %v", err)
+	}
+}
+
+// TestServeReported checks that a peer is held to the debt it reports owing,
+// even if the local ledger has no record of it.
+func TestServeReported(t *testing.T) {
+	configs := []struct {
+		name     string
+		self     string
+		borrowed float64
+		succ     bool
+	}{
+		{name: "Owed", self: "some-author", borrowed: 60, succ: false},
+		{name: "Settled", self: "some-author", borrowed: 0, succ: true},
+		{name: "NoSelf", self: "", borrowed: 60, succ: true},
+	}
+
+	for _, c := range configs {
+		t.Run(c.name, func(t *testing.T) {
+			l, err := New(O{MaxDebt: time.Second, Self: c.self})
+			if err != nil {
+				t.Fatalf("New() unexpectedly failed: %v", err)
+			}
+			l.Observe(&lpb.Summary{
+				Author:   "some-peer",
+				Balances: []*lpb.Balance{{Peer: "some-author", BorrowedSeconds: c.borrowed}},
+				Time:     tpb.Now(),
+			})
+
+			if err := l.Serve("some-peer"); (err == nil) != c.succ {
+				t.Errorf("Serve() = %v, want success = %v", err, c.succ)
+			}
+		})
+	}
+}
+
+// TestClock checks that leases are settled according to the injected clock
+// rather than the wall clock.
+func TestClock(t *testing.T) {
+	start := time.Unix(0, 0)
+	v := clock.NewVirtual(start)
+	l, err := New(O{Clock: v})
+	if err != nil {
+		t.Fatalf("New() unexpectedly failed: %v", err)
+	}
+
+	m := lease(100, "some-token", 0, 0)
+	m.Expiration = tpb.New(start.Add(time.Hour))
+	l.Lend("some-peer", m)
+	v.Advance(time.Minute)
+
+	if got, want := l.Balance("some-peer").GetLentSeconds(), 60.0; got != want {
+		t.Errorf("GetLentSeconds() = %v, want = %v", got, want)
+	}
+}
+
+func TestObserve(t *testing.T) {
+	l, err := New(O{})
+	if err != nil {
+		t.Fatalf("New() unexpectedly failed: %v", err)
+	}
+
+	now := time.Now()
+	l.Observe(&lpb.Summary{
+		Author:   "some-peer",
+		Balances: []*lpb.Balance{{Peer: "some-author", BorrowedSeconds: 60}},
+		Time:     tpb.New(now),
+	})
+	// Stale summaries are ignored.
+	l.Observe(&lpb.Summary{
+		Author:   "some-peer",
+		Balances: []*lpb.Balance{{Peer: "some-author", BorrowedSeconds: 30}},
+		Time:     tpb.New(now.Add(-time.Minute)),
+	})
+
+	b, ok := l.Reported("some-peer", "some-author")
+	if !ok {
+		t.Fatalf("Reported() unexpectedly failed")
+	}
+	if got, want := b.GetBorrowedSeconds(), 60.0; got != want {
+		t.Errorf("GetBorrowedSeconds() = %v, want = %v", got, want)
+	}
+	if _, ok := l.Reported("other-peer", "some-author"); ok {
+		t.Errorf("Reported() unexpectedly succeeded")
+	}
+}
+
+func TestPersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger")
+
+	l, err := New(O{Path: path})
+	if err != nil {
+		t.Fatalf("New() unexpectedly failed: %v", err)
+	}
+	settled := lease(100, "some-token", 0, time.Hour)
+	l.Lend("some-peer", settled)
+	l.Lend("some-peer", lease(101, "some-token", 0, time.Hour))
+	time.Sleep(100 * time.Millisecond)
+	if err := l.Return(settled); err != nil {
+		t.Fatalf("Return() unexpectedly failed: %v", err)
+	}
+
+	l, err = New(O{Path: path})
+	if err != nil {
+		t.Fatalf("New() unexpectedly failed: %v", err)
+	}
+	// Both the settled and the open lease are restored.
+	if got, want := l.Balance("some-peer").GetLentSeconds(), 0.2; !near(got, want) {
+		t.Errorf("GetLentSeconds() = %v, want = %v", got, want)
+	}
+}
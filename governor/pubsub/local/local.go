@@ -1,91 +1,948 @@
 package local
 
 import (
+	"context"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
+	"github.com/kevmo314/fedtorch/governor/metadata/telemetry"
+	"github.com/kevmo314/fedtorch/governor/pubsub/clock"
+	"github.com/kevmo314/fedtorch/governor/pubsub/expiry"
+	"github.com/kevmo314/fedtorch/governor/pubsub/fit"
+	"github.com/kevmo314/fedtorch/governor/pubsub/journal"
+	"github.com/kevmo314/fedtorch/governor/pubsub/token"
+	"google.golang.org/protobuf/proto"
+
 	gpupb "github.com/kevmo314/fedtorch/governor/api/go/gpu"
 	tpb "google.golang.org/protobuf/types/known/timestamppb"
 )
 
+const (
+	// epsilon is the tolerance when summing lease fractions, so that e.g.
+	// ten leases of 0.1 may share a single device.
+	epsilon = 1e-9
+
+	// noticeBufferSize is the number of undelivered preemption notices
+	// kept before further notices are dropped.
+	noticeBufferSize = 64
+
+	// DefaultHold is how long tentative holds are kept if Reserve is not
+	// given a hold duration.
+	DefaultHold = time.Minute
+)
+
 type Allocator struct {
 	// gpus is immutable after construction
 	gpus []*gpupb.GPU
 
-	l      sync.Mutex
-	leases map[int32]*gpupb.Lease
+	l sync.Mutex
+
+	// leases maps a device ID to the leases currently held on the device,
+	// keyed by token. A device may be shared by several fractional
+	// leases.
+	leases map[int32]map[string]*gpupb.Lease
+
+	// requestors maps each token with leases in the inventory to the
+	// requestor which first leased under the token. A live token may not
+	// be used by another requestor.
+	requestors map[string]string
+
+	// expiry removes each lease once it expires. Leases are scheduled
+	// under their key whenever they are set, and cancelled when they are
+	// removed.
+	expiry *expiry.S[key]
+	grace  time.Duration
+
+	// clock tells the time for lease expirations.
+	clock clock.Clock
+
+	// journal persists changes to leases. May be nil.
+	journal *journal.J
+
+	// monitor annotates GPUs with their current telemetry. May be nil.
+	monitor *telemetry.M
+
+	// owner is the peer ID whose requests may preempt remote leases. If
+	// empty, leases are never preempted.
+	owner   string
+	notice  time.Duration
+	notices chan *gpupb.Lease
+
+	// freed is signalled whenever a lease is removed.
+	freed chan struct{}
+
+	// pending tracks the leases granted on GPUs which preempted leases
+	// have yet to vacate. Pending leases are tentative, and only start
+	// once all preempted leases on the GPU have been removed.
+	pending map[key]*start
+}
+
+// start tracks a pending lease.
+type start struct {
+	// d is the duration of the lease once it starts.
+	d time.Duration
 
-	returnGPU chan *gpupb.Lease
-	grace     time.Duration
+	// started is closed once the lease starts, or is removed before it
+	// starts.
+	started chan struct{}
+}
+
+// key identifies a lease by its device and token.
+type key struct {
+	id    int32
+	token string
 }
 
 func New(gpus []*gpupb.GPU, grace time.Duration) *Allocator {
-	a := &Allocator{
-		gpus:      gpus,
-		leases:    make(map[int32]*gpupb.Lease),
-		returnGPU: make(chan *gpupb.Lease),
-		grace:     grace,
+	return &Allocator{
+		gpus:       gpus,
+		leases:     make(map[int32]map[string]*gpupb.Lease),
+		requestors: make(map[string]string),
+		pending:    make(map[key]*start),
+		expiry:     expiry.New[key](clock.Real),
+		freed:      make(chan struct{}, 1),
+		grace:      grace,
+		clock:      clock.Real,
 	}
-	go a.daemon()
-	return a
 }
 
+// Close stops all pending lease expirations, and blocks until any running
+// expiration has finished. Leases are not released, and are restored from
+// the journal, if any, by the next allocator.
+func (a *Allocator) Close() { a.expiry.Close() }
+
 func (a *Allocator) Get(x int32) *gpupb.GPU { return a.gpus[x] }
 
-func (a *Allocator) daemon() {
-	for l := range a.returnGPU {
-		func() {
-			a.l.Lock()
-			defer a.l.Unlock()
+// Restore replays the input journal, restoring all unexpired leases on GPUs
+// in the local inventory, and records all subsequent lease changes to the
+// journal. Restore should be called once on startup, before any leases are
+// granted.
+func (a *Allocator) Restore(j *journal.J) error {
+	leases, err := j.Replay()
+	if err != nil {
+		return err
+	}
+
+	ids := map[int32]bool{}
+	for _, g := range a.gpus {
+		ids[g.GetId()] = true
+	}
+
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	for _, l := range leases {
+		// Claims are made by local tasks, which do not outlive the
+		// governor.
+		if !ids[l.GetGpu().GetId()] || l.GetExpiration() == nil || a.expired(l) {
+			continue
+		}
+		a.set(l)
+
+		// The journal does not record requestors, so fall back to
+		// the requestor the token is bound to.
+		a.requestors[l.GetToken()] = token.Requestor(l.GetToken())
+	}
+	a.journal = j
+
+	// Drop expired and unknown leases from the journal.
+	a.snapshot()
+	return nil
+}
+
+// Monitor attaches the current telemetry of the local GPUs to subsequent
+// leases and offers, and excludes unhealthy GPUs from being leased. Monitor
+// should be called once on startup, before any leases are granted.
+func (a *Allocator) Monitor(m *telemetry.M) { a.monitor = m }
+
+// UseClock expires leases according to the input clock instead of the wall
+// clock. UseClock should be called once on startup, before any leases are
+// granted.
+func (a *Allocator) UseClock(c clock.Clock) {
+	a.expiry.Close()
+	a.expiry = expiry.New[key](c)
+	a.clock = c
+}
+
+// Preemptible allows requests issued by the input owner to preempt remote
+// leases, i.e. leases not bound to the owner, of a strictly lower priority.
+// Preempted leases are cut short to the input notice period, so that the
+// holder may checkpoint before the device is handed over. Preemptible should
+// be called once on startup, before any leases are granted.
+func (a *Allocator) Preemptible(owner string, notice time.Duration) {
+	a.owner = owner
+	a.notice = notice
+	a.notices = make(chan *gpupb.Lease, noticeBufferSize)
+}
+
+// Preemptions returns the leases which have been preempted, with their
+// expiration set to the end of the notice period. Notices are dropped if the
+// channel is not drained. The channel is nil unless Preemptible was called.
+func (a *Allocator) Preemptions() <-chan *gpupb.Lease { return a.notices }
 
-			m, ok := a.leases[l.GetGpu().GetId()]
-			if !ok {
-				return
+// Freed returns a channel which is signalled whenever a GPU may have been
+// freed, i.e. when a lease is released, aborted, or expires. Signals are
+// coalesced if the channel is not drained.
+func (a *Allocator) Freed() <-chan struct{} { return a.freed }
+
+// Expirations returns the expiration times of the current leases, in
+// ascending order, as an estimate of when GPUs will be freed.
+func (a *Allocator) Expirations() []time.Time {
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	var expirations []time.Time
+	for _, leases := range a.leases {
+		for _, l := range leases {
+			if l.GetExpiration() != nil && !a.expired(l) {
+				expirations = append(expirations, l.GetExpiration().AsTime())
 			}
+		}
+	}
+	sort.Slice(expirations, func(i, j int) bool { return expirations[i].Before(expirations[j]) })
+	return expirations
+}
+
+// Leases returns copies of the leases and holds currently held under the
+// input token, with their states as of now, i.e. leases which have expired
+// but have not yet been removed are reported as expired.
+func (a *Allocator) Leases(token string) []*gpupb.Lease {
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	var leases []*gpupb.Lease
+	for _, m := range a.leases {
+		if l, ok := m[token]; ok {
+			l = proto.Clone(l).(*gpupb.Lease)
+			l.State = a.state(l)
+			leases = append(leases, l)
+		}
+	}
+	sort.Slice(leases, func(i, j int) bool { return leases[i].GetGpu().GetId() < leases[j].GetGpu().GetId() })
+	return leases
+}
+
+// Holders returns the tokens of the unexpired leases and holds on the input
+// device, in sorted order.
+func (a *Allocator) Holders(id int32) []string {
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	var tokens []string
+	for t, l := range a.leases[id] {
+		if !a.expired(l) {
+			tokens = append(tokens, t)
+		}
+	}
+	sort.Strings(tokens)
+	return tokens
+}
+
+// Unused returns the healthy GPUs which are not held by any unexpired lease or
+// hold.
+func (a *Allocator) Unused() []*gpupb.GPU {
+	a.l.Lock()
+	defer a.l.Unlock()
 
-			if l.GetToken() != m.GetToken() {
-				return
+	var gpus []*gpupb.GPU
+	for _, g := range a.available() {
+		var held bool
+		for _, l := range a.leases[g.GetId()] {
+			if !a.expired(l) {
+				held = true
+				break
 			}
+		}
+		if !held {
+			gpus = append(gpus, g)
+		}
+	}
+	return gpus
+}
 
-			// Do not check expiration time -- it is possible for us
-			// to have an early return event.
-			delete(a.leases, l.GetGpu().GetId())
-		}()
+// state returns the current state of the input lease.
+func (a *Allocator) state(l *gpupb.Lease) gpupb.Lease_State {
+	switch {
+	case a.expired(l):
+		return gpupb.Lease_STATE_EXPIRED
+	case l.GetPreempted():
+		return gpupb.Lease_STATE_RELEASING
+	case l.GetState() == gpupb.Lease_STATE_UNKNOWN:
+		return gpupb.Lease_STATE_COMMITTED
 	}
+	return l.GetState()
 }
 
-func (a *Allocator) Lease(req *gpupb.LeaseRequest) (*gpupb.LeaseResponse, error) {
-	expiration := time.Now().Add(req.GetDuration().AsDuration()).Add(a.grace)
+// expired checks if the input lease has expired. Claims never expire.
+func (a *Allocator) expired(l *gpupb.Lease) bool {
+	return l.GetExpiration() != nil && a.clock.Now().After(l.GetExpiration().AsTime())
+}
+
+// available returns the GPUs which may currently be leased out, regardless of
+// existing leases.
+func (a *Allocator) available() []*gpupb.GPU {
+	if a.monitor == nil {
+		return a.gpus
+	}
+	return a.monitor.Available(a.gpus)
+}
+
+// Lease leases the free GPU which best fits the request constraints. Of the
+// GPUs with enough free capacity for a fractional request, the most heavily
+// shared GPU is preferred, so that whole GPUs remain free for exclusive
+// requests. If no GPU is free, requests from the owner may preempt lower
+// priority remote leases.
+//
+// Lease does not wait for GPUs to free up, and only fails early if ctx is
+// already done. Lease fails if the request token is held by the unexpired
+// leases of another requestor.
+//
+// If the leased GPU is still being vacated by preempted leases, Lease waits
+// for the GPU to be vacated, and the lease only starts once it is. If ctx is
+// done first, the lease is released and Lease fails.
+func (a *Allocator) Lease(ctx context.Context, req *gpupb.LeaseRequest) (*gpupb.LeaseResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return &gpupb.LeaseResponse{Requestor: req.GetRequestor()}, err
+	}
+	f, err := fraction(req.GetFraction())
+	if err != nil {
+		return &gpupb.LeaseResponse{Requestor: req.GetRequestor()}, err
+	}
+
+	d := req.GetDuration().AsDuration() + a.grace
+	var s *start
 	l, err := func() (*gpupb.Lease, error) {
 		a.l.Lock()
 		defer a.l.Unlock()
 
-		for _, g := range a.gpus {
-			l, ok := a.leases[g.GetId()]
-			if !ok || time.Now().After(l.GetExpiration().AsTime()) {
-				m := &gpupb.Lease{
-					Token:      req.GetToken(),
-					Gpu:        g,
-					Expiration: tpb.New(expiration),
-				}
-				a.leases[g.GetId()] = m
-
-				return m, nil
+		if err := a.check(req.GetToken(), req.GetRequestor()); err != nil {
+			return nil, err
+		}
+		gpus := a.candidates(req, f)
+		if len(gpus) == 0 {
+			gpus = a.preempt(req, f, 1)
+		}
+		for _, g := range gpus {
+			m := &gpupb.Lease{
+				Token:      req.GetToken(),
+				Gpu:        g,
+				Expiration: tpb.New(a.clock.Now().Add(d)),
+				Fraction:   f,
+				Priority:   req.GetPriority(),
+				State:      gpupb.Lease_STATE_COMMITTED,
 			}
+			t, vacating := a.vacating(g.GetId())
+			if vacating {
+				m.Expiration = tpb.New(t.Add(d))
+				m.State = gpupb.Lease_STATE_TENTATIVE
+			}
+			if err := a.put(m); err != nil {
+				return nil, err
+			}
+			a.requestors[req.GetToken()] = req.GetRequestor()
+			if vacating {
+				s = a.wait(m, d)
+			}
+			return m, nil
 		}
 
 		return nil, fmt.Errorf("no local GPU available")
 	}()
-
-	if err != nil {
-		go func(l *gpupb.Lease) {
-			time.Sleep(time.Until(expiration))
-			a.returnGPU <- l
-		}(l)
+	if err == nil && s != nil {
+		l, err = a.await(ctx, l, s)
 	}
+
 	return &gpupb.LeaseResponse{
 		Requestor: req.GetRequestor(),
 		Lease:     l,
 	}, err
 }
+
+// Claim exclusively leases up to n free GPUs to the input local task, in
+// inventory order. Fewer than n GPUs are returned if the local host does not
+// have enough free capacity.
+//
+// Claims do not expire, cannot be preempted, and are not restored from the
+// journal; they are held until released.
+func (a *Allocator) Claim(task string, n int) []*gpupb.Lease {
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	if a.check(task, "") != nil {
+		return nil
+	}
+
+	var leases []*gpupb.Lease
+	for _, g := range a.candidates(&gpupb.LeaseRequest{Token: task}, 1) {
+		if len(leases) >= n {
+			break
+		}
+		m := &gpupb.Lease{
+			Token:    task,
+			Gpu:      g,
+			Fraction: 1,
+			State:    gpupb.Lease_STATE_COMMITTED,
+		}
+		if err := a.put(m); err != nil {
+			break
+		}
+		a.requestors[task] = ""
+		leases = append(leases, m)
+	}
+	return leases
+}
+
+// Reserve places a tentative hold on up to n free GPUs for the input gang
+// request, preferring GPUs which best fit the request constraints. The hold
+// is released after the input hold duration unless it is committed first.
+// Reserve returns an error if no GPUs are free, or if the request token is
+// held by the unexpired leases of another requestor.
+func (a *Allocator) Reserve(req *gpupb.LeaseRequest, n int, hold time.Duration) ([]*gpupb.Lease, error) {
+	f, err := fraction(req.GetFraction())
+	if err != nil {
+		return nil, err
+	}
+
+	if hold <= 0 {
+		hold = DefaultHold
+	}
+	expiration := a.clock.Now().Add(hold)
+
+	var leases []*gpupb.Lease
+	if err := func() error {
+		a.l.Lock()
+		defer a.l.Unlock()
+
+		if err := a.check(req.GetToken(), req.GetRequestor()); err != nil {
+			return err
+		}
+		gpus := a.candidates(req, f)
+		if len(gpus) < n {
+			gpus = append(gpus, a.preempt(req, f, n-len(gpus))...)
+		}
+		for _, g := range gpus {
+			if len(leases) >= n {
+				break
+			}
+			m := &gpupb.Lease{
+				Token:      req.GetToken(),
+				Gpu:        g,
+				Expiration: tpb.New(expiration),
+				Fraction:   f,
+				Priority:   req.GetPriority(),
+				State:      gpupb.Lease_STATE_TENTATIVE,
+			}
+			if err := a.put(m); err != nil {
+				break
+			}
+			a.requestors[req.GetToken()] = req.GetRequestor()
+			leases = append(leases, m)
+		}
+		return nil
+	}(); err != nil {
+		return nil, err
+	}
+
+	if len(leases) == 0 {
+		return nil, fmt.Errorf("no local GPU available")
+	}
+	return leases, nil
+}
+
+// Commit converts the tentative holds on the input devices into committed
+// leases of duration d. Any other tentative holds under the same token are
+// released. Commit returns an error if any of the input holds no longer exist,
+// e.g. if they have already expired, or have already been committed; holds
+// which do exist are still committed.
+//
+// Leases on GPUs which are still being vacated by preempted leases remain
+// tentative, and only start once the GPU is vacated.
+func (a *Allocator) Commit(token string, ids []int32, d time.Duration) ([]*gpupb.Lease, error) {
+	committed := map[int32]bool{}
+	for _, id := range ids {
+		committed[id] = true
+	}
+
+	var leases []*gpupb.Lease
+	var missing []int32
+	func() {
+		a.l.Lock()
+		defer a.l.Unlock()
+
+		for _, id := range ids {
+			m, ok := a.leases[id][token]
+			if !ok || a.state(m) != gpupb.Lease_STATE_TENTATIVE || a.pending[key{id: id, token: token}] != nil {
+				missing = append(missing, id)
+				continue
+			}
+			l := &gpupb.Lease{
+				Token:      token,
+				Gpu:        m.GetGpu(),
+				Expiration: tpb.New(a.clock.Now().Add(d).Add(a.grace)),
+				Fraction:   m.GetFraction(),
+				Priority:   m.GetPriority(),
+				State:      gpupb.Lease_STATE_COMMITTED,
+			}
+			t, vacating := a.vacating(id)
+			if vacating {
+				l.Expiration = tpb.New(t.Add(d).Add(a.grace))
+				l.State = gpupb.Lease_STATE_TENTATIVE
+			}
+			if err := a.put(l); err != nil {
+				missing = append(missing, id)
+				continue
+			}
+			if vacating {
+				a.wait(l, d+a.grace)
+			}
+			leases = append(leases, l)
+		}
+		for id, leases := range a.leases {
+			if m, ok := leases[token]; ok && !committed[id] && a.hold(m) {
+				a.remove(m)
+			}
+		}
+	}()
+
+	if len(missing) > 0 {
+		return leases, fmt.Errorf("no reservations found for token %v on GPUs %v", token, missing)
+	}
+	return leases, nil
+}
+
+// Abort releases all tentative holds under the input token, and returns the
+// number of devices released. Committed leases are not affected, and must be
+// released individually.
+func (a *Allocator) Abort(token string) int {
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	var n int
+	for _, leases := range a.leases {
+		if m, ok := leases[token]; ok && a.hold(m) {
+			a.remove(m)
+			n++
+		}
+	}
+	return n
+}
+
+// hold checks if the input lease is a tentative hold, i.e. is tentative but
+// is not a pending lease. The caller must hold the allocator lock.
+func (a *Allocator) hold(l *gpupb.Lease) bool {
+	_, ok := a.pending[key{id: l.GetGpu().GetId(), token: l.GetToken()}]
+	return l.GetState() == gpupb.Lease_STATE_TENTATIVE && !ok
+}
+
+// Release returns a leased GPU to the free pool before the lease expires. The
+// input lease token must match the token of a lease currently held on the
+// GPU.
+func (a *Allocator) Release(l *gpupb.Lease) error {
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	m, ok := a.leases[l.GetGpu().GetId()][l.GetToken()]
+	if !ok {
+		return fmt.Errorf("no lease found for token %v on GPU %v", l.GetToken(), l.GetGpu().GetId())
+	}
+
+	a.remove(m)
+	return nil
+}
+
+// Renew extends an unexpired committed lease by duration d from now. The input
+// lease token must match the token of a lease currently held on the GPU.
+// Tentative holds cannot be renewed, and must be committed instead.
+func (a *Allocator) Renew(l *gpupb.Lease, d time.Duration) (*gpupb.Lease, error) {
+	expiration := a.clock.Now().Add(d).Add(a.grace)
+
+	m, err := func() (*gpupb.Lease, error) {
+		a.l.Lock()
+		defer a.l.Unlock()
+
+		m, ok := a.leases[l.GetGpu().GetId()][l.GetToken()]
+		if !ok {
+			return nil, fmt.Errorf("no lease found for token %v on GPU %v", l.GetToken(), l.GetGpu().GetId())
+		}
+		if m.GetExpiration() == nil {
+			return nil, fmt.Errorf("GPU %v is claimed by task %v, and cannot be renewed", l.GetGpu().GetId(), l.GetToken())
+		}
+		if a.expired(m) {
+			return nil, fmt.Errorf("lease for token %v on GPU %v has already expired", l.GetToken(), l.GetGpu().GetId())
+		}
+		if m.GetPreempted() {
+			return nil, fmt.Errorf("lease for token %v on GPU %v has been preempted", l.GetToken(), l.GetGpu().GetId())
+		}
+		if m.GetState() == gpupb.Lease_STATE_TENTATIVE {
+			return nil, fmt.Errorf("lease for token %v on GPU %v has not been committed", l.GetToken(), l.GetGpu().GetId())
+		}
+
+		m = &gpupb.Lease{
+			Token:      m.GetToken(),
+			Gpu:        m.GetGpu(),
+			Expiration: tpb.New(expiration),
+			Fraction:   m.GetFraction(),
+			Priority:   m.GetPriority(),
+			State:      gpupb.Lease_STATE_COMMITTED,
+		}
+		if err := a.put(m); err != nil {
+			return nil, err
+		}
+		return m, nil
+	}()
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// expire returns the GPU held by the input lease to the free pool once the
+// lease expires, provided the lease has not since been replaced.
+func (a *Allocator) expire(l *gpupb.Lease) {
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	if m, ok := a.leases[l.GetGpu().GetId()][l.GetToken()]; ok && m == l {
+		a.remove(m)
+	}
+}
+
+// check returns an error if the input token is held by an unexpired lease or
+// hold of a requestor other than the input requestor, so that a request cannot
+// take over or extend another requestor's leases by reusing its token. The
+// caller must hold the allocator lock.
+func (a *Allocator) check(tok string, requestor string) error {
+	r, ok := a.requestors[tok]
+	if !ok || r == requestor {
+		return nil
+	}
+	for _, leases := range a.leases {
+		if l, ok := leases[tok]; ok && !a.expired(l) {
+			return fmt.Errorf("token %v is held by another requestor", tok)
+		}
+	}
+	return nil
+}
+
+// candidates returns the GPUs which satisfy the request constraints and have
+// at least fraction f of the device free, in order of preference. GPUs already
+// held under the request token are excluded, until the existing lease
+// expires. The caller must hold the allocator lock.
+func (a *Allocator) candidates(req *gpupb.LeaseRequest, f float64) []*gpupb.GPU {
+	var gpus []*gpupb.GPU
+	used := map[int32]float64{}
+	for _, g := range fit.Rank(req.GetConstraints(), a.available()) {
+		if l, ok := a.leases[g.GetId()][req.GetToken()]; ok && !a.expired(l) {
+			continue
+		}
+		u := a.used(g.GetId())
+		if u+f > 1+epsilon {
+			continue
+		}
+		used[g.GetId()] = u
+		gpus = append(gpus, g)
+	}
+
+	// Pack fractional leases onto already shared GPUs first. Exclusive
+	// requests only fit unused GPUs, and so are unaffected.
+	sort.SliceStable(gpus, func(i, j int) bool {
+		return used[gpus[i].GetId()] > used[gpus[j].GetId()]
+	})
+	return gpus
+}
+
+// used returns the fraction of the input GPU held by unexpired leases.
+// Preempted leases are vacating the GPU, and do not count against its
+// capacity. The caller must hold the allocator lock.
+func (a *Allocator) used(id int32) float64 {
+	var u float64
+	for _, l := range a.leases[id] {
+		if l.GetPreempted() || a.expired(l) {
+			continue
+		}
+		u += share(l)
+	}
+	return u
+}
+
+// preempt frees up to n GPUs which satisfy the request constraints by
+// preempting lower priority remote leases, and returns the freed GPUs. Only
+// requests from the owner may preempt leases. On each GPU, the lowest priority
+// leases are preempted first, until there is room for the request. The caller
+// must hold the allocator lock.
+func (a *Allocator) preempt(req *gpupb.LeaseRequest, f float64, n int) []*gpupb.GPU {
+	if a.owner == "" || req.GetRequestor() != a.owner {
+		return nil
+	}
+
+	var gpus []*gpupb.GPU
+	for _, g := range fit.Rank(req.GetConstraints(), a.available()) {
+		if len(gpus) >= n {
+			break
+		}
+		if l, ok := a.leases[g.GetId()][req.GetToken()]; ok && !a.expired(l) {
+			continue
+		}
+
+		var victims []*gpupb.Lease
+		for _, l := range a.leases[g.GetId()] {
+			if l.GetPreempted() || l.GetExpiration() == nil || a.expired(l) {
+				continue
+			}
+			if l.GetPriority() < req.GetPriority() && token.Requestor(l.GetToken()) != a.owner {
+				victims = append(victims, l)
+			}
+		}
+		sort.SliceStable(victims, func(i, j int) bool {
+			return victims[i].GetPriority() < victims[j].GetPriority()
+		})
+
+		u := a.used(g.GetId())
+		var k int
+		for ; k < len(victims) && u+f > 1+epsilon; k++ {
+			u -= share(victims[k])
+		}
+		if u+f > 1+epsilon {
+			continue
+		}
+
+		for _, l := range victims[:k] {
+			a.revoke(l)
+		}
+		gpus = append(gpus, g)
+	}
+	return gpus
+}
+
+// revoke marks the input lease as preempted, cuts the lease short to the
+// notice period, and notifies the lease holder. The caller must hold the
+// allocator lock.
+func (a *Allocator) revoke(l *gpupb.Lease) {
+	expiration := a.clock.Now().Add(a.notice)
+	if l.GetExpiration().AsTime().Before(expiration) {
+		expiration = l.GetExpiration().AsTime()
+	}
+	m := &gpupb.Lease{
+		Token:      l.GetToken(),
+		Gpu:        l.GetGpu(),
+		Expiration: tpb.New(expiration),
+		Fraction:   l.GetFraction(),
+		Priority:   l.GetPriority(),
+		Preempted:  true,
+		State:      gpupb.Lease_STATE_RELEASING,
+	}
+
+	// A failed journal write is benign, as the preempted lease is still
+	// restored, albeit with its original expiration.
+	if err := a.put(m); err != nil {
+		a.set(m)
+	}
+
+	select {
+	case a.notices <- m:
+	default:
+	}
+}
+
+// vacating returns the time by which the preempted leases on the input GPU
+// will have vacated the GPU, or false if there are no such leases. The caller
+// must hold the allocator lock.
+func (a *Allocator) vacating(id int32) (time.Time, bool) {
+	var t time.Time
+	var ok bool
+	for _, l := range a.leases[id] {
+		if !l.GetPreempted() || a.expired(l) {
+			continue
+		}
+		if e := l.GetExpiration().AsTime(); e.After(t) {
+			t = e
+		}
+		ok = true
+	}
+	return t, ok
+}
+
+// wait marks the input lease as pending until the GPU is vacated, at which
+// point the lease starts with duration d. The caller must hold the allocator
+// lock.
+func (a *Allocator) wait(l *gpupb.Lease, d time.Duration) *start {
+	s := &start{
+		d:       d,
+		started: make(chan struct{}),
+	}
+	a.pending[key{id: l.GetGpu().GetId(), token: l.GetToken()}] = s
+	return s
+}
+
+// await blocks until the input pending lease starts, and returns the started
+// lease. If ctx is done first, the lease is released. await returns an error if
+// the lease is removed before it starts.
+func (a *Allocator) await(ctx context.Context, l *gpupb.Lease, s *start) (*gpupb.Lease, error) {
+	select {
+	case <-s.started:
+	case <-ctx.Done():
+	}
+
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	k := key{id: l.GetGpu().GetId(), token: l.GetToken()}
+	m, ok := a.leases[k.id][k.token]
+	if a.pending[k] == s {
+		if ok {
+			a.remove(m)
+		}
+		return nil, fmt.Errorf("GPU %v was not vacated in time: %w", k.id, ctx.Err())
+	}
+	if !ok || m.GetState() != gpupb.Lease_STATE_COMMITTED {
+		return nil, fmt.Errorf("lease for token %v on GPU %v was removed before it started", k.token, k.id)
+	}
+	return m, nil
+}
+
+// begin starts the pending leases on the input GPU once all preempted leases
+// have vacated the GPU. The caller must hold the allocator lock.
+func (a *Allocator) begin(id int32) {
+	if _, ok := a.vacating(id); ok {
+		return
+	}
+	for k, s := range a.pending {
+		if k.id != id {
+			continue
+		}
+		delete(a.pending, k)
+
+		m := a.leases[k.id][k.token]
+		l := &gpupb.Lease{
+			Token:      m.GetToken(),
+			Gpu:        m.GetGpu(),
+			Expiration: tpb.New(a.clock.Now().Add(s.d)),
+			Fraction:   m.GetFraction(),
+			Priority:   m.GetPriority(),
+			State:      gpupb.Lease_STATE_COMMITTED,
+		}
+
+		// A failed journal write is benign, as the pending lease is
+		// still restored, albeit as a tentative lease.
+		if err := a.put(l); err != nil {
+			a.set(l)
+		}
+		close(s.started)
+	}
+}
+
+// fraction validates the input requested fraction of a device, and returns
+// the fraction to be leased.
+func fraction(f float64) (float64, error) {
+	if f == 0 {
+		return 1, nil
+	}
+	if f < 0 || f > 1 {
+		return 0, fmt.Errorf("invalid GPU fraction %v, must be in (0, 1]", f)
+	}
+	return f, nil
+}
+
+// share returns the fraction of the device held by the input lease.
+func share(l *gpupb.Lease) float64 {
+	if l.GetFraction() == 0 {
+		return 1
+	}
+	return l.GetFraction()
+}
+
+// put sets the lease held by the lease token on the leased GPU, and records
+// the lease in the journal. The caller must hold the allocator lock.
+func (a *Allocator) put(l *gpupb.Lease) error {
+	if a.journal != nil {
+		if err := a.journal.Put(l); err != nil {
+			return err
+		}
+	}
+	a.set(l)
+	a.compact()
+	return nil
+}
+
+// set sets the lease held by the lease token on the leased GPU, and schedules
+// the lease to expire, replacing the expiration of any previous lease under
+// the same token. The caller must hold the allocator lock.
+func (a *Allocator) set(l *gpupb.Lease) {
+	id := l.GetGpu().GetId()
+	if a.leases[id] == nil {
+		a.leases[id] = make(map[string]*gpupb.Lease)
+	}
+	a.leases[id][l.GetToken()] = l
+	if l.GetExpiration() == nil {
+		a.expiry.Cancel(key{id: id, token: l.GetToken()})
+		return
+	}
+	a.expiry.Schedule(key{id: id, token: l.GetToken()}, l.GetExpiration().AsTime(), func() { a.expire(l) })
+}
+
+// remove frees the share of the GPU held by the input lease, and records the
+// release in the journal. The caller must hold the allocator lock.
+func (a *Allocator) remove(l *gpupb.Lease) {
+	id := l.GetGpu().GetId()
+	if s, ok := a.pending[key{id: id, token: l.GetToken()}]; ok {
+		delete(a.pending, key{id: id, token: l.GetToken()})
+		close(s.started)
+	}
+	delete(a.leases[id], l.GetToken())
+	if len(a.leases[id]) == 0 {
+		delete(a.leases, id)
+	}
+	a.expiry.Cancel(key{id: id, token: l.GetToken()})
+	if !a.holds(l.GetToken()) {
+		delete(a.requestors, l.GetToken())
+	}
+	select {
+	case a.freed <- struct{}{}:
+	default:
+	}
+	if a.journal != nil {
+		// A failed write is benign, as the lease will only be
+		// restored until it expires.
+		a.journal.Delete(l)
+	}
+	a.compact()
+
+	if l.GetPreempted() {
+		a.begin(id)
+	}
+}
+
+// holds checks if any lease or hold, expired or not, remains under the input
+// token. The caller must hold the allocator lock.
+func (a *Allocator) holds(tok string) bool {
+	for _, leases := range a.leases {
+		if _, ok := leases[tok]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// compact snapshots the current leases into the journal if the journal has
+// grown too large. The caller must hold the allocator lock.
+func (a *Allocator) compact() {
+	if a.journal != nil && a.journal.ShouldCompact() {
+		a.snapshot()
+	}
+}
+
+// snapshot replaces the journal with the current leases. The caller must hold
+// the allocator lock.
+func (a *Allocator) snapshot() {
+	var leases []*gpupb.Lease
+	for _, m := range a.leases {
+		for _, l := range m {
+			leases = append(leases, l)
+		}
+	}
+
+	// A failed compaction leaves the journal intact.
+	a.journal.Compact(leases)
+}
@@ -1,9 +1,19 @@
 package local
 
 import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"runtime"
 	"testing"
 	"time"
 
+	"github.com/kevmo314/fedtorch/governor/metadata/telemetry"
+	"github.com/kevmo314/fedtorch/governor/pubsub/clock"
+	"github.com/kevmo314/fedtorch/governor/pubsub/journal"
+	"github.com/kevmo314/fedtorch/governor/pubsub/token"
+	"go.uber.org/goleak"
+
 	gpupb "github.com/kevmo314/fedtorch/governor/api/go/gpu"
 	dpb "google.golang.org/protobuf/types/known/durationpb"
 	tpb "google.golang.org/protobuf/types/known/timestamppb"
@@ -32,17 +42,21 @@ func TestLease(t *testing.T) {
 		{
 			name: "Full",
 			a: &Allocator{
+				clock: clock.Real,
 				gpus: []*gpupb.GPU{
 					&gpupb.GPU{
 						Id: 100,
 					},
 				},
-				leases: map[int32]*gpupb.Lease{
-					100: &gpupb.Lease{
-						Gpu: &gpupb.GPU{
-							Id: 100,
+				leases: map[int32]map[string]*gpupb.Lease{
+					100: map[string]*gpupb.Lease{
+						"other-token": &gpupb.Lease{
+							Token: "other-token",
+							Gpu: &gpupb.GPU{
+								Id: 100,
+							},
+							Expiration: tpb.New(time.Now().Add(time.Hour)),
 						},
-						Expiration: tpb.New(time.Now().Add(time.Hour)),
 					},
 				},
 			},
@@ -52,7 +66,7 @@ func TestLease(t *testing.T) {
 
 	for _, c := range configs {
 		t.Run(c.name, func(t *testing.T) {
-			l, err := c.a.Lease(&gpupb.LeaseRequest{
+			l, err := c.a.Lease(context.Background(), &gpupb.LeaseRequest{
 				Duration: dpb.New(time.Minute),
 			})
 			if !c.succ && err == nil {
@@ -64,6 +78,377 @@ func TestLease(t *testing.T) {
 	}
 }
 
+func TestLeaseConstraints(t *testing.T) {
+	gpus := []*gpupb.GPU{
+		&gpupb.GPU{
+			Id:     100,
+			Name:   "NVIDIA A100",
+			Memory: 80 << 30,
+		},
+		&gpupb.GPU{
+			Id:     101,
+			Name:   "NVIDIA A100",
+			Memory: 40 << 30,
+		},
+	}
+
+	configs := []struct {
+		name string
+		c    *gpupb.Constraints
+		want int32
+		succ bool
+	}{
+		{
+			name: "BestFit",
+			c: &gpupb.Constraints{
+				MinMemory: 32 << 30,
+			},
+			want: 101,
+			succ: true,
+		},
+		{
+			name: "MinMemory",
+			c: &gpupb.Constraints{
+				MinMemory: 64 << 30,
+			},
+			want: 100,
+			succ: true,
+		},
+		{
+			name: "Unsatisfiable",
+			c: &gpupb.Constraints{
+				Names: []string{"NVIDIA T4"},
+			},
+			succ: false,
+		},
+	}
+
+	for _, c := range configs {
+		t.Run(c.name, func(t *testing.T) {
+			a := New(gpus, 0)
+			resp, err := a.Lease(context.Background(), &gpupb.LeaseRequest{
+				Duration:    dpb.New(time.Minute),
+				Constraints: c.c,
+			})
+			if !c.succ {
+				if err == nil {
+					t.Errorf("Lease() unexpectedly succeeded: %v", resp)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Lease() unexpectedly failed: %v", err)
+			}
+			if got := resp.GetLease().GetGpu().GetId(); got != c.want {
+				t.Errorf("GetId() = %v, want = %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestLeaseMonitor(t *testing.T) {
+	gpus := []*gpupb.GPU{
+		&gpupb.GPU{Id: 100, Memory: 40 << 30},
+		&gpupb.GPU{Id: 101, Memory: 80 << 30},
+		&gpupb.GPU{Id: 102, Memory: 80 << 30},
+	}
+
+	s := &telemetry.Fake{}
+	s.Set(map[int32]*gpupb.Telemetry{
+		// Overheating.
+		100: &gpupb.Telemetry{FreeMemory: 40 << 30, Temperature: 95, Sampled: tpb.Now()},
+		// Failing.
+		101: &gpupb.Telemetry{FreeMemory: 80 << 30, EccErrors: 2, Sampled: tpb.Now()},
+		102: &gpupb.Telemetry{FreeMemory: 60 << 30, Temperature: 60, Sampled: tpb.Now()},
+	}, nil)
+	m := telemetry.New(telemetry.O{Sampler: s})
+	if err := m.Sample(); err != nil {
+		t.Fatalf("Sample() unexpectedly failed: %v", err)
+	}
+
+	a := New(gpus, 0)
+	a.Monitor(m)
+
+	resp, err := a.Lease(context.Background(), &gpupb.LeaseRequest{
+		Duration: dpb.New(time.Minute),
+	})
+	if err != nil {
+		t.Fatalf("Lease() unexpectedly failed: %v", err)
+	}
+	if got, want := resp.GetLease().GetGpu().GetId(), int32(102); got != want {
+		t.Errorf("GetId() = %v, want = %v", got, want)
+	}
+	if got, want := resp.GetLease().GetGpu().GetTelemetry().GetFreeMemory(), int64(60<<30); got != want {
+		t.Errorf("GetFreeMemory() = %v, want = %v", got, want)
+	}
+
+	if resp, err := a.Lease(context.Background(), &gpupb.LeaseRequest{
+		Duration: dpb.New(time.Minute),
+	}); err == nil {
+		t.Errorf("Lease() unexpectedly succeeded: %v", resp)
+	}
+}
+
+func TestLeaseFraction(t *testing.T) {
+	a := New([]*gpupb.GPU{
+		&gpupb.GPU{Id: 100},
+		&gpupb.GPU{Id: 101},
+	}, 0)
+
+	configs := []struct {
+		name     string
+		token    string
+		fraction float64
+		want     int32
+		succ     bool
+	}{
+		{name: "Shared", token: "some-token", fraction: 0.5, want: 100, succ: true},
+		// Fractional leases are packed onto the shared GPU.
+		{name: "Packed", token: "other-token", fraction: 0.3, want: 100, succ: true},
+		{name: "Exclusive", token: "exclusive-token", fraction: 0, want: 101, succ: true},
+		{name: "Full", token: "full-token", fraction: 0.25, succ: false},
+		{name: "Remainder", token: "remainder-token", fraction: 0.2, want: 100, succ: true},
+		{name: "Invalid", token: "invalid-token", fraction: 1.5, succ: false},
+	}
+
+	for _, c := range configs {
+		t.Run(c.name, func(t *testing.T) {
+			resp, err := a.Lease(context.Background(), &gpupb.LeaseRequest{
+				Token:    c.token,
+				Duration: dpb.New(time.Minute),
+				Fraction: c.fraction,
+			})
+			if !c.succ {
+				if err == nil {
+					t.Errorf("Lease() unexpectedly succeeded: %v", resp)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Lease() unexpectedly failed: %v", err)
+			}
+			if got := resp.GetLease().GetGpu().GetId(); got != c.want {
+				t.Errorf("GetId() = %v, want = %v", got, c.want)
+			}
+		})
+	}
+
+	// Releasing a share frees capacity for other fractional leases.
+	if err := a.Release(&gpupb.Lease{
+		Token: "some-token",
+		Gpu:   &gpupb.GPU{Id: 100},
+	}); err != nil {
+		t.Fatalf("Release() unexpectedly failed: %v", err)
+	}
+	resp, err := a.Lease(context.Background(), &gpupb.LeaseRequest{
+		Token:    "full-token",
+		Duration: dpb.New(time.Minute),
+		Fraction: 0.25,
+	})
+	if err != nil {
+		t.Fatalf("Lease() unexpectedly failed: %v", err)
+	}
+	if got, want := resp.GetLease().GetFraction(), 0.25; got != want {
+		t.Errorf("GetFraction() = %v, want = %v", got, want)
+	}
+}
+
+func TestPreempt(t *testing.T) {
+	owner := "some-owner"
+	tok := func(requestor string) string {
+		tok, err := token.New(requestor)
+		if err != nil {
+			t.Fatalf("New() unexpectedly failed: %v", err)
+		}
+		return tok
+	}
+
+	configs := []struct {
+		name string
+		held *gpupb.LeaseRequest
+		req  *gpupb.LeaseRequest
+		succ bool
+	}{
+		{
+			name: "Preempted",
+			held: &gpupb.LeaseRequest{Requestor: "some-request-host", Token: tok("some-request-host")},
+			req:  &gpupb.LeaseRequest{Requestor: owner, Token: tok(owner), Priority: 1},
+			succ: true,
+		},
+		{
+			name: "SamePriority",
+			held: &gpupb.LeaseRequest{Requestor: "some-request-host", Token: tok("some-request-host"), Priority: 1},
+			req:  &gpupb.LeaseRequest{Requestor: owner, Token: tok(owner), Priority: 1},
+			succ: false,
+		},
+		{
+			name: "NotOwner",
+			held: &gpupb.LeaseRequest{Requestor: "some-request-host", Token: tok("some-request-host")},
+			req:  &gpupb.LeaseRequest{Requestor: "other-request-host", Token: tok("other-request-host"), Priority: 1},
+			succ: false,
+		},
+		{
+			name: "OwnerLease",
+			held: &gpupb.LeaseRequest{Requestor: owner, Token: tok(owner)},
+			req:  &gpupb.LeaseRequest{Requestor: owner, Token: tok(owner), Priority: 1},
+			succ: false,
+		},
+	}
+
+	for _, c := range configs {
+		t.Run(c.name, func(t *testing.T) {
+			a := New([]*gpupb.GPU{
+				&gpupb.GPU{Id: 100},
+			}, 0)
+			a.Preemptible(owner, time.Second)
+
+			c.held.Duration = dpb.New(time.Hour)
+			held, err := a.Lease(context.Background(), c.held)
+			if err != nil {
+				t.Fatalf("Lease() unexpectedly failed: %v", err)
+			}
+
+			c.req.Duration = dpb.New(time.Hour)
+			resp, err := a.Lease(context.Background(), c.req)
+			if !c.succ {
+				if err == nil {
+					t.Errorf("Lease() unexpectedly succeeded: %v", resp)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Lease() unexpectedly failed: %v", err)
+			}
+
+			select {
+			case l := <-a.Preemptions():
+				if l.GetToken() != c.held.GetToken() || !l.GetPreempted() {
+					t.Errorf("Preemptions() = %v, want a preempted lease for token %v", l, c.held.GetToken())
+				}
+				if l.GetExpiration().AsTime().After(time.Now().Add(time.Second)) {
+					t.Errorf("GetExpiration() = %v, want before the end of the notice period", l.GetExpiration().AsTime())
+				}
+			default:
+				t.Errorf("Preemptions() unexpectedly empty")
+			}
+
+			if _, err := a.Renew(held.GetLease(), time.Hour); err == nil {
+				t.Errorf("Renew() unexpectedly succeeded")
+			}
+		})
+	}
+}
+
+// TestPreemptPending checks that the lease of the preempting owner only
+// starts once the preempted lease has vacated the GPU.
+func TestPreemptPending(t *testing.T) {
+	configs := []struct {
+		name string
+		// vacate ends the notice period of the preempted lease.
+		vacate func(a *Allocator, c *clock.Virtual, held *gpupb.Lease, cancel context.CancelFunc)
+		succ   bool
+	}{
+		{
+			name: "Expired",
+			vacate: func(a *Allocator, c *clock.Virtual, held *gpupb.Lease, cancel context.CancelFunc) {
+				c.Advance(time.Minute)
+			},
+			succ: true,
+		},
+		{
+			name: "Released",
+			vacate: func(a *Allocator, c *clock.Virtual, held *gpupb.Lease, cancel context.CancelFunc) {
+				a.Release(held)
+			},
+			succ: true,
+		},
+		{
+			name: "Cancelled",
+			vacate: func(a *Allocator, c *clock.Virtual, held *gpupb.Lease, cancel context.CancelFunc) {
+				cancel()
+			},
+			succ: false,
+		},
+	}
+
+	for _, c := range configs {
+		t.Run(c.name, func(t *testing.T) {
+			v := clock.NewVirtual(time.Unix(0, 0))
+			a := New([]*gpupb.GPU{
+				&gpupb.GPU{Id: 100},
+			}, 0)
+			a.UseClock(v)
+			a.Preemptible("some-owner", time.Minute)
+			defer a.Close()
+
+			held, err := a.Lease(context.Background(), &gpupb.LeaseRequest{
+				Requestor: "some-request-host",
+				Token:     "some-request-host:token",
+				Duration:  dpb.New(time.Hour),
+			})
+			if err != nil {
+				t.Fatalf("Lease() unexpectedly failed: %v", err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			type result struct {
+				resp *gpupb.LeaseResponse
+				err  error
+			}
+			ch := make(chan result, 1)
+			go func() {
+				resp, err := a.Lease(ctx, &gpupb.LeaseRequest{
+					Requestor: "some-owner",
+					Token:     "some-owner:token",
+					Duration:  dpb.New(time.Hour),
+					Priority:  1,
+				})
+				ch <- result{resp: resp, err: err}
+			}()
+
+			// The owner's lease is tentative while the preempted
+			// lease is still running.
+			for start := time.Now(); len(a.Leases("some-owner:token")) == 0; time.Sleep(10 * time.Millisecond) {
+				if time.Since(start) > time.Second {
+					t.Fatalf("Leases() unexpectedly empty")
+				}
+			}
+			if got := a.Leases("some-owner:token")[0].GetState(); got != gpupb.Lease_STATE_TENTATIVE {
+				t.Errorf("GetState() = %v, want = %v", got, gpupb.Lease_STATE_TENTATIVE)
+			}
+
+			c.vacate(a, v, held.GetLease(), cancel)
+
+			var r result
+			select {
+			case r = <-ch:
+			case <-time.After(time.Second):
+				t.Fatalf("Lease() did not return once the GPU was vacated")
+			}
+			if !c.succ {
+				if r.err == nil {
+					t.Errorf("Lease() unexpectedly succeeded: %v", r.resp)
+				}
+				if got := a.Leases("some-owner:token"); len(got) != 0 {
+					t.Errorf("Leases() = %v, want = []", got)
+				}
+				return
+			}
+			if r.err != nil {
+				t.Fatalf("Lease() unexpectedly failed: %v", r.err)
+			}
+			if got := r.resp.GetLease().GetState(); got != gpupb.Lease_STATE_COMMITTED {
+				t.Errorf("GetState() = %v, want = %v", got, gpupb.Lease_STATE_COMMITTED)
+			}
+			if got, want := r.resp.GetLease().GetExpiration().AsTime(), v.Now().Add(time.Hour); !got.Equal(want) {
+				t.Errorf("GetExpiration() = %v, want = %v", got, want)
+			}
+		})
+	}
+}
+
 func TestReturn(t *testing.T) {
 	a := New([]*gpupb.GPU{
 		&gpupb.GPU{
@@ -71,7 +456,7 @@ func TestReturn(t *testing.T) {
 		},
 	}, 0)
 
-	l, err := a.Lease(&gpupb.LeaseRequest{
+	l, err := a.Lease(context.Background(), &gpupb.LeaseRequest{
 		Duration: dpb.New(time.Second),
 	})
 	if err != nil {
@@ -80,9 +465,635 @@ func TestReturn(t *testing.T) {
 
 	time.Sleep(time.Until(l.GetLease().GetExpiration().AsTime()))
 
-	if _, err := a.Lease(&gpupb.LeaseRequest{
+	if _, err := a.Lease(context.Background(), &gpupb.LeaseRequest{
 		Duration: dpb.New(time.Second),
 	}); err != nil {
 		t.Errorf("Lease unexpectedly failed: %v", err)
 	}
 }
+
+// TestTokenCollision checks that a token held by one requestor cannot be
+// reused by another requestor until all of its leases are gone.
+func TestTokenCollision(t *testing.T) {
+	configs := []struct {
+		name      string
+		requestor string
+		release   bool
+		succ      bool
+	}{
+		{name: "SameRequestor", requestor: "some-request-host", succ: true},
+		{name: "OtherRequestor", requestor: "other-request-host", succ: false},
+		{name: "Released", requestor: "other-request-host", release: true, succ: true},
+	}
+
+	for _, c := range configs {
+		t.Run(c.name, func(t *testing.T) {
+			a := New([]*gpupb.GPU{
+				&gpupb.GPU{Id: 100},
+				&gpupb.GPU{Id: 101},
+			}, 0)
+
+			resp, err := a.Lease(context.Background(), &gpupb.LeaseRequest{
+				Requestor: "some-request-host",
+				Token:     "some-token",
+				Duration:  dpb.New(time.Hour),
+			})
+			if err != nil {
+				t.Fatalf("Lease() unexpectedly failed: %v", err)
+			}
+			if c.release {
+				if err := a.Release(resp.GetLease()); err != nil {
+					t.Fatalf("Release() unexpectedly failed: %v", err)
+				}
+			}
+
+			req := &gpupb.LeaseRequest{
+				Requestor: c.requestor,
+				Token:     "some-token",
+				Duration:  dpb.New(time.Hour),
+			}
+			if _, err := a.Lease(context.Background(), req); (err == nil) != c.succ {
+				t.Errorf("Lease() = %v, want success = %v", err, c.succ)
+			}
+			if _, err := a.Reserve(req, 1, time.Minute); !c.succ && err == nil {
+				t.Errorf("Reserve() unexpectedly succeeded")
+			}
+		})
+	}
+}
+
+// TestClaim checks that claims by local tasks never expire or are preempted,
+// and can only be released.
+func TestClaim(t *testing.T) {
+	owner := "some-owner"
+	tok, err := token.New(owner)
+	if err != nil {
+		t.Fatalf("New() unexpectedly failed: %v", err)
+	}
+
+	c := clock.NewVirtual(time.Unix(0, 0))
+	a := New([]*gpupb.GPU{
+		&gpupb.GPU{
+			Id: 100,
+		},
+		&gpupb.GPU{
+			Id: 101,
+		},
+	}, 0)
+	a.UseClock(c)
+	a.Preemptible(owner, time.Second)
+	defer a.Close()
+
+	leases := a.Claim("some-task", 2)
+	if len(leases) != 2 {
+		t.Fatalf("len(Claim()) = %v, want = 2", len(leases))
+	}
+	if got := a.Claim("other-task", 1); len(got) != 0 {
+		t.Errorf("Claim() unexpectedly succeeded: %v", got)
+	}
+	if got := a.Holders(100); len(got) != 1 || got[0] != "some-task" {
+		t.Errorf("Holders() = %v, want = [some-task]", got)
+	}
+
+	c.Advance(24 * time.Hour)
+	if got := len(a.Unused()); got != 0 {
+		t.Errorf("len(Unused()) = %v, want = 0", got)
+	}
+	if got := a.Expirations(); len(got) != 0 {
+		t.Errorf("Expirations() = %v, want = []", got)
+	}
+	if resp, err := a.Lease(context.Background(), &gpupb.LeaseRequest{
+		Requestor: owner,
+		Token:     tok,
+		Duration:  dpb.New(time.Hour),
+		Priority:  1,
+	}); err == nil {
+		t.Errorf("Lease() unexpectedly preempted a claim: %v", resp)
+	}
+	if _, err := a.Renew(leases[0], time.Hour); err == nil {
+		t.Errorf("Renew() unexpectedly succeeded")
+	}
+
+	if err := a.Release(leases[0]); err != nil {
+		t.Fatalf("Release() unexpectedly failed: %v", err)
+	}
+	if got := a.Unused(); len(got) != 1 || got[0].GetId() != 100 {
+		t.Errorf("Unused() = %v, want a single free GPU 100", got)
+	}
+}
+
+func TestReserve(t *testing.T) {
+	a := New([]*gpupb.GPU{
+		&gpupb.GPU{
+			Id: 100,
+		},
+		&gpupb.GPU{
+			Id: 101,
+		},
+		&gpupb.GPU{
+			Id: 102,
+		},
+	}, 0)
+
+	leases, err := a.Reserve(&gpupb.LeaseRequest{
+		Token: "some-token",
+	}, 2, time.Hour)
+	if err != nil {
+		t.Fatalf("Reserve() unexpectedly failed: %v", err)
+	}
+	if got := len(leases); got != 2 {
+		t.Fatalf("len(Reserve()) = %v, want = 2", got)
+	}
+
+	// Only commit one of the two reservations; the other should be
+	// released.
+	if _, err := a.Commit("some-token", []int32{leases[0].GetGpu().GetId()}, time.Hour); err != nil {
+		t.Fatalf("Commit() unexpectedly failed: %v", err)
+	}
+
+	leases, err = a.Reserve(&gpupb.LeaseRequest{
+		Token: "other-token",
+	}, 3, time.Hour)
+	if err != nil {
+		t.Fatalf("Reserve() unexpectedly failed: %v", err)
+	}
+	if got := len(leases); got != 2 {
+		t.Errorf("len(Reserve()) = %v, want = 2", got)
+	}
+
+	if got := a.Abort("other-token"); got != 2 {
+		t.Errorf("Abort() = %v, want = 2", got)
+	}
+	if _, err := a.Commit("other-token", []int32{leases[0].GetGpu().GetId()}, time.Hour); err == nil {
+		t.Errorf("Commit() unexpectedly succeeded")
+	}
+}
+
+func TestReserveExpire(t *testing.T) {
+	a := New([]*gpupb.GPU{
+		&gpupb.GPU{
+			Id: 100,
+		},
+	}, 0)
+
+	leases, err := a.Reserve(&gpupb.LeaseRequest{
+		Token: "some-token",
+	}, 1, 100*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Reserve() unexpectedly failed: %v", err)
+	}
+
+	time.Sleep(time.Until(leases[0].GetExpiration().AsTime()) + 100*time.Millisecond)
+
+	if _, err := a.Commit("some-token", []int32{100}, time.Hour); err == nil {
+		t.Errorf("Commit() unexpectedly succeeded")
+	}
+}
+
+// TestStates checks the transitions between lease states.
+func TestStates(t *testing.T) {
+	type op func(a *Allocator, c *clock.Virtual) error
+
+	reserve := func(hold time.Duration) op {
+		return func(a *Allocator, c *clock.Virtual) error {
+			_, err := a.Reserve(&gpupb.LeaseRequest{Token: "some-token"}, 1, hold)
+			return err
+		}
+	}
+	lease := func(a *Allocator, c *clock.Virtual) error {
+		_, err := a.Lease(context.Background(), &gpupb.LeaseRequest{
+			Token:    "some-token",
+			Duration: dpb.New(time.Hour),
+		})
+		return err
+	}
+	commit := func(a *Allocator, c *clock.Virtual) error {
+		_, err := a.Commit("some-token", []int32{100}, time.Hour)
+		return err
+	}
+	abort := func(a *Allocator, c *clock.Virtual) error {
+		a.Abort("some-token")
+		return nil
+	}
+	renew := func(a *Allocator, c *clock.Virtual) error {
+		_, err := a.Renew(&gpupb.Lease{
+			Token: "some-token",
+			Gpu:   &gpupb.GPU{Id: 100},
+		}, time.Hour)
+		return err
+	}
+	preempt := func(a *Allocator, c *clock.Virtual) error {
+		_, err := a.Reserve(&gpupb.LeaseRequest{
+			Requestor: "some-owner",
+			Token:     "some-owner:token",
+			Priority:  1,
+		}, 1, time.Hour)
+		return err
+	}
+	advance := func(d time.Duration) op {
+		return func(a *Allocator, c *clock.Virtual) error {
+			c.Advance(d)
+			return nil
+		}
+	}
+
+	configs := []struct {
+		name string
+		ops  []op
+		// fail is the index of the op which is expected to fail, if
+		// any.
+		fail int
+		// want is the expected state of the lease, if any lease is
+		// held.
+		want gpupb.Lease_State
+		// expiration is the expected expiration, relative to the
+		// start of the test, if any lease is held.
+		expiration time.Duration
+	}{
+		{name: "Lease", ops: []op{lease}, fail: -1, want: gpupb.Lease_STATE_COMMITTED, expiration: time.Hour},
+		{name: "Reserve", ops: []op{reserve(time.Minute)}, fail: -1, want: gpupb.Lease_STATE_TENTATIVE, expiration: time.Minute},
+		{name: "Reserve/DefaultHold", ops: []op{reserve(0)}, fail: -1, want: gpupb.Lease_STATE_TENTATIVE, expiration: DefaultHold},
+		{name: "Reserve/Expire", ops: []op{reserve(time.Minute), advance(2 * time.Minute), commit}, fail: 2, want: gpupb.Lease_STATE_EXPIRED, expiration: time.Minute},
+		{name: "Commit", ops: []op{reserve(time.Minute), commit}, fail: -1, want: gpupb.Lease_STATE_COMMITTED, expiration: time.Hour},
+		{name: "Commit/Committed", ops: []op{lease, commit}, fail: 1, want: gpupb.Lease_STATE_COMMITTED, expiration: time.Hour},
+		{name: "Abort", ops: []op{reserve(time.Minute), abort}, fail: -1},
+		{name: "Abort/Committed", ops: []op{lease, abort}, fail: -1, want: gpupb.Lease_STATE_COMMITTED, expiration: time.Hour},
+		{name: "Renew/Tentative", ops: []op{reserve(time.Minute), renew}, fail: 1, want: gpupb.Lease_STATE_TENTATIVE, expiration: time.Minute},
+		{name: "Renew", ops: []op{lease, advance(time.Minute), renew}, fail: -1, want: gpupb.Lease_STATE_COMMITTED, expiration: time.Hour + time.Minute},
+		{name: "Preempt", ops: []op{lease, preempt}, fail: -1, want: gpupb.Lease_STATE_RELEASING, expiration: time.Minute},
+		{name: "Expire", ops: []op{lease, advance(2 * time.Hour)}, fail: -1, want: gpupb.Lease_STATE_EXPIRED, expiration: time.Hour},
+	}
+
+	for _, c := range configs {
+		t.Run(c.name, func(t *testing.T) {
+			start := time.Unix(0, 0)
+			v := clock.NewVirtual(start)
+			a := New([]*gpupb.GPU{
+				&gpupb.GPU{
+					Id: 100,
+				},
+			}, 0)
+			a.UseClock(v)
+			a.Preemptible("some-owner", time.Minute)
+			defer a.Close()
+
+			for i, o := range c.ops {
+				if err := o(a, v); err != nil && i != c.fail {
+					t.Fatalf("op %v unexpectedly failed: %v", i, err)
+				} else if err == nil && i == c.fail {
+					t.Fatalf("op %v unexpectedly succeeded", i)
+				}
+			}
+
+			leases := a.Leases("some-token")
+			if c.want == gpupb.Lease_STATE_UNKNOWN {
+				if len(leases) != 0 {
+					t.Errorf("Leases() = %v, want = []", leases)
+				}
+				return
+			}
+			if len(leases) != 1 {
+				t.Fatalf("len(Leases()) = %v, want = 1", len(leases))
+			}
+			if got := leases[0].GetState(); got != c.want {
+				t.Errorf("GetState() = %v, want = %v", got, c.want)
+			}
+			if got, want := leases[0].GetExpiration().AsTime(), start.Add(c.expiration); !got.Equal(want) {
+				t.Errorf("GetExpiration() = %v, want = %v", got, want)
+			}
+		})
+	}
+}
+
+// TestCommitRenew checks that committing a reservation is not undone by the
+// expiration of the original tentative hold.
+func TestCommitRenew(t *testing.T) {
+	a := New([]*gpupb.GPU{
+		&gpupb.GPU{
+			Id: 100,
+		},
+	}, 0)
+
+	leases, err := a.Reserve(&gpupb.LeaseRequest{
+		Token: "some-token",
+	}, 1, 100*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Reserve() unexpectedly failed: %v", err)
+	}
+	if _, err := a.Commit("some-token", []int32{100}, time.Hour); err != nil {
+		t.Fatalf("Commit() unexpectedly failed: %v", err)
+	}
+
+	time.Sleep(time.Until(leases[0].GetExpiration().AsTime()) + 100*time.Millisecond)
+
+	if l, err := a.Lease(context.Background(), &gpupb.LeaseRequest{
+		Duration: dpb.New(time.Minute),
+	}); err == nil {
+		t.Errorf("Lease() unexpectedly succeeded: %v", l)
+	}
+}
+
+func TestRelease(t *testing.T) {
+	a := New([]*gpupb.GPU{
+		&gpupb.GPU{
+			Id: 100,
+		},
+	}, 0)
+
+	resp, err := a.Lease(context.Background(), &gpupb.LeaseRequest{
+		Token:    "some-token",
+		Duration: dpb.New(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("Lease() unexpectedly failed: %v", err)
+	}
+
+	// A lease may only be released with the matching token.
+	if err := a.Release(&gpupb.Lease{
+		Gpu:   resp.GetLease().GetGpu(),
+		Token: "other-token",
+	}); err == nil {
+		t.Errorf("Release() unexpectedly succeeded")
+	}
+	if err := a.Release(resp.GetLease()); err != nil {
+		t.Errorf("Release() unexpectedly failed: %v", err)
+	}
+
+	if _, err := a.Lease(context.Background(), &gpupb.LeaseRequest{
+		Token:    "other-token",
+		Duration: dpb.New(time.Hour),
+	}); err != nil {
+		t.Errorf("Lease() unexpectedly failed: %v", err)
+	}
+}
+
+func TestRenew(t *testing.T) {
+	a := New([]*gpupb.GPU{
+		&gpupb.GPU{
+			Id: 100,
+		},
+	}, 0)
+
+	resp, err := a.Lease(context.Background(), &gpupb.LeaseRequest{
+		Token:    "some-token",
+		Duration: dpb.New(100 * time.Millisecond),
+	})
+	if err != nil {
+		t.Fatalf("Lease() unexpectedly failed: %v", err)
+	}
+
+	if l, err := a.Renew(&gpupb.Lease{
+		Gpu:   resp.GetLease().GetGpu(),
+		Token: "other-token",
+	}, time.Hour); err == nil {
+		t.Errorf("Renew() unexpectedly succeeded: %v", l)
+	}
+	if _, err := a.Renew(resp.GetLease(), time.Hour); err != nil {
+		t.Fatalf("Renew() unexpectedly failed: %v", err)
+	}
+
+	// The original expiration should not free the renewed lease.
+	time.Sleep(time.Until(resp.GetLease().GetExpiration().AsTime()) + 100*time.Millisecond)
+
+	if l, err := a.Lease(context.Background(), &gpupb.LeaseRequest{
+		Token:    "other-token",
+		Duration: dpb.New(time.Hour),
+	}); err == nil {
+		t.Errorf("Lease() unexpectedly succeeded: %v", l)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	gpus := []*gpupb.GPU{
+		&gpupb.GPU{
+			Id: 100,
+		},
+		&gpupb.GPU{
+			Id: 101,
+		},
+		&gpupb.GPU{
+			Id: 102,
+		},
+	}
+
+	j, err := journal.Open(journal.O{Path: path})
+	if err != nil {
+		t.Fatalf("Open() unexpectedly failed: %v", err)
+	}
+	a := New(gpus, 0)
+	if err := a.Restore(j); err != nil {
+		t.Fatalf("Restore() unexpectedly failed: %v", err)
+	}
+
+	held, err := a.Lease(context.Background(), &gpupb.LeaseRequest{
+		Token:    "some-token",
+		Duration: dpb.New(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("Lease() unexpectedly failed: %v", err)
+	}
+	released, err := a.Lease(context.Background(), &gpupb.LeaseRequest{
+		Token:    "other-token",
+		Duration: dpb.New(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("Lease() unexpectedly failed: %v", err)
+	}
+	if err := a.Release(released.GetLease()); err != nil {
+		t.Fatalf("Release() unexpectedly failed: %v", err)
+	}
+	if _, err := a.Lease(context.Background(), &gpupb.LeaseRequest{
+		Token:    "expired-token",
+		Duration: dpb.New(100 * time.Millisecond),
+	}); err != nil {
+		t.Fatalf("Lease() unexpectedly failed: %v", err)
+	}
+
+	// Emulate a crash -- the in-memory state of the allocator is lost.
+	j.Close()
+	time.Sleep(200 * time.Millisecond)
+
+	j, err = journal.Open(journal.O{Path: path})
+	if err != nil {
+		t.Fatalf("Open() unexpectedly failed: %v", err)
+	}
+	defer j.Close()
+
+	b := New(gpus, 0)
+	if err := b.Restore(j); err != nil {
+		t.Fatalf("Restore() unexpectedly failed: %v", err)
+	}
+
+	// Only the unexpired, unreleased lease should be restored, along
+	// with its token.
+	for i := 0; i < 2; i++ {
+		if _, err := b.Lease(context.Background(), &gpupb.LeaseRequest{
+			Token:    "new-token",
+			Duration: dpb.New(time.Hour),
+		}); err != nil {
+			t.Errorf("Lease() unexpectedly failed: %v", err)
+		}
+	}
+	if resp, err := b.Lease(context.Background(), &gpupb.LeaseRequest{
+		Token:    "new-token",
+		Duration: dpb.New(time.Hour),
+	}); err == nil {
+		t.Errorf("Lease() unexpectedly succeeded: %v", resp)
+	}
+	if err := b.Release(held.GetLease()); err != nil {
+		t.Errorf("Release() unexpectedly failed: %v", err)
+	}
+}
+
+func TestFreed(t *testing.T) {
+	a := New([]*gpupb.GPU{
+		&gpupb.GPU{
+			Id: 100,
+		},
+	}, 0)
+
+	resp, err := a.Lease(context.Background(), &gpupb.LeaseRequest{
+		Duration: dpb.New(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("Lease() unexpectedly failed: %v", err)
+	}
+
+	expirations := a.Expirations()
+	if got := len(expirations); got != 1 {
+		t.Fatalf("len(Expirations()) = %v, want = 1", got)
+	}
+	if got, want := expirations[0], resp.GetLease().GetExpiration().AsTime(); !got.Equal(want) {
+		t.Errorf("Expirations()[0] = %v, want = %v", got, want)
+	}
+
+	select {
+	case <-a.Freed():
+		t.Fatalf("Freed() unexpectedly signalled before Release()")
+	default:
+	}
+
+	if err := a.Release(resp.GetLease()); err != nil {
+		t.Fatalf("Release() unexpectedly failed: %v", err)
+	}
+	select {
+	case <-a.Freed():
+	case <-time.After(time.Second):
+		t.Errorf("Freed() was not signalled after Release()")
+	}
+	if got := len(a.Expirations()); got != 0 {
+		t.Errorf("len(Expirations()) = %v, want = 0", got)
+	}
+}
+
+func TestUseClock(t *testing.T) {
+	c := clock.NewVirtual(time.Unix(0, 0))
+	a := New([]*gpupb.GPU{
+		&gpupb.GPU{
+			Id: 100,
+		},
+	}, 0)
+	a.UseClock(c)
+	defer a.Close()
+
+	resp, err := a.Lease(context.Background(), &gpupb.LeaseRequest{
+		Duration: dpb.New(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("Lease() unexpectedly failed: %v", err)
+	}
+	if got, want := resp.GetLease().GetExpiration().AsTime(), c.Now().Add(time.Hour); !got.Equal(want) {
+		t.Errorf("GetExpiration() = %v, want = %v", got, want)
+	}
+
+	if _, err := a.Lease(context.Background(), &gpupb.LeaseRequest{
+		Duration: dpb.New(time.Hour),
+	}); err == nil {
+		t.Fatalf("Lease() unexpectedly succeeded before the lease expired")
+	}
+
+	// The GPU is available as soon as the lease expires in virtual time,
+	// even before the expired lease is removed.
+	c.Advance(2 * time.Hour)
+	if _, err := a.Lease(context.Background(), &gpupb.LeaseRequest{
+		Duration: dpb.New(time.Hour),
+	}); err != nil {
+		t.Errorf("Lease() unexpectedly failed after the lease expired: %v", err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	opt := goleak.IgnoreCurrent()
+
+	a := New([]*gpupb.GPU{
+		&gpupb.GPU{
+			Id: 100,
+		},
+		&gpupb.GPU{
+			Id: 101,
+		},
+	}, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if resp, err := a.Lease(ctx, &gpupb.LeaseRequest{
+		Duration: dpb.New(time.Hour),
+	}); err == nil {
+		t.Errorf("Lease() unexpectedly succeeded: %v", resp)
+	}
+
+	for _, d := range []time.Duration{time.Hour, 10 * time.Millisecond} {
+		if _, err := a.Lease(context.Background(), &gpupb.LeaseRequest{
+			Duration: dpb.New(d),
+		}); err != nil {
+			t.Fatalf("Lease() unexpectedly failed: %v", err)
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	a.Close()
+	a.Close()
+	goleak.VerifyNone(t, opt)
+}
+
+// BenchmarkRenew renews and releases leases on an allocator with 100k
+// outstanding leases, and reports the number of goroutines per lease.
+func BenchmarkRenew(b *testing.B) {
+	const n = 100000
+
+	var gpus []*gpupb.GPU
+	for i := 0; i < n; i++ {
+		gpus = append(gpus, &gpupb.GPU{Id: int32(i)})
+	}
+	a := New(gpus, 0)
+	defer a.Close()
+
+	var leases []*gpupb.Lease
+	func() {
+		a.l.Lock()
+		defer a.l.Unlock()
+
+		expiration := tpb.New(time.Now().Add(time.Hour))
+		for _, g := range gpus {
+			l := &gpupb.Lease{
+				Token:      fmt.Sprintf("token-%v", g.GetId()),
+				Gpu:        g,
+				Expiration: expiration,
+			}
+			a.set(l)
+			leases = append(leases, l)
+		}
+	}()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		l := leases[i%n]
+		if _, err := a.Renew(l, time.Hour); err != nil {
+			b.Fatalf("Renew() unexpectedly failed: %v", err)
+		}
+	}
+	b.StopTimer()
+
+	b.ReportMetric(float64(runtime.NumGoroutine())/n, "goroutines/lease")
+}
@@ -0,0 +1,52 @@
+package pubsub
+
+import (
+	"time"
+
+	gpupb "github.com/kevmo314/fedtorch/governor/api/go/gpu"
+)
+
+// Offer is a remote reservation made in response to a locally issued lease
+// request.
+type Offer struct {
+	Response *gpupb.LeaseResponse
+
+	// Latency is how long the offer took to arrive after the request was
+	// published.
+	Latency time.Duration
+}
+
+// Policy chooses between competing offers. Policy reports whether offer a is
+// preferred over offer b.
+type Policy func(a, b *Offer) bool
+
+// Cheapest prefers the offer with the lowest asking price, breaking ties by
+// latency.
+func Cheapest(a, b *Offer) bool {
+	if pa, pb := a.Response.GetOffer().GetPrice(), b.Response.GetOffer().GetPrice(); pa != pb {
+		return pa < pb
+	}
+	return LowestLatency(a, b)
+}
+
+// Fastest prefers the offer whose slowest reserved GPU has the highest clock
+// rate, breaking ties by price.
+func Fastest(a, b *Offer) bool {
+	if ca, cb := clockRate(a), clockRate(b); ca != cb {
+		return ca > cb
+	}
+	return Cheapest(a, b)
+}
+
+// LowestLatency prefers the offer which arrived first.
+func LowestLatency(a, b *Offer) bool { return a.Latency < b.Latency }
+
+func clockRate(o *Offer) int32 {
+	var c int32
+	for i, l := range o.Response.GetLeases() {
+		if r := l.GetGpu().GetClockRate(); i == 0 || r < c {
+			c = r
+		}
+	}
+	return c
+}
@@ -3,39 +3,66 @@ package pubsub
 import (
 	"context"
 	"fmt"
-	"math/rand"
+	"sort"
 	"sync"
 	"time"
 
+	"github.com/kevmo314/fedtorch/governor/pubsub/clock"
+	"github.com/kevmo314/fedtorch/governor/pubsub/journal"
+	"github.com/kevmo314/fedtorch/governor/pubsub/ledger"
 	"github.com/kevmo314/fedtorch/governor/pubsub/local"
+	"github.com/kevmo314/fedtorch/governor/pubsub/quota"
 	"github.com/kevmo314/fedtorch/governor/pubsub/remote"
+	"github.com/kevmo314/fedtorch/governor/pubsub/token"
 	"github.com/libp2p/go-libp2p-pubsub"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"google.golang.org/protobuf/proto"
 
 	gpupb "github.com/kevmo314/fedtorch/governor/api/go/gpu"
+	lpb "github.com/kevmo314/fedtorch/governor/api/go/ledger"
+	dpb "google.golang.org/protobuf/types/known/durationpb"
+	tpb "google.golang.org/protobuf/types/known/timestamppb"
 )
 
 const (
 	LeaseRequestTopic  = "GPU_REQUEST"
 	LeaseResponseTopic = "GPU_FULFILLMENT"
+	LeaseCommitTopic   = "GPU_COMMIT"
+	LeaseAckTopic      = "GPU_COMMIT_ACK"
+	LeaseReleaseTopic  = "GPU_RELEASE"
+	LeaseRenewTopic    = "GPU_RENEW"
 
-	tokenLength = 64
-	tokenSet    = "abcdefghijklmnopqrstuvwxyz" + "ABCDEFGHIJKLMNOPQRSTUVWXYZ" + "0123456789"
+	LeasePreemptionTopic = "GPU_PREEMPT"
+	LedgerTopic          = "GPU_LEDGER"
+
+	// defaultWindow is how long a requestor collects offers before
+	// choosing between them.
+	defaultWindow = 5 * time.Second
+
+	// defaultLedgerInterval is how often the ledger summary is gossiped.
+	defaultLedgerInterval = time.Minute
+
+	subscriptionBufferSize = 64
 )
 
-func generateToken() string {
-	b := make([]byte, tokenLength)
-	for i := range b {
-		b[i] = tokenSet[rand.Intn(len(tokenSet))]
-	}
-	return string(b)
-}
+// errClosed is returned by requests made after, or interrupted by, Close.
+var errClosed = fmt.Errorf("allocator is closed")
 
-func pub[T proto.Message](ctx context.Context, t *pubsub.Topic) chan<- T {
+// pub returns a channel whose messages are published to the input topic,
+// until ctx is done. Senders should use send, so as not to block once ctx is
+// done.
+func pub[T proto.Message](ctx context.Context, wg *sync.WaitGroup, t *pubsub.Topic) chan<- T {
 	ch := make(chan T)
+	wg.Add(1)
 	go func() {
-		for msg := range ch {
+		defer wg.Done()
+		for {
+			var msg T
+			select {
+			case <-ctx.Done():
+				return
+			case msg = <-ch:
+			}
 			data, err := proto.Marshal(msg)
 			if err != nil {
 				continue
@@ -46,27 +73,51 @@ func pub[T proto.Message](ctx context.Context, t *pubsub.Topic) chan<- T {
 	return ch
 }
 
-func sub[T proto.Message](ctx context.Context, t *pubsub.Topic, f func(pb T) bool) <-chan T {
-	s, err := t.Subscribe(pubsub.WithBufferSize(0))
+// send sends the input message on ch, and returns false if ctx is done first.
+func send[T any](ctx context.Context, ch chan<- T, msg T) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case ch <- msg:
+		return true
+	}
+}
+
+// sub returns a channel of the messages on the input topic which pass the
+// input filter. The channel is closed once ctx is done.
+func sub[T proto.Message](ctx context.Context, wg *sync.WaitGroup, t *pubsub.Topic, f func(pb T) bool) <-chan T {
+	// Competing offers may arrive in quick succession; libp2p drops
+	// messages if the subscription buffer is full.
+	s, err := t.Subscribe(pubsub.WithBufferSize(subscriptionBufferSize))
 	if err != nil {
 		panic(fmt.Sprintf("cannot subscribe to topic %v: %v", t.String(), err))
 	}
 
 	ch := make(chan T)
+	wg.Add(1)
 	go func() {
+		defer wg.Done()
+		defer close(ch)
+		defer s.Cancel()
+
 		for {
 			msg, err := s.Next(ctx)
 			if err != nil {
 				return
 			}
 
-			var pb T
+			var zero T
+			pb := zero.ProtoReflect().New().Interface().(T)
 			if err := proto.Unmarshal(msg.Data, pb); err != nil {
 				continue
 			}
 
 			if f(pb) {
-				ch <- pb
+				select {
+				case <-ctx.Done():
+					return
+				case ch <- pb:
+				}
 			}
 		}
 	}()
@@ -78,25 +129,166 @@ type O struct {
 	PubSub          *pubsub.PubSub
 	PeerID          peer.ID
 	GPUs            []*gpupb.GPU
+
+	// Local is the inventory used to fulfill both local and remote lease
+	// requests, and must implement remote.Reserver, as remote requests
+	// are answered with tentative holds. If nil, a local.Allocator is
+	// constructed over GPUs.
+	Local remote.Leaser
+
+	// Pricer prices the offers made to remote requestors. If nil, GPUs
+	// are offered for free.
+	Pricer remote.Pricer
+
+	// Policy chooses between the offers made for locally issued requests.
+	// Defaults to Cheapest.
+	Policy Policy
+
+	// Window is how long to collect offers for locally issued requests
+	// before choosing between them. Must be shorter than the request
+	// timeout. Defaults to five seconds.
+	Window time.Duration
+
+	// PreemptionNotice is how long remote holders of preempted leases
+	// have to vacate the GPU. If set and Local is nil, locally issued
+	// requests may preempt lower priority remote leases. If Local is
+	// set, preemption is configured on Local instead.
+	PreemptionNotice time.Duration
+
+	// Quota limits the GPUs and GPU time each remote requestor may lease
+	// out of the local inventory. Under contention, remote requests are
+	// served in order of the fair share of the requestor, which favours
+	// peers that have lent GPUs to the local governor. If nil, requests
+	// are unlimited and served in order of arrival.
+	Quota *quota.Q
+
+	// Ledger records the GPU time lent to and borrowed from each peer.
+	// Remote requestors which owe too much GPU time are not served. A
+	// signed summary of the ledger is gossiped to peers every
+	// LedgerInterval, which defaults to one minute, and peers are also
+	// held to the debts they gossip if the ledger was constructed with
+	// Self set to PeerID. May be nil.
+	Ledger         *ledger.L
+	LedgerInterval time.Duration
+
+	// Hold is how long tentative holds placed on local GPUs for remote
+	// requests are kept before they expire, unless the requestor commits
+	// or declines them first. Must be longer than the request timeout, as
+	// a requestor may wait up to the timeout for enough offers before
+	// committing. Defaults to twice the request timeout.
+	Hold time.Duration
+
+	// Journal is the path of the lease journal of the local inventory
+	// constructed by New, so that leases granted to remote requestors
+	// survive a restart of the governor. If Local is set, the journal is
+	// restored onto Local separately. If empty, leases are not persisted.
+	Journal string
+
+	// Clock times lease expirations, offer windows and request timeouts,
+	// and may be replaced to run the allocator in virtual time. The
+	// clocks of Quota and Ledger are replaced as well. If Local is set,
+	// the clock of Local is configured separately. Defaults to the wall
+	// clock.
+	Clock clock.Clock
+}
+
+// pending tracks the offers made for a locally issued request.
+type pending struct {
+	start  time.Time
+	offers []*Offer
+
+	// arrived is signalled whenever an offer is added, so that the
+	// requestor is woken up as soon as enough GPUs have been offered.
+	arrived chan struct{}
+}
+
+// offered returns the total number of GPUs offered. The caller must hold the
+// allocator lock.
+func (p *pending) offered() int {
+	var n int
+	for _, o := range p.offers {
+		n += len(o.Response.GetLeases())
+	}
+	return n
 }
 
 type Allocator struct {
+	// ctx is cancelled by Close, and stops all allocator goroutines,
+	// which are tracked by wg.
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	once   sync.Once
+
+	ps     *pubsub.PubSub
+	topics []*pubsub.Topic
+
+	// owned is the local inventory constructed by New, if any, which is
+	// closed along with the allocator, as is its journal.
+	owned   *local.Allocator
+	journal *journal.J
+
 	reqPub  chan<- *gpupb.LeaseRequest
 	reqSub  <-chan *gpupb.LeaseRequest
 	respPub chan<- *gpupb.LeaseResponse
 	respSub <-chan *gpupb.LeaseResponse
+	comPub  chan<- *gpupb.LeaseCommit
+	comSub  <-chan *gpupb.LeaseCommit
+	ackPub  chan<- *gpupb.LeaseAck
+	ackSub  <-chan *gpupb.LeaseAck
+	relPub  chan<- *gpupb.LeaseRelease
+	relSub  <-chan *gpupb.LeaseRelease
+	renPub  chan<- *gpupb.LeaseRenew
+	renSub  <-chan *gpupb.LeaseRenew
+	prePub  chan<- *gpupb.LeasePreemption
+	preSub  <-chan *gpupb.LeasePreemption
+	ledPub  chan<- *lpb.Summary
+	ledSub  <-chan *lpb.Summary
+
+	// preemptions delivers notices of preempted leases held by the local
+	// host.
+	preemptions chan *gpupb.LeasePreemption
+
+	l sync.Mutex
 
-	// responses keeps track of requests issued locally which have returned
-	// from a remote fulfillment. This struct listens to the respSub
-	// channel.
-	responses map[string]*gpupb.LeaseResponse
-	l         sync.Mutex
-	clean     chan string
+	// pending keeps track of the offers made for requests issued locally.
+	// This struct listens to the respSub channel. Offers for a token
+	// which is not pending are aborted as soon as they arrive.
+	pending map[string]*pending
+
+	// committing receives the acknowledgements of the commits sent for
+	// requests issued locally, keyed by token. Acknowledgements for a
+	// token which is no longer committing are released as soon as they
+	// arrive.
+	committing map[string]chan *gpupb.LeaseAck
+
+	// queue holds the locally issued requests waiting for a free GPU.
+	queue *queue
+
+	// backlog holds the remote requests waiting to be served.
+	backlog *backlog
 
 	remote *remote.Allocator
-	local  *local.Allocator
+	local  remote.Leaser
+	quota  *quota.Q
+	ledger *ledger.L
+
+	requestor string
+
+	policy Policy
+	window time.Duration
+
+	// gossip is how often the ledger summary is published.
+	gossip time.Duration
 
 	timeout time.Duration
+
+	// hold is how long tentative reservations made for remote requests
+	// are kept before they are released. This needs to be long enough
+	// for the requestor to collect all offers and commit.
+	hold time.Duration
+
+	clock clock.Clock
 }
 
 // New constructs a Allocator daemon.
@@ -118,9 +310,39 @@ type Allocator struct {
 // Motivated by
 // https://medium.com/rahasak/libp2p-pubsub-with-golang-495539e6aae1.
 func New(ctx context.Context, o O, timeout time.Duration) *Allocator {
-	const fuzz = 15 * time.Second
-	if timeout < 2*fuzz {
-		panic(fmt.Sprintf("remote fulfillment request timeout %v does not account for backoff fuzzing time %v", timeout, 2*fuzz))
+	if o.Clock == nil {
+		o.Clock = clock.Real
+	}
+	if o.Policy == nil {
+		o.Policy = Cheapest
+	}
+	if o.Window == 0 {
+		o.Window = defaultWindow
+	}
+	if o.Window >= timeout {
+		panic(fmt.Sprintf("offer window %v must be shorter than the request timeout %v", o.Window, timeout))
+	}
+	if o.LedgerInterval == 0 {
+		o.LedgerInterval = defaultLedgerInterval
+	}
+	if o.Hold == 0 {
+		o.Hold = 2 * timeout
+	}
+	if o.Hold <= timeout {
+		panic(fmt.Sprintf("tentative hold %v must be longer than the request timeout %v", o.Hold, timeout))
+	}
+
+	// Remote requests are only ever answered with tentative holds.
+	if _, ok := o.Local.(remote.Reserver); o.Local != nil && !ok {
+		panic(fmt.Sprintf("local inventory %T does not support reservations", o.Local))
+	}
+
+	// Lease messages must be signed by the peer they claim to be from,
+	// so that e.g. a peer cannot commit or release another peer's leases.
+	for topic, v := range validators {
+		if err := o.PubSub.RegisterTopicValidator(topic, v); err != nil {
+			panic(fmt.Sprintf("cannot register validator for topic %v: %v", topic, err))
+		}
 	}
 
 	requestT, err := o.PubSub.Join(LeaseRequestTopic)
@@ -133,99 +355,855 @@ func New(ctx context.Context, o O, timeout time.Duration) *Allocator {
 		panic(fmt.Sprintf("cannot join response topic %v: %v", LeaseResponseTopic, err))
 	}
 
-	localAllocator := local.New(o.GPUs, time.Minute)
+	commitT, err := o.PubSub.Join(LeaseCommitTopic)
+	if err != nil {
+		panic(fmt.Sprintf("cannot join commit topic %v: %v", LeaseCommitTopic, err))
+	}
 
+	ackT, err := o.PubSub.Join(LeaseAckTopic)
+	if err != nil {
+		panic(fmt.Sprintf("cannot join acknowledgement topic %v: %v", LeaseAckTopic, err))
+	}
+
+	releaseT, err := o.PubSub.Join(LeaseReleaseTopic)
+	if err != nil {
+		panic(fmt.Sprintf("cannot join release topic %v: %v", LeaseReleaseTopic, err))
+	}
+
+	renewT, err := o.PubSub.Join(LeaseRenewTopic)
+	if err != nil {
+		panic(fmt.Sprintf("cannot join renew topic %v: %v", LeaseRenewTopic, err))
+	}
+
+	preemptionT, err := o.PubSub.Join(LeasePreemptionTopic)
+	if err != nil {
+		panic(fmt.Sprintf("cannot join preemption topic %v: %v", LeasePreemptionTopic, err))
+	}
+
+	ledgerT, err := o.PubSub.Join(LedgerTopic)
+	if err != nil {
+		panic(fmt.Sprintf("cannot join ledger topic %v: %v", LedgerTopic, err))
+	}
+
+	// Requestor IDs are sent over the wire in their string-encoded form,
+	// as the raw peer ID bytes are not guaranteed to be valid UTF-8.
+	requestor := o.PeerID.String()
+
+	ctx, cancel := context.WithCancel(ctx)
 	a := &Allocator{
-		reqPub: pub[*gpupb.LeaseRequest](ctx, requestT),
-		reqSub: sub[*gpupb.LeaseRequest](ctx, requestT, func(pb *gpupb.LeaseRequest) bool {
-			return peer.ID(pb.GetRequestor()) != o.PeerID
-		}),
+		ctx:    ctx,
+		cancel: cancel,
+		ps:     o.PubSub,
+		topics: []*pubsub.Topic{requestT, responseT, commitT, ackT, releaseT, renewT, preemptionT, ledgerT},
 
-		respPub: pub[*gpupb.LeaseResponse](ctx, responseT),
-		respSub: sub[*gpupb.LeaseResponse](ctx, responseT, func(pb *gpupb.LeaseResponse) bool {
-			return peer.ID(pb.GetRequestor()) == o.PeerID
-		}),
+		preemptions: make(chan *gpupb.LeasePreemption, subscriptionBufferSize),
 
-		remote: remote.New(remote.O{
-			AmbientTraffic: sub[*gpupb.LeaseResponse](ctx, responseT, func(pb *gpupb.LeaseResponse) bool {
-				return peer.ID(pb.GetRequestor()) != o.PeerID
-			}),
-		}, fuzz),
-		local:   localAllocator,
-		timeout: timeout,
+		pending:    make(map[string]*pending),
+		committing: make(map[string]chan *gpupb.LeaseAck),
+		queue:      newQueue(),
+		backlog:    newBacklog(),
+		local:      o.Local,
+		quota:      o.Quota,
+		ledger:     o.Ledger,
+		requestor:  requestor,
+		policy:     o.Policy,
+		window:     o.Window,
+		gossip:     o.LedgerInterval,
+		timeout:    timeout,
+		hold:       o.Hold,
+		clock:      o.Clock,
 	}
+	if a.local == nil {
+		l := local.New(o.GPUs, time.Minute)
+		l.UseClock(o.Clock)
+		if o.PreemptionNotice > 0 {
+			l.Preemptible(requestor, o.PreemptionNotice)
+		}
+		if o.Journal != "" {
+			j, err := journal.Open(journal.O{Path: o.Journal})
+			if err != nil {
+				panic(fmt.Sprintf("cannot open lease journal: %v", err))
+			}
+			if err := l.Restore(j); err != nil {
+				panic(fmt.Sprintf("cannot restore lease journal %v: %v", o.Journal, err))
+			}
+			a.journal = j
+		}
+		a.local = l
+		a.owned = l
+	}
+
+	a.reqPub = pub[*gpupb.LeaseRequest](ctx, &a.wg, requestT)
+	a.reqSub = sub[*gpupb.LeaseRequest](ctx, &a.wg, requestT, func(pb *gpupb.LeaseRequest) bool {
+		return pb.GetRequestor() != requestor
+	})
+
+	a.respPub = pub[*gpupb.LeaseResponse](ctx, &a.wg, responseT)
+	a.respSub = sub[*gpupb.LeaseResponse](ctx, &a.wg, responseT, func(pb *gpupb.LeaseResponse) bool {
+		return pb.GetRequestor() == requestor
+	})
+
+	a.comPub = pub[*gpupb.LeaseCommit](ctx, &a.wg, commitT)
+	a.comSub = sub[*gpupb.LeaseCommit](ctx, &a.wg, commitT, func(pb *gpupb.LeaseCommit) bool {
+		return pb.GetResponder() == requestor
+	})
+
+	a.ackPub = pub[*gpupb.LeaseAck](ctx, &a.wg, ackT)
+	a.ackSub = sub[*gpupb.LeaseAck](ctx, &a.wg, ackT, func(pb *gpupb.LeaseAck) bool {
+		return pb.GetRequestor() == requestor
+	})
+
+	a.relPub = pub[*gpupb.LeaseRelease](ctx, &a.wg, releaseT)
+	a.relSub = sub[*gpupb.LeaseRelease](ctx, &a.wg, releaseT, func(pb *gpupb.LeaseRelease) bool {
+		return pb.GetResponder() == requestor
+	})
+
+	a.renPub = pub[*gpupb.LeaseRenew](ctx, &a.wg, renewT)
+	a.renSub = sub[*gpupb.LeaseRenew](ctx, &a.wg, renewT, func(pb *gpupb.LeaseRenew) bool {
+		return pb.GetResponder() == requestor
+	})
 
-	go a.daemon()
-	go a.cleaner()
-	go a.listener()
+	a.prePub = pub[*gpupb.LeasePreemption](ctx, &a.wg, preemptionT)
+	a.preSub = sub[*gpupb.LeasePreemption](ctx, &a.wg, preemptionT, func(pb *gpupb.LeasePreemption) bool {
+		return pb.GetRequestor() == requestor
+	})
+
+	a.ledPub = pub[*lpb.Summary](ctx, &a.wg, ledgerT)
+	a.ledSub = sub[*lpb.Summary](ctx, &a.wg, ledgerT, func(pb *lpb.Summary) bool {
+		return pb.GetAuthor() != requestor
+	})
+
+	// Remote requests are answered by Reserve, which does not back off,
+	// rather than by the fuzzed remote.Allocator.Lease.
+	a.remote = remote.New(remote.O{
+		AmbientTraffic: sub[*gpupb.LeaseResponse](ctx, &a.wg, responseT, func(pb *gpupb.LeaseResponse) bool {
+			return pb.GetRequestor() != requestor
+		}),
+		AmbientReleases: sub[*gpupb.LeaseRelease](ctx, &a.wg, releaseT, func(pb *gpupb.LeaseRelease) bool {
+			return pb.GetRequestor() != requestor
+		}),
+		AmbientRenewals: sub[*gpupb.LeaseRenew](ctx, &a.wg, renewT, func(pb *gpupb.LeaseRenew) bool {
+			return pb.GetRequestor() != requestor
+		}),
+		LocalAllocator: a.local,
+		Pricer:         o.Pricer,
+		Quota:          o.Quota,
+		Ledger:         o.Ledger,
+		Clock:          o.Clock,
+	}, 0)
+
+	a.spawn(a.intake)
+	a.spawn(a.daemon)
+	a.spawn(a.committer)
+	a.spawn(a.acker)
+	a.spawn(a.releaser)
+	a.spawn(a.renewer)
+	a.spawn(a.listener)
+	a.spawn(a.watcher)
+	a.spawn(a.dispatcher)
+	if p, ok := a.local.(remote.Preempter); ok {
+		a.spawn(func() { a.notifier(p) })
+	}
+	if a.ledger != nil {
+		a.spawn(a.gossiper)
+		a.spawn(a.observer)
+	}
 
 	return a
 }
 
-func (a *Allocator) daemon() {
+// spawn runs the input function in a goroutine tracked by Close.
+func (a *Allocator) spawn(f func()) {
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		f()
+	}()
+}
+
+// Close stops all allocator goroutines, including those of the underlying
+// remote allocator and of the local inventory if it was constructed by New,
+// and blocks until they have exited. Close leaves the pubsub topics and
+// unregisters the topic validators, so that a new Allocator may be
+// constructed over the same pubsub instance.
+//
+// Pending and queued requests fail once the allocator is closed. Leases
+// which have already been granted are not released.
+func (a *Allocator) Close() {
+	a.once.Do(func() {
+		a.cancel()
+		a.wg.Wait()
+
+		a.remote.Close()
+		if a.owned != nil {
+			a.owned.Close()
+		}
+		if a.journal != nil {
+			a.journal.Close()
+		}
+
+		// Closing a topic only fails if it is still subscribed to,
+		// which cannot happen once all subscribers have exited.
+		for _, t := range a.topics {
+			t.Close()
+		}
+		for topic := range validators {
+			a.ps.UnregisterTopicValidator(topic)
+		}
+	})
+}
+
+// intake moves remote requests into the backlog as soon as they arrive, so
+// that requests which arrive while the daemon is busy may be reordered.
+func (a *Allocator) intake() {
 	for req := range a.reqSub {
-		resp, err := a.remote.Lease(req)
+		a.backlog.push(req)
+	}
+}
+
+// daemon serves the remote requests in the backlog, answering each with an
+// offer if any local GPUs can be reserved.
+func (a *Allocator) daemon() {
+	for {
+		select {
+		case <-a.ctx.Done():
+			return
+		case <-a.backlog.wake:
+		}
+		for req, ok := a.next(); ok; req, ok = a.next() {
+			resp, err := a.remote.Reserve(req, a.hold)
+			if err != nil {
+				continue
+			}
+
+			resp.Responder = a.requestor
+			send(a.ctx, a.respPub, resp)
+		}
+	}
+}
+
+// next removes and returns the remote request in the backlog which should be
+// served next, or false if the backlog is empty.
+//
+// Requests are served in order of arrival, unless a quota is configured, in
+// which case requests from peers with a larger fair share are served first.
+func (a *Allocator) next() (*gpupb.LeaseRequest, bool) {
+	if a.quota == nil {
+		return a.backlog.pop(nil)
+	}
+	return a.backlog.pop(a.quota.Share)
+}
+
+// committer finalizes the reservations made for remote requests, and
+// acknowledges each accepted offer with the committed leases.
+func (a *Allocator) committer() {
+	for c := range a.comSub {
+		leases, err := a.remote.Commit(c)
+		if len(c.GetIds()) == 0 {
+			continue
+		}
+		ack := &gpupb.LeaseAck{
+			Requestor: c.GetRequestor(),
+			Responder: a.requestor,
+			Token:     c.GetToken(),
+			Leases:    leases,
+		}
 		if err != nil {
+			ack.Error = err.Error()
+		}
+		send(a.ctx, a.ackPub, ack)
+	}
+}
+
+// acker routes the acknowledgements of commits sent for local requests to
+// the waiting requestor.
+func (a *Allocator) acker() {
+	for ack := range a.ackSub {
+		a.l.Lock()
+		ch, ok := a.committing[ack.GetToken()]
+		if ok {
+			// The channel is buffered for one acknowledgement per
+			// accepted responder.
+			select {
+			case ch <- ack:
+			default:
+			}
+		}
+		a.l.Unlock()
+
+		if !ok {
+			a.revoke(ack.GetResponder(), ack.GetLeases())
+		}
+	}
+}
+
+// releaser returns GPUs leased out to remote requestors which have finished
+// early.
+func (a *Allocator) releaser() {
+	for r := range a.relSub {
+		a.remote.Release(r)
+	}
+}
+
+// renewer extends leases held by remote requestors.
+//
+// TODO(minkezhang): Notify the requestor if the renewal fails.
+func (a *Allocator) renewer() {
+	for r := range a.renSub {
+		a.remote.Renew(r)
+	}
+}
+
+// notifier forwards notices of preempted leases out of the local inventory to
+// the lease holders.
+func (a *Allocator) notifier(p remote.Preempter) {
+	for {
+		var l *gpupb.Lease
+		select {
+		case <-a.ctx.Done():
+			return
+		case l = <-p.Preemptions():
+		}
+		n := &gpupb.LeasePreemption{
+			Requestor: token.Requestor(l.GetToken()),
+			Responder: a.requestor,
+			Lease:     l,
+		}
+		if n.GetRequestor() == a.requestor {
+			a.preempt(n)
 			continue
 		}
+		if a.ledger != nil {
+			a.ledger.Update(l)
+		}
+		send(a.ctx, a.prePub, n)
+	}
+}
 
-		a.respPub <- resp
+// watcher listens for notices of preempted leases held by the local host.
+func (a *Allocator) watcher() {
+	for n := range a.preSub {
+		if a.ledger != nil {
+			a.ledger.Update(n.GetLease())
+		}
+		a.preempt(n)
 	}
 }
 
+// gossiper periodically publishes a summary of the local ledger, so that
+// peers may check their own records against it.
+func (a *Allocator) gossiper() {
+	t := a.clock.NewTicker(a.gossip)
+	defer t.Stop()
+	for {
+		select {
+		case <-a.ctx.Done():
+			return
+		case <-t.C():
+			send(a.ctx, a.ledPub, a.ledger.Summary(a.requestor))
+		}
+	}
+}
+
+// observer records the ledger summaries gossiped by peers.
+func (a *Allocator) observer() {
+	for s := range a.ledSub {
+		a.ledger.Observe(s)
+	}
+}
+
+// preempt delivers a preemption notice to the local host. Notices are dropped
+// if Preemptions is not drained.
+func (a *Allocator) preempt(n *gpupb.LeasePreemption) {
+	select {
+	case a.preemptions <- n:
+	default:
+	}
+}
+
+// Preemptions returns notices of leases held by the local host which have
+// been preempted by the responder. The holder should checkpoint and vacate
+// the leased GPU before the lease expiration, e.g. via
+// hypervisor.Hypervisor.Preempt.
+func (a *Allocator) Preemptions() <-chan *gpupb.LeasePreemption { return a.preemptions }
+
 func (a *Allocator) listener() {
 	for resp := range a.respSub {
-		a.l.Lock()
+		if len(resp.GetLeases()) > 0 {
+			a.reserve(resp)
+		}
+	}
+}
 
-		a.responses[resp.GetLease().GetToken()] = resp
+// reserve tracks a remote offer made for a local request.
+func (a *Allocator) reserve(resp *gpupb.LeaseResponse) {
+	token := resp.GetLeases()[0].GetToken()
 
-		a.l.Unlock()
+	a.l.Lock()
+	defer a.l.Unlock()
 
-		go func(resp *gpupb.LeaseResponse) {
-			time.Sleep(time.Until(resp.GetLease().GetExpiration().AsTime()))
-			a.clean <- resp.GetLease().GetToken()
-		}(resp)
+	p, ok := a.pending[token]
+	if !ok {
+		a.abort(resp)
+		return
+	}
+	p.offers = append(p.offers, &Offer{
+		Response: resp,
+		Latency:  clock.Since(a.clock, p.start),
+	})
+	select {
+	case p.arrived <- struct{}{}:
+	default:
 	}
 }
 
-func (a *Allocator) cleaner() {
-	for x := range a.clean {
-		a.l.Lock()
+// abort releases all reservations made by the responder of the input offer.
+func (a *Allocator) abort(resp *gpupb.LeaseResponse) {
+	a.spawn(func() {
+		send(a.ctx, a.comPub, &gpupb.LeaseCommit{
+			Requestor: a.requestor,
+			Responder: resp.GetResponder(),
+			Token:     resp.GetLeases()[0].GetToken(),
+		})
+	})
+}
 
-		delete(a.responses, x)
+// revoke releases the input leases committed by the input responder, e.g. if
+// the commit was acknowledged too late.
+func (a *Allocator) revoke(responder string, leases []*gpupb.Lease) {
+	for _, l := range leases {
+		l := l
+		a.spawn(func() {
+			send(a.ctx, a.relPub, &gpupb.LeaseRelease{
+				Requestor: a.requestor,
+				Responder: responder,
+				Lease:     l,
+			})
+		})
+	}
+}
 
-		a.l.Unlock()
+// fill returns a copy of the input request with the local peer ID as the
+// requestor, and with a freshly generated token filled in if missing.
+//
+// The requestor is always overwritten, as remote governors reject requests
+// which are not signed by the requestor. An existing token must be bound to
+// the local peer ID.
+func (a *Allocator) fill(req *gpupb.LeaseRequest) (*gpupb.LeaseRequest, error) {
+	req = proto.Clone(req).(*gpupb.LeaseRequest)
+	req.Requestor = a.requestor
+	if req.GetToken() == "" {
+		t, err := token.New(a.requestor)
+		if err != nil {
+			return nil, err
+		}
+		req.Token = t
 	}
+	if err := token.Check(req.GetToken(), a.requestor); err != nil {
+		return nil, err
+	}
+	return req, nil
 }
 
-// Lease fulfills a local host's allocation request.
-func (a *Allocator) Lease(req *gpupb.LeaseRequest) (*gpupb.LeaseResponse, error) {
-	resp, err := a.local.Lease(req)
+// Lease fulfills a local host's allocation request. Lease sets the requestor
+// to the local peer ID, and if the request does not specify a token, fills in
+// a freshly generated token bound to the local peer ID.
+//
+// If no local GPU is available, Lease collects offers from remote governors
+// and accepts the offer preferred by the allocator policy. Lease fails early
+// if ctx is done before enough offers are collected.
+func (a *Allocator) Lease(ctx context.Context, req *gpupb.LeaseRequest) (*gpupb.LeaseResponse, error) {
+	req, err := a.fill(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := a.local.Lease(ctx, req)
 	if err == nil {
+		resp.Responder = a.requestor
 		return resp, nil
 	}
 
-	// Try a remote lease request.
+	offers, err := a.gather(ctx, req, 1)
+	if err != nil {
+		return nil, err
+	}
+	accepted, err := a.accept(ctx, req, 1, offers)
+	if err != nil {
+		return nil, err
+	}
+	return accepted[0], nil
+}
+
+// LeaseN fulfills a local host's gang allocation request for req.Count GPUs.
+// Either all GPUs are leased, or none are -- GPUs are first tentatively
+// reserved from the local inventory and from remote governors, and are only
+// committed once enough reservations have been made. Remote commits must be
+// acknowledged by the responders. Otherwise, all reservations are aborted, and
+// any leases which were already committed are released.
+//
+// All returned leases share the same token.
+func (a *Allocator) LeaseN(ctx context.Context, req *gpupb.LeaseRequest) ([]*gpupb.Lease, error) {
+	req, err := a.fill(req)
+	if err != nil {
+		return nil, err
+	}
+
+	n := int(req.GetCount())
+	if n <= 1 {
+		resp, err := a.Lease(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		return []*gpupb.Lease{resp.GetLease()}, nil
+	}
+
+	var local []*gpupb.Lease
+	r, ok := a.local.(remote.Reserver)
+	if ok {
+		// A local reservation failure just means we need to look
+		// for more GPUs on the network.
+		local, _ = r.Reserve(req, n, a.hold)
+	}
+
+	var offers []*gpupb.LeaseResponse
+	if len(local) < n {
+		if offers, err = a.gather(ctx, req, n-len(local)); err != nil {
+			if ok {
+				r.Abort(req.GetToken())
+			}
+			return nil, err
+		}
+	}
+
+	var leases []*gpupb.Lease
+	if len(local) > 0 {
+		var ids []int32
+		for _, l := range local {
+			ids = append(ids, l.GetGpu().GetId())
+		}
+		committed, err := r.Commit(req.GetToken(), ids, req.GetDuration().AsDuration())
+		if err != nil {
+			r.Abort(req.GetToken())
+			a.unwind(committed)
+			for _, resp := range offers {
+				a.abort(resp)
+			}
+			return nil, fmt.Errorf("could not commit local reservations: %v", err)
+		}
+		leases = append(leases, committed...)
+	}
+
+	accepted, err := a.accept(ctx, req, n-len(leases), offers)
+	if err != nil {
+		a.unwind(leases)
+		return nil, err
+	}
+	for _, resp := range accepted {
+		leases = append(leases, resp.GetLease())
+	}
+	return leases, nil
+}
+
+// unwind releases the input leases committed out of the local inventory, e.g.
+// if the rest of the gang could not be leased. Leases which cannot be
+// released are held until they expire.
+func (a *Allocator) unwind(leases []*gpupb.Lease) {
+	r, ok := a.local.(remote.Releaser)
+	if !ok {
+		return
+	}
+	for _, l := range leases {
+		r.Release(l)
+	}
+}
+
+// accept commits just enough reservations out of the input offers to fill n
+// GPUs, in order, and declines the rest. accept then waits for each accepted
+// responder to acknowledge its commit, and returns the committed leases.
+// Accepted leases are credited to the responders as contributions in the
+// quota ledger.
+//
+// The commits are all-or-nothing -- if any responder fails to commit, or does
+// not acknowledge its commit before the timeout or before ctx is done, all
+// acknowledged leases are released and an error is returned.
+func (a *Allocator) accept(ctx context.Context, req *gpupb.LeaseRequest, n int, offers []*gpupb.LeaseResponse) ([]*gpupb.LeaseResponse, error) {
+	var commits []*gpupb.LeaseCommit
+	accepted := map[string]int{}
+	var m int
+	for _, resp := range offers {
+		c := &gpupb.LeaseCommit{
+			Requestor: a.requestor,
+			Responder: resp.GetResponder(),
+			Token:     req.GetToken(),
+			Duration:  req.GetDuration(),
+		}
+		for _, l := range resp.GetLeases() {
+			if m >= n {
+				break
+			}
+			c.Ids = append(c.Ids, l.GetGpu().GetId())
+			m++
+		}
+		if len(c.GetIds()) > 0 {
+			accepted[c.GetResponder()] = len(c.GetIds())
+		}
+		commits = append(commits, c)
+	}
+	if m < n {
+		for _, resp := range offers {
+			a.abort(resp)
+		}
+		return nil, fmt.Errorf("could not find %v free GPUs on the network", n)
+	}
+
+	// Acknowledgements may arrive as soon as the commits are sent.
+	acks := make(chan *gpupb.LeaseAck, len(accepted))
+	a.l.Lock()
+	a.committing[req.GetToken()] = acks
+	a.l.Unlock()
+
+	for _, c := range commits {
+		send(a.ctx, a.comPub, c)
+	}
+
+	var resps []*gpupb.LeaseResponse
+	err := func() error {
+		defer func() {
+			a.l.Lock()
+			defer a.l.Unlock()
+			delete(a.committing, req.GetToken())
+		}()
+
+		timeout := a.clock.NewTimer(a.timeout)
+		defer timeout.Stop()
+
+		var err error
+		for pending := len(accepted); pending > 0; {
+			var ack *gpupb.LeaseAck
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-a.ctx.Done():
+				return errClosed
+			case <-timeout.C():
+				return fmt.Errorf("commit was not acknowledged by all responders")
+			case ack = <-acks:
+			}
+			want, ok := accepted[ack.GetResponder()]
+			if !ok {
+				continue
+			}
+			delete(accepted, ack.GetResponder())
+			pending--
+
+			for _, l := range ack.GetLeases() {
+				resps = append(resps, &gpupb.LeaseResponse{
+					Requestor: a.requestor,
+					Responder: ack.GetResponder(),
+					Lease:     l,
+				})
+			}
+			if ack.GetError() != "" {
+				err = fmt.Errorf("responder %v could not commit: %v", ack.GetResponder(), ack.GetError())
+			} else if len(ack.GetLeases()) != want {
+				err = fmt.Errorf("responder %v committed %v GPUs, want %v", ack.GetResponder(), len(ack.GetLeases()), want)
+			}
+		}
+		return err
+	}()
+
 	if err != nil {
+		for _, resp := range resps {
+			a.revoke(resp.GetResponder(), []*gpupb.Lease{resp.GetLease()})
+		}
+		return nil, err
+	}
+
+	for _, resp := range resps {
+		l := resp.GetLease()
+		if a.quota != nil {
+			f := l.GetFraction()
+			if f == 0 {
+				f = 1
+			}
+			a.quota.Contribute(resp.GetResponder(), f*req.GetDuration().AsDuration().Hours())
+		}
+		if a.ledger != nil {
+			a.ledger.Borrow(resp.GetResponder(), l)
+		}
+	}
+	return resps, nil
+}
+
+// gather broadcasts a request for n GPUs and collects offers from remote
+// governors for the offer window, or until at least n GPUs have been offered
+// in total, whichever is later. The offers are returned in order of the
+// allocator policy. If not enough GPUs are offered before the timeout, or if
+// ctx is done first, gather aborts all offers it has received.
+func (a *Allocator) gather(ctx context.Context, req *gpupb.LeaseRequest, n int) ([]*gpupb.LeaseResponse, error) {
+	req = proto.Clone(req).(*gpupb.LeaseRequest)
+	req.Count = int32(n)
+
+	p := &pending{
+		start:   a.clock.Now(),
+		arrived: make(chan struct{}, 1),
+	}
+	a.l.Lock()
+	a.pending[req.GetToken()] = p
+	a.l.Unlock()
+
+	// Once the request is no longer pending, any late offers will be
+	// aborted by the listener.
+	collect := func() []*Offer {
+		a.l.Lock()
+		defer a.l.Unlock()
+
+		delete(a.pending, req.GetToken())
+		return p.offers
+	}
+
+	err := func() error {
+		timeout := a.clock.NewTimer(a.timeout)
+		defer timeout.Stop()
+
 		select {
-		case <-time.After(a.timeout):
-			return nil, fmt.Errorf("could not write GPU lease request to the network")
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-a.ctx.Done():
+			return errClosed
+		case <-timeout.C():
+			return fmt.Errorf("could not write GPU lease request to the network")
 		case a.reqPub <- req:
 		}
+		return a.await(ctx, p, n)
+	}()
 
-		// Wait for a remote fulfillment.
-		timeout := time.Now().Add(a.timeout)
-		for time.Now().Before(timeout) {
-			if resp, ok := func() (*gpupb.LeaseResponse, bool) {
-				a.l.Lock()
-				defer a.l.Unlock()
-				resp, ok := a.responses[req.GetToken()]
-				return resp, ok
-			}(); ok {
-				return resp, nil
-			}
-			time.Sleep(time.Second)
+	offers := collect()
+	if err != nil {
+		for _, o := range offers {
+			a.abort(o.Response)
+		}
+		return nil, err
+	}
+
+	sort.SliceStable(offers, func(i, j int) bool {
+		return a.policy(offers[i], offers[j])
+	})
+	var resps []*gpupb.LeaseResponse
+	for _, o := range offers {
+		resps = append(resps, o.Response)
+	}
+	return resps, nil
+}
+
+// await blocks until the offer window of the input pending request has
+// elapsed and at least n GPUs have been offered. The waiting caller is woken
+// up by the listener as soon as each offer arrives. await returns an error if
+// not enough GPUs are offered before the timeout, or if ctx is done first.
+func (a *Allocator) await(ctx context.Context, p *pending, n int) error {
+	window := a.clock.NewTimer(a.window - clock.Since(a.clock, p.start))
+	defer window.Stop()
+	timeout := a.clock.NewTimer(a.timeout)
+	defer timeout.Stop()
+
+	var elapsed bool
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-a.ctx.Done():
+			return errClosed
+		case <-timeout.C():
+			return fmt.Errorf("could not find %v free GPUs on the network", n)
+		case <-window.C():
+			elapsed = true
+		case <-p.arrived:
+		}
+
+		if elapsed && func() bool {
+			a.l.Lock()
+			defer a.l.Unlock()
+			return p.offered() >= n
+		}() {
+			return nil
+		}
+	}
+}
+
+// Release returns a leased GPU before the lease expires. Leases granted by the
+// local inventory are released immediately; otherwise, a release message is
+// sent to the responding governor.
+func (a *Allocator) Release(resp *gpupb.LeaseResponse) error {
+	if resp.GetResponder() == "" || resp.GetResponder() == a.requestor {
+		r, ok := a.local.(remote.Releaser)
+		if !ok {
+			return fmt.Errorf("local inventory does not support releasing leases")
+		}
+		return r.Release(resp.GetLease())
+	}
+
+	t := a.clock.NewTimer(a.timeout)
+	defer t.Stop()
+
+	select {
+	case <-a.ctx.Done():
+		return errClosed
+	case <-t.C():
+		return fmt.Errorf("could not write GPU lease release to the network")
+	case a.relPub <- &gpupb.LeaseRelease{
+		Requestor: a.requestor,
+		Responder: resp.GetResponder(),
+		Lease:     resp.GetLease(),
+	}:
+	}
+	if a.ledger != nil {
+		a.ledger.Return(resp.GetLease())
+	}
+	return nil
+}
+
+// Renew extends a lease by duration d from now, and returns the renewed
+// lease.
+//
+// Renewals of remote leases are not acknowledged by the responder -- the
+// returned expiration is an estimate, and the renewal may silently fail if
+// the remote lease has already expired.
+func (a *Allocator) Renew(resp *gpupb.LeaseResponse, d time.Duration) (*gpupb.LeaseResponse, error) {
+	if resp.GetResponder() == "" || resp.GetResponder() == a.requestor {
+		r, ok := a.local.(remote.Releaser)
+		if !ok {
+			return nil, fmt.Errorf("local inventory does not support renewing leases")
 		}
+		l, err := r.Renew(resp.GetLease(), d)
+		if err != nil {
+			return nil, err
+		}
+		renewed := proto.Clone(resp).(*gpupb.LeaseResponse)
+		renewed.Lease = l
+		return renewed, nil
+	}
+
+	t := a.clock.NewTimer(a.timeout)
+	defer t.Stop()
+
+	select {
+	case <-a.ctx.Done():
+		return nil, errClosed
+	case <-t.C():
+		return nil, fmt.Errorf("could not write GPU lease renewal to the network")
+	case a.renPub <- &gpupb.LeaseRenew{
+		Requestor: a.requestor,
+		Responder: resp.GetResponder(),
+		Lease:     resp.GetLease(),
+		Duration:  dpb.New(d),
+	}:
+	}
+
+	renewed := proto.Clone(resp).(*gpupb.LeaseResponse)
+	renewed.GetLease().Expiration = tpb.New(a.clock.Now().Add(d))
+	if a.ledger != nil {
+		a.ledger.Update(renewed.GetLease())
 	}
-	return nil, fmt.Errorf("could not find a free GPU on the network")
+	return renewed, nil
 }
@@ -1 +1,1188 @@
 package pubsub
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kevmo314/fedtorch/governor/p2p"
+	"github.com/kevmo314/fedtorch/governor/pubsub/clock"
+	"github.com/kevmo314/fedtorch/governor/pubsub/ledger"
+	"github.com/kevmo314/fedtorch/governor/pubsub/local"
+	"github.com/kevmo314/fedtorch/governor/pubsub/quota"
+	"github.com/kevmo314/fedtorch/governor/pubsub/token"
+	"github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/goleak"
+
+	gpupb "github.com/kevmo314/fedtorch/governor/api/go/gpu"
+	dpb "google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestLeaseLocal(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h, err := p2p.NewHost("/ip4/127.0.0.1/tcp/0")
+	if err != nil {
+		t.Fatalf("NewHost() unexpectedly failed: %v", err)
+	}
+	defer h.Close()
+
+	ps, err := pubsub.NewGossipSub(ctx, h)
+	if err != nil {
+		t.Fatalf("NewGossipSub() unexpectedly failed: %v", err)
+	}
+
+	a := New(ctx, O{
+		PubSub: ps,
+		PeerID: h.ID(),
+		GPUs: []*gpupb.GPU{
+			&gpupb.GPU{
+				Id: 100,
+			},
+		},
+	}, time.Minute)
+
+	resp, err := a.Lease(ctx, &gpupb.LeaseRequest{
+		Duration: dpb.New(time.Minute),
+	})
+	if err != nil {
+		t.Fatalf("Lease() unexpectedly failed: %v", err)
+	}
+	if got, want := resp.GetRequestor(), h.ID().String(); got != want {
+		t.Errorf("GetRequestor() = %v, want = %v", got, want)
+	}
+	if err := token.Check(resp.GetLease().GetToken(), h.ID().String()); err != nil {
+		t.Errorf("Check() unexpectedly failed: %v", err)
+	}
+}
+
+// TestJournal checks that leases out of the inventory constructed by New are
+// restored from the journal by the next allocator.
+func TestJournal(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	o := O{
+		GPUs: []*gpupb.GPU{
+			&gpupb.GPU{
+				Id: 100,
+			},
+		},
+		Journal: filepath.Join(t.TempDir(), "journal"),
+	}
+
+	_, a := newAllocatorO(t, ctx, o)
+	resp, err := a.Lease(ctx, &gpupb.LeaseRequest{
+		Duration: dpb.New(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("Lease() unexpectedly failed: %v", err)
+	}
+	a.Close()
+
+	_, a = newAllocatorO(t, ctx, o)
+	defer a.Close()
+
+	leases := a.owned.Leases(resp.GetLease().GetToken())
+	if len(leases) != 1 || leases[0].GetGpu().GetId() != 100 {
+		t.Errorf("Leases() = %v, want a single lease on GPU 100", leases)
+	}
+}
+
+func newAllocator(t *testing.T, ctx context.Context, l *local.Allocator) (host.Host, *Allocator) {
+	t.Helper()
+	return newAllocatorO(t, ctx, O{Local: l})
+}
+
+func newAllocatorO(t testing.TB, ctx context.Context, o O) (host.Host, *Allocator) {
+	t.Helper()
+
+	h, err := p2p.NewHost("/ip4/127.0.0.1/tcp/0")
+	if err != nil {
+		t.Fatalf("NewHost() unexpectedly failed: %v", err)
+	}
+	t.Cleanup(func() { h.Close() })
+
+	ps, err := pubsub.NewGossipSub(ctx, h)
+	if err != nil {
+		t.Fatalf("NewGossipSub() unexpectedly failed: %v", err)
+	}
+
+	o.PubSub = ps
+	o.PeerID = h.ID()
+	if o.Window == 0 {
+		o.Window = time.Second
+	}
+	return h, New(ctx, o, time.Minute)
+}
+
+func TestLeaseN(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	la := local.New([]*gpupb.GPU{
+		&gpupb.GPU{
+			Id: 100,
+		},
+	}, 0)
+	lb := local.New([]*gpupb.GPU{
+		&gpupb.GPU{
+			Id: 200,
+		},
+		&gpupb.GPU{
+			Id: 201,
+		},
+		&gpupb.GPU{
+			Id: 202,
+		},
+	}, 0)
+
+	ha, a := newAllocator(t, ctx, la)
+	hb, _ := newAllocator(t, ctx, lb)
+	if err := ha.Connect(ctx, peer.AddrInfo{ID: hb.ID(), Addrs: hb.Addrs()}); err != nil {
+		t.Fatalf("Connect() unexpectedly failed: %v", err)
+	}
+
+	// Wait for the subscriptions to propagate.
+	time.Sleep(time.Second)
+
+	leases, err := a.LeaseN(ctx, &gpupb.LeaseRequest{
+		Duration: dpb.New(time.Hour),
+		Count:    3,
+	})
+	if err != nil {
+		t.Fatalf("LeaseN() unexpectedly failed: %v", err)
+	}
+	if got := len(leases); got != 3 {
+		t.Fatalf("len(LeaseN()) = %v, want = 3", got)
+	}
+
+	// Two of the remote GPUs should be committed, and the third released.
+	time.Sleep(time.Second)
+	if _, err := lb.Lease(ctx, &gpupb.LeaseRequest{
+		Duration: dpb.New(time.Hour),
+	}); err != nil {
+		t.Errorf("Lease() unexpectedly failed: %v", err)
+	}
+	if l, err := lb.Lease(ctx, &gpupb.LeaseRequest{
+		Duration: dpb.New(time.Hour),
+	}); err == nil {
+		t.Errorf("Lease() unexpectedly succeeded: %v", l)
+	}
+}
+
+// partial is a local inventory which only commits the first of the input
+// reservations.
+type partial struct {
+	*local.Allocator
+}
+
+func (p partial) Commit(token string, ids []int32, d time.Duration) ([]*gpupb.Lease, error) {
+	leases, _ := p.Allocator.Commit(token, ids[:1], d)
+	return leases, fmt.Errorf("no reservations found for token %v on GPUs %v", token, ids[1:])
+}
+
+// TestLeaseNCommitFailed checks that local leases are released if only some
+// of the local reservations are committed.
+func TestLeaseNCommitFailed(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	l := local.New([]*gpupb.GPU{
+		&gpupb.GPU{
+			Id: 100,
+		},
+		&gpupb.GPU{
+			Id: 101,
+		},
+	}, 0)
+	defer l.Close()
+
+	_, a := newAllocatorO(t, ctx, O{Local: partial{l}})
+	defer a.Close()
+
+	if leases, err := a.LeaseN(ctx, &gpupb.LeaseRequest{
+		Duration: dpb.New(time.Hour),
+		Count:    2,
+	}); err == nil {
+		t.Fatalf("LeaseN() unexpectedly succeeded: %v", leases)
+	}
+	if got := len(l.Expirations()); got != 0 {
+		t.Errorf("len(Expirations()) = %v, want = 0", got)
+	}
+}
+
+func TestReleaseRenew(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lb := local.New([]*gpupb.GPU{
+		&gpupb.GPU{
+			Id: 200,
+		},
+	}, 0)
+
+	ha, a := newAllocator(t, ctx, local.New(nil, 0))
+	hb, _ := newAllocator(t, ctx, lb)
+	if err := ha.Connect(ctx, peer.AddrInfo{ID: hb.ID(), Addrs: hb.Addrs()}); err != nil {
+		t.Fatalf("Connect() unexpectedly failed: %v", err)
+	}
+
+	// Wait for the subscriptions to propagate.
+	time.Sleep(time.Second)
+
+	resp, err := a.Lease(ctx, &gpupb.LeaseRequest{
+		Duration: dpb.New(time.Minute),
+	})
+	if err != nil {
+		t.Fatalf("Lease() unexpectedly failed: %v", err)
+	}
+	if got, want := resp.GetResponder(), hb.ID().String(); got != want {
+		t.Errorf("GetResponder() = %v, want = %v", got, want)
+	}
+
+	renewed, err := a.Renew(resp, time.Hour)
+	if err != nil {
+		t.Fatalf("Renew() unexpectedly failed: %v", err)
+	}
+	if !renewed.GetLease().GetExpiration().AsTime().After(resp.GetLease().GetExpiration().AsTime()) {
+		t.Errorf("Renew() did not extend the lease expiration")
+	}
+
+	time.Sleep(time.Second)
+	if _, err := lb.Renew(resp.GetLease(), time.Hour); err != nil {
+		t.Errorf("Renew() unexpectedly failed: %v", err)
+	}
+
+	if err := a.Release(renewed); err != nil {
+		t.Fatalf("Release() unexpectedly failed: %v", err)
+	}
+
+	// The remote GPU should be free again.
+	time.Sleep(time.Second)
+	if _, err := lb.Lease(ctx, &gpupb.LeaseRequest{
+		Duration: dpb.New(time.Hour),
+	}); err != nil {
+		t.Errorf("Lease() unexpectedly failed: %v", err)
+	}
+}
+
+func TestPreemption(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ha, a := newAllocator(t, ctx, local.New(nil, 0))
+	hb, b := newAllocatorO(t, ctx, O{
+		GPUs: []*gpupb.GPU{
+			&gpupb.GPU{
+				Id: 200,
+			},
+		},
+		PreemptionNotice: time.Second,
+	})
+	if err := ha.Connect(ctx, peer.AddrInfo{ID: hb.ID(), Addrs: hb.Addrs()}); err != nil {
+		t.Fatalf("Connect() unexpectedly failed: %v", err)
+	}
+
+	// Wait for the subscriptions to propagate.
+	time.Sleep(time.Second)
+
+	borrowed, err := a.Lease(ctx, &gpupb.LeaseRequest{
+		Duration: dpb.New(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("Lease() unexpectedly failed: %v", err)
+	}
+
+	// The owner reclaims its GPU.
+	resp, err := b.Lease(ctx, &gpupb.LeaseRequest{
+		Duration: dpb.New(time.Hour),
+		Priority: 1,
+	})
+	if err != nil {
+		t.Fatalf("Lease() unexpectedly failed: %v", err)
+	}
+	if got, want := resp.GetResponder(), hb.ID().String(); got != want {
+		t.Errorf("GetResponder() = %v, want = %v", got, want)
+	}
+
+	select {
+	case n := <-a.Preemptions():
+		if got, want := n.GetLease().GetToken(), borrowed.GetLease().GetToken(); got != want {
+			t.Errorf("GetToken() = %v, want = %v", got, want)
+		}
+		if got, want := n.GetResponder(), hb.ID().String(); got != want {
+			t.Errorf("GetResponder() = %v, want = %v", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Preemptions() did not receive a preemption notice")
+	}
+}
+
+func TestLeaseOffers(t *testing.T) {
+	configs := []struct {
+		name   string
+		policy Policy
+		want   int32
+	}{
+		{name: "Cheapest", policy: Cheapest, want: 300},
+		{name: "Fastest", policy: Fastest, want: 200},
+	}
+
+	for _, c := range configs {
+		t.Run(c.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			lb := local.New([]*gpupb.GPU{
+				&gpupb.GPU{
+					Id:        200,
+					ClockRate: 1590000,
+				},
+			}, 0)
+			lc := local.New([]*gpupb.GPU{
+				&gpupb.GPU{
+					Id:        300,
+					ClockRate: 1410000,
+				},
+			}, 0)
+
+			ha, a := newAllocatorO(t, ctx, O{
+				Local:  local.New(nil, 0),
+				Policy: c.policy,
+				Window: 3 * time.Second,
+			})
+			hb, _ := newAllocatorO(t, ctx, O{
+				Local:  lb,
+				Pricer: func(*gpupb.GPU) float64 { return 2 },
+			})
+			hc, _ := newAllocatorO(t, ctx, O{
+				Local:  lc,
+				Pricer: func(*gpupb.GPU) float64 { return 1 },
+			})
+			for _, h := range []host.Host{hb, hc} {
+				if err := ha.Connect(ctx, peer.AddrInfo{ID: h.ID(), Addrs: h.Addrs()}); err != nil {
+					t.Fatalf("Connect() unexpectedly failed: %v", err)
+				}
+			}
+
+			// Wait for the subscriptions to propagate.
+			time.Sleep(2 * time.Second)
+
+			resp, err := a.Lease(ctx, &gpupb.LeaseRequest{
+				Duration: dpb.New(time.Hour),
+			})
+			if err != nil {
+				t.Fatalf("Lease() unexpectedly failed: %v", err)
+			}
+			if got := resp.GetLease().GetGpu().GetId(); got != c.want {
+				t.Errorf("GetId() = %v, want = %v", got, c.want)
+			}
+
+			// The losing offer should have been released.
+			time.Sleep(time.Second)
+			loser := lb
+			if c.want == 200 {
+				loser = lc
+			}
+			if _, err := loser.Lease(ctx, &gpupb.LeaseRequest{
+				Duration: dpb.New(time.Hour),
+			}); err != nil {
+				t.Errorf("Lease() unexpectedly failed: %v", err)
+			}
+		})
+	}
+}
+
+// TestSchedule checks that remote requests which arrive while the daemon is
+// busy are served in order of fair share. The requests are delivered over an
+// unbuffered channel, as by sub.
+func TestSchedule(t *testing.T) {
+	q, err := quota.New(quota.O{})
+	if err != nil {
+		t.Fatalf("New() unexpectedly failed: %v", err)
+	}
+	q.Contribute("some-contributor", 8)
+
+	reqs := make(chan *gpupb.LeaseRequest)
+	a := &Allocator{
+		reqSub:  reqs,
+		quota:   q,
+		backlog: newBacklog(),
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		a.intake()
+	}()
+
+	for _, r := range []string{"some-request-host", "other-request-host", "some-contributor"} {
+		reqs <- &gpupb.LeaseRequest{Requestor: r}
+	}
+	close(reqs)
+	<-done
+
+	for i, want := range []string{"some-contributor", "some-request-host", "other-request-host"} {
+		req, ok := a.next()
+		if !ok {
+			t.Fatalf("next() unexpectedly failed")
+		}
+		if got := req.GetRequestor(); got != want {
+			t.Errorf("next()[%v].GetRequestor() = %v, want = %v", i, got, want)
+		}
+	}
+	if req, ok := a.next(); ok {
+		t.Errorf("next() = %v, want an empty backlog", req)
+	}
+}
+
+func TestLedger(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	la, err := ledger.New(ledger.O{})
+	if err != nil {
+		t.Fatalf("New() unexpectedly failed: %v", err)
+	}
+	lb, err := ledger.New(ledger.O{})
+	if err != nil {
+		t.Fatalf("New() unexpectedly failed: %v", err)
+	}
+
+	ha, a := newAllocatorO(t, ctx, O{
+		Local:          local.New(nil, 0),
+		Ledger:         la,
+		LedgerInterval: 500 * time.Millisecond,
+	})
+	hb, _ := newAllocatorO(t, ctx, O{
+		Local: local.New([]*gpupb.GPU{
+			&gpupb.GPU{
+				Id: 200,
+			},
+		}, 0),
+		Ledger:         lb,
+		LedgerInterval: 500 * time.Millisecond,
+	})
+	if err := ha.Connect(ctx, peer.AddrInfo{ID: hb.ID(), Addrs: hb.Addrs()}); err != nil {
+		t.Fatalf("Connect() unexpectedly failed: %v", err)
+	}
+
+	// Wait for the subscriptions to propagate.
+	time.Sleep(time.Second)
+
+	if _, err := a.Lease(ctx, &gpupb.LeaseRequest{
+		Duration: dpb.New(time.Hour),
+	}); err != nil {
+		t.Fatalf("Lease() unexpectedly failed: %v", err)
+	}
+
+	// Wait for the lease to be committed, and for the ledgers to be
+	// gossiped.
+	time.Sleep(2 * time.Second)
+
+	if got := la.Balance(hb.ID().String()).GetBorrowedSeconds(); got <= 0 {
+		t.Errorf("GetBorrowedSeconds() = %v, want > 0", got)
+	}
+	if got := lb.Balance(ha.ID().String()).GetLentSeconds(); got <= 0 {
+		t.Errorf("GetLentSeconds() = %v, want > 0", got)
+	}
+
+	// Each governor can check the other's account of the lease.
+	b, ok := la.Reported(hb.ID().String(), ha.ID().String())
+	if !ok {
+		t.Fatalf("Reported() unexpectedly failed")
+	}
+	if got := b.GetLentSeconds(); got <= 0 {
+		t.Errorf("GetLentSeconds() = %v, want > 0", got)
+	}
+}
+
+func TestQueue(t *testing.T) {
+	q := newQueue()
+	for _, p := range []int32{0, 1, 0, 2, 1} {
+		q.push(context.Background(), &gpupb.LeaseRequest{Priority: p}, nil)
+	}
+
+	var got []uint64
+	for _, w := range q.waiters {
+		got = append(got, w.seq)
+	}
+	want := []uint64{3, 1, 4, 0, 2}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("push() order = %v, want = %v", got, want)
+		}
+	}
+}
+
+func TestLeaseQueued(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	l := local.New([]*gpupb.GPU{
+		&gpupb.GPU{
+			Id: 100,
+		},
+	}, 0)
+	_, a := newAllocator(t, ctx, l)
+
+	held, err := a.Lease(ctx, &gpupb.LeaseRequest{
+		Duration: dpb.New(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("Lease() unexpectedly failed: %v", err)
+	}
+
+	type result struct {
+		resp *gpupb.LeaseResponse
+		err  error
+	}
+
+	status := make(chan Status, 1)
+	queued := make(chan result, 1)
+	go func() {
+		resp, err := a.LeaseQueued(ctx, &gpupb.LeaseRequest{
+			Duration: dpb.New(time.Minute),
+		}, status)
+		queued <- result{resp: resp, err: err}
+	}()
+
+	select {
+	case s := <-status:
+		if s.Position != 0 {
+			t.Errorf("Position = %v, want = 0", s.Position)
+		}
+		if s.ETA <= 59*time.Minute {
+			t.Errorf("ETA = %v, want approximately %v", s.ETA, time.Hour)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("LeaseQueued() did not report its status")
+	}
+
+	// A cancelled request is removed from the queue.
+	cctx, ccancel := context.WithCancel(ctx)
+	cancelled := make(chan error, 1)
+	go func() {
+		_, err := a.LeaseQueued(cctx, &gpupb.LeaseRequest{
+			Duration: dpb.New(time.Minute),
+		}, nil)
+		cancelled <- err
+	}()
+	ccancel()
+	select {
+	case err := <-cancelled:
+		if err != context.Canceled {
+			t.Errorf("LeaseQueued() = %v, want = %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("LeaseQueued() did not return after cancellation")
+	}
+
+	select {
+	case r := <-queued:
+		t.Fatalf("LeaseQueued() unexpectedly returned before the GPU was freed: %v, %v", r.resp, r.err)
+	default:
+	}
+
+	if err := a.Release(held); err != nil {
+		t.Fatalf("Release() unexpectedly failed: %v", err)
+	}
+	select {
+	case r := <-queued:
+		if r.err != nil {
+			t.Fatalf("LeaseQueued() unexpectedly failed: %v", r.err)
+		}
+		if got, want := r.resp.GetLease().GetGpu().GetId(), int32(100); got != want {
+			t.Errorf("GetId() = %v, want = %v", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("LeaseQueued() was not granted after the GPU was freed")
+	}
+}
+
+// TestRound checks that each remote search for a queued request is made under
+// a distinct token, so that late declines of an earlier search cannot release
+// the holds of a later one.
+func TestRound(t *testing.T) {
+	a := &Allocator{requestor: "some-request-host"}
+	req := &gpupb.LeaseRequest{
+		Requestor: "some-request-host",
+		Token:     "some-token",
+	}
+
+	seen := map[string]bool{req.GetToken(): true}
+	for i := 0; i < 2; i++ {
+		r, err := a.round(req)
+		if err != nil {
+			t.Fatalf("round() unexpectedly failed: %v", err)
+		}
+		if err := token.Check(r.GetToken(), a.requestor); err != nil {
+			t.Errorf("Check() unexpectedly failed: %v", err)
+		}
+		if seen[r.GetToken()] {
+			t.Errorf("GetToken() = %v, want a fresh token", r.GetToken())
+		}
+		seen[r.GetToken()] = true
+	}
+	if got := req.GetToken(); got != "some-token" {
+		t.Errorf("GetToken() = %v, want = %v", got, "some-token")
+	}
+}
+
+// TestLeaseQueuedCancelSearch checks that the remote search made on behalf of
+// a queued request stops once the request is cancelled.
+func TestLeaseQueuedCancelSearch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	l := local.New(nil, 0)
+	_, a := newAllocator(t, ctx, l)
+
+	// Each search is made under a fresh token, and the queued request
+	// is the only request which may be pending.
+	searching := func() bool {
+		a.l.Lock()
+		defer a.l.Unlock()
+
+		return len(a.pending) > 0
+	}
+
+	cctx, ccancel := context.WithCancel(ctx)
+	done := make(chan error, 1)
+	go func() {
+		_, err := a.LeaseQueued(cctx, &gpupb.LeaseRequest{
+			Duration: dpb.New(time.Minute),
+		}, nil)
+		done <- err
+	}()
+
+	for start := time.Now(); !searching(); time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("LeaseQueued() did not search for a remote GPU")
+		}
+	}
+	ccancel()
+	<-done
+
+	for start := time.Now(); searching(); time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatalf("LeaseQueued() did not stop searching after cancellation")
+		}
+	}
+}
+
+func TestClose(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h, err := p2p.NewHost("/ip4/127.0.0.1/tcp/0")
+	if err != nil {
+		t.Fatalf("NewHost() unexpectedly failed: %v", err)
+	}
+	defer h.Close()
+
+	ps, err := pubsub.NewGossipSub(ctx, h)
+	if err != nil {
+		t.Fatalf("NewGossipSub() unexpectedly failed: %v", err)
+	}
+
+	// Only check for goroutines started by the allocator.
+	opt := goleak.IgnoreCurrent()
+
+	o := O{
+		PubSub: ps,
+		PeerID: h.ID(),
+		GPUs: []*gpupb.GPU{
+			&gpupb.GPU{
+				Id: 100,
+			},
+		},
+		Ledger: func() *ledger.L {
+			l, err := ledger.New(ledger.O{})
+			if err != nil {
+				t.Fatalf("New() unexpectedly failed: %v", err)
+			}
+			return l
+		}(),
+		PreemptionNotice: time.Minute,
+		Window:           time.Second,
+	}
+	a := New(ctx, o, time.Minute)
+
+	if _, err := a.Lease(ctx, &gpupb.LeaseRequest{
+		Duration: dpb.New(time.Hour),
+	}); err != nil {
+		t.Fatalf("Lease() unexpectedly failed: %v", err)
+	}
+
+	// The queued request searches the network for a free GPU until the
+	// allocator is closed.
+	queued := make(chan error, 1)
+	go func() {
+		_, err := a.LeaseQueued(ctx, &gpupb.LeaseRequest{
+			Duration: dpb.New(time.Hour),
+		}, nil)
+		queued <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	a.Close()
+	a.Close()
+	select {
+	case err := <-queued:
+		if err == nil {
+			t.Errorf("LeaseQueued() unexpectedly succeeded")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("LeaseQueued() did not return after Close()")
+	}
+	if resp, err := a.Lease(ctx, &gpupb.LeaseRequest{
+		Duration: dpb.New(time.Hour),
+	}); err == nil {
+		t.Errorf("Lease() unexpectedly succeeded: %v", resp)
+	}
+	goleak.VerifyNone(t, opt)
+
+	// The topics and validators are released for reuse.
+	New(ctx, o, time.Minute).Close()
+	goleak.VerifyNone(t, opt)
+}
+
+// offer returns a fake offer of a single GPU for the input token.
+func offer(token string) *gpupb.LeaseResponse {
+	return &gpupb.LeaseResponse{
+		Responder: "some-responder",
+		Leases: []*gpupb.Lease{
+			&gpupb.Lease{
+				Gpu:   &gpupb.GPU{Id: 100},
+				Token: token,
+			},
+		},
+	}
+}
+
+// offers returns one offer of n GPUs from each of the input responders, in
+// order.
+func offers(token string, n int, responders ...string) []*gpupb.LeaseResponse {
+	var resps []*gpupb.LeaseResponse
+	for i, r := range responders {
+		resp := &gpupb.LeaseResponse{Responder: r}
+		for j := 0; j < n; j++ {
+			resp.Leases = append(resp.Leases, &gpupb.Lease{
+				Gpu:   &gpupb.GPU{Id: int32(100*i + j)},
+				Token: token,
+			})
+		}
+		resps = append(resps, resp)
+	}
+	return resps
+}
+
+// register tracks a pending request for the input token, as if the request
+// had been published.
+func register(a *Allocator, token string) *pending {
+	p := &pending{
+		start:   a.clock.Now(),
+		arrived: make(chan struct{}, 1),
+	}
+	a.l.Lock()
+	defer a.l.Unlock()
+	a.pending[token] = p
+	return p
+}
+
+func TestAwait(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, a := newAllocatorO(t, ctx, O{Window: 100 * time.Millisecond})
+
+	t.Run("Offer", func(t *testing.T) {
+		p := register(a, "some-token")
+		go func() {
+			time.Sleep(200 * time.Millisecond)
+			a.reserve(offer("some-token"))
+		}()
+
+		start := time.Now()
+		if err := a.await(ctx, p, 1); err != nil {
+			t.Fatalf("await() unexpectedly failed: %v", err)
+		}
+		if got := time.Since(start); got > 300*time.Millisecond {
+			t.Errorf("await() took %v, want < %v", got, 300*time.Millisecond)
+		}
+	})
+
+	t.Run("Window", func(t *testing.T) {
+		p := register(a, "other-token")
+		a.reserve(offer("other-token"))
+
+		start := time.Now()
+		if err := a.await(ctx, p, 1); err != nil {
+			t.Fatalf("await() unexpectedly failed: %v", err)
+		}
+		if got := time.Since(start); got < 50*time.Millisecond {
+			t.Errorf("await() took %v, want at least the offer window", got)
+		}
+	})
+
+	t.Run("Cancel", func(t *testing.T) {
+		p := register(a, "cancelled-token")
+		cctx, ccancel := context.WithTimeout(ctx, 200*time.Millisecond)
+		defer ccancel()
+		if err := a.await(cctx, p, 1); err != context.DeadlineExceeded {
+			t.Errorf("await() = %v, want = %v", err, context.DeadlineExceeded)
+		}
+	})
+}
+
+func TestAccept(t *testing.T) {
+	configs := []struct {
+		name   string
+		n      int
+		offers []*gpupb.LeaseResponse
+
+		// want is the number of GPUs accepted from each responder;
+		// offers from all other responders are declined.
+		want map[string]int
+
+		// fail is a responder which fails to commit, and silent is a
+		// responder which never acknowledges its commit.
+		fail   string
+		silent string
+	}{
+		{
+			name:   "Simultaneous",
+			n:      1,
+			offers: offers("some-token", 1, "responder-a", "responder-b", "responder-c"),
+			want:   map[string]int{"responder-a": 1},
+		},
+		{
+			name:   "Gang",
+			n:      3,
+			offers: offers("some-token", 2, "responder-a", "responder-b", "responder-c"),
+			want:   map[string]int{"responder-a": 2, "responder-b": 1},
+		},
+		{
+			name:   "Gang/Failed",
+			n:      3,
+			offers: offers("some-token", 2, "responder-a", "responder-b", "responder-c"),
+			want:   map[string]int{"responder-a": 2, "responder-b": 1},
+			fail:   "responder-b",
+		},
+		{
+			name:   "Gang/Unacknowledged",
+			n:      3,
+			offers: offers("some-token", 2, "responder-a", "responder-b", "responder-c"),
+			want:   map[string]int{"responder-a": 2, "responder-b": 1},
+			silent: "responder-b",
+		},
+	}
+
+	for _, c := range configs {
+		t.Run(c.name, func(t *testing.T) {
+			commits := make(chan *gpupb.LeaseCommit, len(c.offers))
+			releases := make(chan *gpupb.LeaseRelease, c.n)
+			a := &Allocator{
+				ctx:        context.Background(),
+				comPub:     commits,
+				relPub:     releases,
+				committing: map[string]chan *gpupb.LeaseAck{},
+				requestor:  "some-request-host",
+				timeout:    time.Second,
+				clock:      clock.Real,
+			}
+			defer a.wg.Wait()
+
+			type result struct {
+				accepted []*gpupb.LeaseResponse
+				err      error
+			}
+			done := make(chan result, 1)
+			go func() {
+				accepted, err := a.accept(context.Background(), &gpupb.LeaseRequest{
+					Token:    "some-token",
+					Duration: dpb.New(time.Hour),
+				}, c.n, c.offers)
+				done <- result{accepted: accepted, err: err}
+			}()
+
+			// Each responder receives exactly one commit, which
+			// either accepts or declines its offer. Accepted
+			// responders acknowledge the commit.
+			for range c.offers {
+				m := <-commits
+				if got, want := len(m.GetIds()), c.want[m.GetResponder()]; got != want {
+					t.Errorf("len(GetIds()) = %v for %v, want = %v", got, m.GetResponder(), want)
+				}
+				if got := m.GetToken(); got != "some-token" {
+					t.Errorf("GetToken() = %v, want = %v", got, "some-token")
+				}
+				if len(m.GetIds()) == 0 || m.GetResponder() == c.silent {
+					continue
+				}
+
+				ack := &gpupb.LeaseAck{
+					Requestor: m.GetRequestor(),
+					Responder: m.GetResponder(),
+					Token:     m.GetToken(),
+				}
+				for _, id := range m.GetIds() {
+					ack.Leases = append(ack.Leases, &gpupb.Lease{
+						Gpu:   &gpupb.GPU{Id: id},
+						Token: m.GetToken(),
+						State: gpupb.Lease_STATE_COMMITTED,
+					})
+				}
+				if m.GetResponder() == c.fail {
+					ack.Leases = nil
+					ack.Error = "hold has expired"
+				}
+				a.l.Lock()
+				a.committing[m.GetToken()] <- ack
+				a.l.Unlock()
+			}
+
+			r := <-done
+			if c.fail != "" || c.silent != "" {
+				if r.err == nil {
+					t.Fatalf("accept() unexpectedly succeeded: %v", r.accepted)
+				}
+
+				// The acknowledged leases are released.
+				a.wg.Wait()
+				if got, want := len(releases), c.want["responder-a"]; got != want {
+					t.Errorf("len(releases) = %v, want = %v", got, want)
+				}
+				return
+			}
+			if r.err != nil {
+				t.Fatalf("accept() unexpectedly failed: %v", r.err)
+			}
+			if got := len(r.accepted); got != c.n {
+				t.Errorf("len(accept()) = %v, want = %v", got, c.n)
+			}
+			for _, resp := range r.accepted {
+				if got := resp.GetLease().GetState(); got != gpupb.Lease_STATE_COMMITTED {
+					t.Errorf("GetState() = %v, want = %v", got, gpupb.Lease_STATE_COMMITTED)
+				}
+			}
+		})
+	}
+}
+
+// TestAckLate checks that leases acknowledged once the requestor has stopped
+// waiting are released.
+func TestAckLate(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	acks := make(chan *gpupb.LeaseAck, 1)
+	releases := make(chan *gpupb.LeaseRelease, 1)
+	a := &Allocator{
+		ctx:        ctx,
+		ackSub:     acks,
+		relPub:     releases,
+		committing: map[string]chan *gpupb.LeaseAck{},
+		requestor:  "some-request-host",
+	}
+
+	acks <- &gpupb.LeaseAck{
+		Requestor: "some-request-host",
+		Responder: "some-responder",
+		Token:     "some-token",
+		Leases: []*gpupb.Lease{
+			&gpupb.Lease{
+				Gpu:   &gpupb.GPU{Id: 100},
+				Token: "some-token",
+			},
+		},
+	}
+	close(acks)
+	a.acker()
+
+	select {
+	case r := <-releases:
+		if got, want := r.GetResponder(), "some-responder"; got != want {
+			t.Errorf("GetResponder() = %v, want = %v", got, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("late acknowledgement was not released")
+	}
+	a.wg.Wait()
+}
+
+func TestDeclineLate(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	commits := make(chan *gpupb.LeaseCommit, 1)
+	a := &Allocator{
+		ctx:       ctx,
+		comPub:    commits,
+		pending:   map[string]*pending{},
+		requestor: "some-request-host",
+		clock:     clock.Real,
+	}
+	defer a.wg.Wait()
+
+	// Offers which arrive once the request is no longer pending, e.g.
+	// after another offer has been accepted, are declined.
+	a.reserve(offer("some-token"))
+	select {
+	case m := <-commits:
+		if got := len(m.GetIds()); got != 0 {
+			t.Errorf("len(GetIds()) = %v, want = 0", got)
+		}
+		if got, want := m.GetResponder(), "some-responder"; got != want {
+			t.Errorf("GetResponder() = %v, want = %v", got, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("late offer was not declined")
+	}
+}
+
+func TestAwaitClock(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := clock.NewVirtual(time.Unix(0, 0))
+	_, a := newAllocatorO(t, ctx, O{Window: 30 * time.Second, Clock: c})
+
+	configs := []struct {
+		name    string
+		offer   bool
+		advance time.Duration
+		succ    bool
+	}{
+		{name: "Window", offer: true, advance: 30 * time.Second, succ: true},
+		{name: "Timeout", offer: false, advance: time.Minute, succ: false},
+	}
+
+	for _, cfg := range configs {
+		t.Run(cfg.name, func(t *testing.T) {
+			token := fmt.Sprintf("%v-token", cfg.name)
+			p := register(a, token)
+			if cfg.offer {
+				a.reserve(offer(token))
+			}
+
+			n := c.Len()
+			errs := make(chan error, 1)
+			go func() { errs <- a.await(ctx, p, 1) }()
+
+			// Wait for the window and timeout timers to be
+			// scheduled.
+			for c.Len() < n+2 {
+				time.Sleep(time.Millisecond)
+			}
+			select {
+			case err := <-errs:
+				t.Fatalf("await() = %v before the clock was advanced", err)
+			default:
+			}
+
+			c.Advance(cfg.advance)
+			select {
+			case err := <-errs:
+				if cfg.succ && err != nil {
+					t.Errorf("await() unexpectedly failed: %v", err)
+				} else if !cfg.succ && err == nil {
+					t.Errorf("await() unexpectedly succeeded")
+				}
+			case <-time.After(time.Second):
+				t.Errorf("await() did not return after the clock was advanced")
+			}
+		})
+	}
+}
+
+func TestNewTimeout(t *testing.T) {
+	configs := []struct {
+		name    string
+		hold    time.Duration
+		timeout time.Duration
+		succ    bool
+	}{
+		{name: "Default", timeout: time.Minute, succ: true},
+		{name: "Short", timeout: 10 * time.Second, succ: true},
+		{name: "Window", timeout: time.Second, succ: false},
+		{name: "Hold", hold: 2 * time.Minute, timeout: time.Minute, succ: true},
+		{name: "Hold/Window", hold: 2 * time.Second, timeout: time.Minute, succ: false},
+		{name: "Hold/Timeout", hold: time.Minute, timeout: time.Minute, succ: false},
+	}
+
+	for _, c := range configs {
+		t.Run(c.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			h, err := p2p.NewHost("/ip4/127.0.0.1/tcp/0")
+			if err != nil {
+				t.Fatalf("NewHost() unexpectedly failed: %v", err)
+			}
+			defer h.Close()
+
+			ps, err := pubsub.NewGossipSub(ctx, h)
+			if err != nil {
+				t.Fatalf("NewGossipSub() unexpectedly failed: %v", err)
+			}
+
+			var a *Allocator
+			func() {
+				defer func() {
+					if r := recover(); r != nil && c.succ {
+						t.Errorf("New() unexpectedly panicked: %v", r)
+					}
+				}()
+				a = New(ctx, O{
+					PubSub: ps,
+					PeerID: h.ID(),
+					Window: time.Second,
+					Hold:   c.hold,
+				}, c.timeout)
+			}()
+			if a != nil {
+				a.Close()
+			}
+			if !c.succ && a != nil {
+				t.Errorf("New() unexpectedly succeeded")
+			}
+		})
+	}
+}
+
+// BenchmarkAwait reports the latency between an offer arriving and the
+// waiting requestor waking up.
+func BenchmarkAwait(b *testing.B) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, a := newAllocatorO(b, ctx, O{Window: time.Nanosecond})
+
+	sent := make(chan time.Time, 1)
+	var wakeup time.Duration
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		token := fmt.Sprintf("token-%v", i)
+		p := register(a, token)
+		go func() {
+			sent <- time.Now()
+			a.reserve(offer(token))
+		}()
+		if err := a.await(ctx, p, 1); err != nil {
+			b.Fatalf("await() unexpectedly failed: %v", err)
+		}
+		wakeup += time.Since(<-sent)
+
+		a.l.Lock()
+		delete(a.pending, token)
+		a.l.Unlock()
+	}
+	b.ReportMetric(float64(wakeup.Nanoseconds())/float64(b.N), "ns/wakeup")
+}
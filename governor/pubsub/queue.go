@@ -0,0 +1,332 @@
+package pubsub
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kevmo314/fedtorch/governor/pubsub/clock"
+	"github.com/kevmo314/fedtorch/governor/pubsub/remote"
+	"github.com/kevmo314/fedtorch/governor/pubsub/token"
+	"google.golang.org/protobuf/proto"
+
+	gpupb "github.com/kevmo314/fedtorch/governor/api/go/gpu"
+)
+
+// Status reports the progress of a queued lease request.
+type Status struct {
+	// Position is the number of requests queued ahead of the request.
+	Position int
+
+	// ETA estimates how long until a local GPU is freed for the request,
+	// based on the expiration of the current local leases. ETA is zero
+	// if no estimate is available.
+	ETA time.Duration
+}
+
+// waiter is a locally issued lease request waiting for a free GPU.
+type waiter struct {
+	req *gpupb.LeaseRequest
+	seq uint64
+
+	// ctx is cancelled once the waiter is no longer interested in the
+	// request, and bounds the local and remote searches made on behalf
+	// of the waiter.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// status receives updates whenever the position of the waiter or
+	// the estimated time at which it will be granted a GPU changes.
+	status   chan<- Status
+	position int
+	eta      time.Time
+
+	// granted receives the lease once the request is fulfilled. The
+	// channel is buffered so that the dispatcher never blocks on a
+	// waiter.
+	granted chan *gpupb.LeaseResponse
+}
+
+// queue orders waiters by descending request priority, and then in order of
+// arrival.
+type queue struct {
+	l       sync.Mutex
+	waiters []*waiter
+	seq     uint64
+
+	// searching is set while a remote search is in flight for the head
+	// of the queue.
+	searching bool
+
+	wake chan struct{}
+}
+
+func newQueue() *queue {
+	return &queue{wake: make(chan struct{}, 1)}
+}
+
+// push adds the input request to the queue. The waiter context is derived from
+// the input context.
+func (q *queue) push(ctx context.Context, req *gpupb.LeaseRequest, status chan<- Status) *waiter {
+	q.l.Lock()
+	defer q.l.Unlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	w := &waiter{
+		req:      req,
+		seq:      q.seq,
+		ctx:      ctx,
+		cancel:   cancel,
+		status:   status,
+		position: -1,
+		granted:  make(chan *gpupb.LeaseResponse, 1),
+	}
+	q.seq++
+
+	i := sort.Search(len(q.waiters), func(i int) bool {
+		return q.waiters[i].req.GetPriority() < req.GetPriority()
+	})
+	q.waiters = append(q.waiters, nil)
+	copy(q.waiters[i+1:], q.waiters[i:])
+	q.waiters[i] = w
+
+	q.signal()
+	return w
+}
+
+// remove removes the input waiter from the queue, and returns false if the
+// waiter was no longer queued.
+//
+// The caller must hold the queue lock.
+func (q *queue) remove(w *waiter) bool {
+	for i, v := range q.waiters {
+		if v == w {
+			q.waiters = append(q.waiters[:i], q.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// signal wakes up the dispatcher. Signals are coalesced.
+func (q *queue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// LeaseQueued fulfills a local host's allocation request, like Lease, but
+// instead of failing when no GPU is free, waits in a queue until a local or
+// remote GPU becomes available.
+//
+// Queued requests are served in order of descending priority, and then in
+// order of arrival. A request which cannot currently be fulfilled does not
+// block requests behind it which can, e.g. due to different constraints.
+//
+// If status is non-nil, the position of the request in the queue and the
+// estimated wait are sent whenever they change. Status updates are dropped if
+// the channel is not ready.
+//
+// LeaseQueued returns when the request is granted, or when ctx is done or the
+// allocator is closed, in which case the request is removed from the queue.
+// GPUs granted by remote governors are leased under a fresh token for each
+// search of the network, rather than under the token of the request.
+func (a *Allocator) LeaseQueued(ctx context.Context, req *gpupb.LeaseRequest, status chan<- Status) (*gpupb.LeaseResponse, error) {
+	req, err := a.fill(req)
+	if err != nil {
+		return nil, err
+	}
+
+	w := a.queue.push(a.ctx, req, status)
+	defer w.cancel()
+
+	select {
+	case resp := <-w.granted:
+		return resp, nil
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-a.ctx.Done():
+		err = errClosed
+	}
+
+	// Stop any search in flight for the request.
+	w.cancel()
+
+	a.queue.l.Lock()
+	ok := a.queue.remove(w)
+	a.queue.l.Unlock()
+
+	// The request was granted concurrently with the cancellation, and the
+	// lease is no longer wanted.
+	if !ok {
+		a.Release(<-w.granted)
+	}
+	return nil, err
+}
+
+// dispatcher grants queued requests whenever a GPU may have been freed. Local
+// GPUs are checked whenever a local lease ends, and remote GPUs are searched
+// for continuously on behalf of the head of the queue.
+func (a *Allocator) dispatcher() {
+	var freed <-chan struct{}
+	if w, ok := a.local.(remote.Watcher); ok {
+		freed = w.Freed()
+	}
+
+	// Local leases may also end without notice, e.g. if the local
+	// inventory does not implement remote.Watcher.
+	t := a.clock.NewTicker(a.window)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-a.ctx.Done():
+			return
+		case <-a.queue.wake:
+		case <-freed:
+		case <-t.C():
+		}
+		a.dispatch()
+	}
+}
+
+// dispatch grants the queued requests which can be fulfilled by the local
+// inventory, starts a remote search for the head of the queue, and reports
+// the status of the remaining requests.
+func (a *Allocator) dispatch() {
+	a.queue.l.Lock()
+	waiters := append([]*waiter(nil), a.queue.waiters...)
+	a.queue.l.Unlock()
+
+	// The queue is not locked while leasing, as the local inventory may
+	// wait for a preempted GPU to be vacated.
+	for _, w := range waiters {
+		resp, err := a.local.Lease(w.ctx, w.req)
+		if err != nil {
+			continue
+		}
+		resp.Responder = a.requestor
+
+		a.queue.l.Lock()
+		ok := a.queue.remove(w)
+		a.queue.l.Unlock()
+
+		if !ok {
+			a.unwind([]*gpupb.Lease{resp.GetLease()})
+			continue
+		}
+		w.granted <- resp
+	}
+
+	a.queue.l.Lock()
+	defer a.queue.l.Unlock()
+
+	if len(a.queue.waiters) == 0 {
+		return
+	}
+
+	if !a.queue.searching {
+		a.queue.searching = true
+		w := a.queue.waiters[0]
+		a.spawn(func() { a.search(w) })
+	}
+
+	var expirations []time.Time
+	if w, ok := a.local.(remote.Watcher); ok {
+		expirations = w.Expirations()
+	}
+	for i, w := range a.queue.waiters {
+		if w.status == nil {
+			continue
+		}
+		var eta time.Time
+		if i < len(expirations) {
+			eta = expirations[i]
+		}
+		if i == w.position && eta.Equal(w.eta) {
+			continue
+		}
+
+		s := Status{Position: i}
+		if !eta.IsZero() {
+			s.ETA = clock.Until(a.clock, eta)
+		}
+		select {
+		case w.status <- s:
+			w.position, w.eta = i, eta
+		default:
+		}
+	}
+}
+
+// search collects remote offers for the input queued request, and grants the
+// request if it is still queued once the accepted offer is committed.
+// Otherwise, all offers are aborted, or the committed lease is released.
+//
+// Each search is made under a fresh token, so that a decline of the offers of
+// an earlier search which arrives late cannot release the holds of the current
+// search. The granted lease therefore carries the token of the search rather
+// than of the queued request.
+func (a *Allocator) search(w *waiter) {
+	var resp *gpupb.LeaseResponse
+	req, err := a.round(w.req)
+	var offers []*gpupb.LeaseResponse
+	if err == nil {
+		offers, err = a.gather(w.ctx, req, 1)
+	}
+	if err == nil {
+		if a.queued(w) {
+			// The queue is not locked while waiting for the
+			// commit to be acknowledged, so that local GPUs may
+			// still be granted in the meantime.
+			if accepted, err := a.accept(w.ctx, req, 1, offers); err == nil {
+				resp = accepted[0]
+			}
+		} else {
+			for _, resp := range offers {
+				a.abort(resp)
+			}
+		}
+	}
+
+	a.queue.l.Lock()
+	defer a.queue.l.Unlock()
+
+	a.queue.searching = false
+	a.queue.signal()
+
+	if resp == nil {
+		return
+	}
+	if !a.queue.remove(w) {
+		a.revoke(resp.GetResponder(), []*gpupb.Lease{resp.GetLease()})
+		return
+	}
+	w.granted <- resp
+}
+
+// round returns a copy of the input request under a freshly generated token.
+func (a *Allocator) round(req *gpupb.LeaseRequest) (*gpupb.LeaseRequest, error) {
+	t, err := token.New(a.requestor)
+	if err != nil {
+		return nil, err
+	}
+	req = proto.Clone(req).(*gpupb.LeaseRequest)
+	req.Token = t
+	return req, nil
+}
+
+// queued returns true if the input waiter is still queued.
+func (a *Allocator) queued(w *waiter) bool {
+	a.queue.l.Lock()
+	defer a.queue.l.Unlock()
+
+	for _, v := range a.queue.waiters {
+		if v == w {
+			return true
+		}
+	}
+	return false
+}
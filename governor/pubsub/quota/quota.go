@@ -0,0 +1,414 @@
+// Package quota enforces per-peer limits on the GPU time leased out of the
+// local inventory to remote requestors, and keeps a persistent usage ledger
+// of each peer for fair-share scheduling.
+//
+// Usage is measured in GPU-hours, where a fractional lease counts for its
+// fraction of the device. Leases are charged up front for their full duration
+// when committed, and the unused remainder is refunded if the lease is
+// released early.
+package quota
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/kevmo314/fedtorch/governor/pubsub/clock"
+	"github.com/kevmo314/fedtorch/governor/pubsub/token"
+	"google.golang.org/protobuf/proto"
+
+	gpupb "github.com/kevmo314/fedtorch/governor/api/go/gpu"
+	qpb "github.com/kevmo314/fedtorch/governor/api/go/quota"
+	tpb "google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	defaultWindow = 24 * time.Hour
+
+	// epsilon is the tolerance when comparing usage against limits.
+	epsilon = 1e-9
+)
+
+type O struct {
+	// MaxGPUs is the number of GPUs a single peer may hold at once,
+	// including tentative holds. If zero, the number of GPUs is
+	// unlimited.
+	MaxGPUs float64
+
+	// MaxGPUHours is the number of GPU-hours a single peer may consume
+	// within the accounting window. If zero, usage is unlimited.
+	MaxGPUHours float64
+
+	// Window is the accounting window of MaxGPUHours. Defaults to 24h.
+	Window time.Duration
+
+	// Path is the file the usage ledger is persisted to. The ledger is
+	// loaded from the file on construction, if it exists. If empty, the
+	// ledger is kept in memory only.
+	Path string
+
+	// Clock times lease expirations and the accounting window. Defaults
+	// to the wall clock.
+	Clock clock.Clock
+}
+
+type key struct {
+	id    int32
+	token string
+}
+
+type account struct {
+	charges     []*qpb.Charge
+	contributed float64
+
+	// leases are the committed leases held by the peer.
+	leases map[key]*gpupb.Lease
+
+	// holds are the tentative reservations held by the peer. Holds are
+	// short-lived, and are not persisted.
+	holds map[key]*gpupb.Lease
+}
+
+// Q tracks the usage of each peer against the configured quotas.
+type Q struct {
+	o O
+
+	l        sync.Mutex
+	accounts map[string]*account
+	clock    clock.Clock
+}
+
+// New constructs a quota tracker, and loads the usage ledger persisted at the
+// input path, if any.
+func New(o O) (*Q, error) {
+	if o.Window == 0 {
+		o.Window = defaultWindow
+	}
+	if o.Clock == nil {
+		o.Clock = clock.Real
+	}
+	q := &Q{
+		o:        o,
+		accounts: make(map[string]*account),
+		clock:    o.Clock,
+	}
+	if o.Path == "" {
+		return q, nil
+	}
+
+	data, err := os.ReadFile(o.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read usage ledger: %w", err)
+	}
+	var pb qpb.Ledger
+	if err := proto.Unmarshal(data, &pb); err != nil {
+		return nil, fmt.Errorf("invalid usage ledger %v: %w", o.Path, err)
+	}
+	for _, acc := range pb.GetAccounts() {
+		a := q.account(acc.GetPeer())
+		a.charges = acc.GetCharges()
+		a.contributed = acc.GetContributed()
+		for _, l := range acc.GetLeases() {
+			a.leases[key{id: l.GetGpu().GetId(), token: l.GetToken()}] = l
+		}
+	}
+	return q, nil
+}
+
+// Admit returns the number of GPUs, up to n, which may be leased to the
+// requestor of the input request without exceeding its quota. Admit returns
+// an error if no GPUs may be leased.
+func (q *Q) Admit(req *gpupb.LeaseRequest, n int) (int, error) {
+	f := req.GetFraction()
+	if f <= 0 {
+		f = 1
+	}
+	hours := f * req.GetDuration().AsDuration().Hours()
+
+	q.l.Lock()
+	defer q.l.Unlock()
+
+	a := q.accounts[req.GetRequestor()]
+	m := n
+	if q.o.MaxGPUs > 0 {
+		if k := int(math.Floor((q.o.MaxGPUs - a.active(q.clock.Now()) + epsilon) / f)); k < m {
+			m = k
+		}
+	}
+	if q.o.MaxGPUHours > 0 && hours > 0 {
+		if k := int(math.Floor((q.o.MaxGPUHours - a.consumed(q.o.Window, q.clock.Now()) + epsilon) / hours)); k < m {
+			m = k
+		}
+	}
+	if m <= 0 {
+		return 0, fmt.Errorf("requestor %v has exceeded its GPU quota", req.GetRequestor())
+	}
+	return m, nil
+}
+
+// Hold records tentative reservations made for a remote requestor, which
+// count against the number of GPUs the requestor may hold at once.
+func (q *Q) Hold(leases []*gpupb.Lease) {
+	q.l.Lock()
+	defer q.l.Unlock()
+
+	for _, l := range leases {
+		q.account(token.Requestor(l.GetToken())).holds[keyOf(l)] = l
+	}
+}
+
+// Commit drops all tentative reservations under the input token, and charges
+// the requestor for the input committed leases.
+func (q *Q) Commit(tok string, leases []*gpupb.Lease) error {
+	q.l.Lock()
+	defer q.l.Unlock()
+
+	a := q.account(token.Requestor(tok))
+	for k := range a.holds {
+		if k.token == tok {
+			delete(a.holds, k)
+		}
+	}
+	for _, l := range leases {
+		q.charge(l)
+	}
+	return q.save()
+}
+
+// Abort drops all tentative reservations under the input token.
+func (q *Q) Abort(tok string) {
+	q.Commit(tok, nil)
+}
+
+// Charge charges the requestor for the full duration of the input lease.
+func (q *Q) Charge(l *gpupb.Lease) error {
+	q.l.Lock()
+	defer q.l.Unlock()
+
+	q.charge(l)
+	return q.save()
+}
+
+// Release refunds the requestor for the unused remainder of the input lease.
+func (q *Q) Release(l *gpupb.Lease) error {
+	q.l.Lock()
+	defer q.l.Unlock()
+
+	q.refund(l)
+	return q.save()
+}
+
+// Renew replaces the charge for the existing lease with the same token on the
+// same GPU with a charge for the input renewed lease.
+func (q *Q) Renew(l *gpupb.Lease) error {
+	q.l.Lock()
+	defer q.l.Unlock()
+
+	q.refund(l)
+	q.charge(l)
+	return q.save()
+}
+
+// Contribute credits the input peer with having lent the input GPU-hours to
+// the local governor.
+func (q *Q) Contribute(peer string, hours float64) error {
+	q.l.Lock()
+	defer q.l.Unlock()
+
+	q.account(peer).contributed += hours
+	return q.save()
+}
+
+// Consumed returns the GPU-hours consumed by the input peer within the
+// accounting window.
+func (q *Q) Consumed(peer string) float64 {
+	q.l.Lock()
+	defer q.l.Unlock()
+
+	return q.accounts[peer].consumed(q.o.Window, q.clock.Now())
+}
+
+// Share returns the fair share of the input peer. Peers which have lent more
+// GPU time to the local governor relative to their own recent usage have a
+// larger share, and should be served first under contention.
+func (q *Q) Share(peer string) float64 {
+	q.l.Lock()
+	defer q.l.Unlock()
+
+	a := q.accounts[peer]
+	var contributed float64
+	if a != nil {
+		contributed = a.contributed
+	}
+	return (1 + contributed) / (1 + a.consumed(q.o.Window, q.clock.Now()))
+}
+
+// UseClock replaces the clock of the tracker, e.g. with the clock of the
+// allocator consulting the tracker.
+func (q *Q) UseClock(c clock.Clock) {
+	q.l.Lock()
+	defer q.l.Unlock()
+
+	q.clock = c
+}
+
+// account returns the account of the input peer, creating the account if
+// necessary. The caller must hold the lock.
+func (q *Q) account(peer string) *account {
+	a, ok := q.accounts[peer]
+	if !ok {
+		a = &account{
+			leases: make(map[key]*gpupb.Lease),
+			holds:  make(map[key]*gpupb.Lease),
+		}
+		q.accounts[peer] = a
+	}
+	return a
+}
+
+// charge records the input lease, and charges the requestor for the remaining
+// duration of the lease. The caller must hold the lock.
+func (q *Q) charge(l *gpupb.Lease) {
+	a := q.account(token.Requestor(l.GetToken()))
+	a.leases[keyOf(l)] = l
+	now := q.clock.Now()
+	a.charges = append(a.charges, &qpb.Charge{
+		Time:     tpb.New(now),
+		GpuHours: remaining(l, now),
+	})
+}
+
+// refund removes the existing lease with the same token on the same GPU as
+// the input lease, and refunds the requestor for the remaining duration of
+// the existing lease. The caller must hold the lock.
+func (q *Q) refund(l *gpupb.Lease) {
+	a := q.account(token.Requestor(l.GetToken()))
+	m, ok := a.leases[keyOf(l)]
+	if !ok {
+		return
+	}
+	delete(a.leases, keyOf(l))
+	now := q.clock.Now()
+	if r := remaining(m, now); r > 0 {
+		a.charges = append(a.charges, &qpb.Charge{
+			Time:     tpb.New(now),
+			GpuHours: -r,
+		})
+	}
+}
+
+// save prunes expired leases and charges which have fallen out of the
+// accounting window, and atomically persists the ledger. The caller must hold
+// the lock.
+func (q *Q) save() error {
+	now := q.clock.Now()
+	pb := &qpb.Ledger{}
+	for peer, a := range q.accounts {
+		var charges []*qpb.Charge
+		for _, c := range a.charges {
+			if now.Sub(c.GetTime().AsTime()) <= q.o.Window {
+				charges = append(charges, c)
+			}
+		}
+		a.charges = charges
+
+		acc := &qpb.Account{
+			Peer:        peer,
+			Charges:     a.charges,
+			Contributed: a.contributed,
+		}
+		for k, l := range a.leases {
+			if now.After(l.GetExpiration().AsTime()) {
+				delete(a.leases, k)
+				continue
+			}
+			acc.Leases = append(acc.Leases, l)
+		}
+		pb.Accounts = append(pb.Accounts, acc)
+	}
+
+	if q.o.Path == "" {
+		return nil
+	}
+
+	data, err := proto.Marshal(pb)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(q.o.Path), filepath.Base(q.o.Path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("cannot write usage ledger: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("cannot write usage ledger: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("cannot write usage ledger: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("cannot write usage ledger: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), q.o.Path); err != nil {
+		return fmt.Errorf("cannot write usage ledger: %w", err)
+	}
+	return nil
+}
+
+// active returns the number of GPUs held by the account as of the input time,
+// counting fractional leases by their fraction.
+func (a *account) active(now time.Time) float64 {
+	if a == nil {
+		return 0
+	}
+	var n float64
+	for _, m := range []map[key]*gpupb.Lease{a.leases, a.holds} {
+		for _, l := range m {
+			if now.Before(l.GetExpiration().AsTime()) {
+				n += share(l)
+			}
+		}
+	}
+	return n
+}
+
+// consumed returns the GPU-hours charged to the account within the input
+// window before the input time.
+func (a *account) consumed(window time.Duration, now time.Time) float64 {
+	if a == nil {
+		return 0
+	}
+	var hours float64
+	for _, c := range a.charges {
+		if now.Sub(c.GetTime().AsTime()) <= window {
+			hours += c.GetGpuHours()
+		}
+	}
+	return math.Max(hours, 0)
+}
+
+func keyOf(l *gpupb.Lease) key {
+	return key{id: l.GetGpu().GetId(), token: l.GetToken()}
+}
+
+// share returns the fraction of the device held by the input lease.
+func share(l *gpupb.Lease) float64 {
+	if l.GetFraction() == 0 {
+		return 1
+	}
+	return l.GetFraction()
+}
+
+// remaining returns the GPU-hours left on the input lease as of the input time.
+func remaining(l *gpupb.Lease, now time.Time) float64 {
+	return math.Max(share(l)*l.GetExpiration().AsTime().Sub(now).Hours(), 0)
+}
@@ -0,0 +1,231 @@
+package quota
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kevmo314/fedtorch/governor/pubsub/clock"
+	"github.com/kevmo314/fedtorch/governor/pubsub/token"
+
+	gpupb "github.com/kevmo314/fedtorch/governor/api/go/gpu"
+	dpb "google.golang.org/protobuf/types/known/durationpb"
+	tpb "google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func lease(t *testing.T, requestor string, id int32, fraction float64, d time.Duration) *gpupb.Lease {
+	t.Helper()
+
+	tok, err := token.New(requestor)
+	if err != nil {
+		t.Fatalf("New() unexpectedly failed: %v", err)
+	}
+	return &gpupb.Lease{
+		Token:      tok,
+		Gpu:        &gpupb.GPU{Id: id},
+		Expiration: tpb.New(time.Now().Add(d)),
+		Fraction:   fraction,
+	}
+}
+
+func TestAdmit(t *testing.T) {
+	configs := []struct {
+		name string
+		o    O
+		held []*gpupb.Lease
+		req  *gpupb.LeaseRequest
+		n    int
+		want int
+		succ bool
+	}{
+		{
+			name: "Unlimited",
+			o:    O{},
+			req:  &gpupb.LeaseRequest{Requestor: "some-request-host", Duration: dpb.New(time.Hour)},
+			n:    8,
+			want: 8,
+			succ: true,
+		},
+		{
+			name: "MaxGPUs",
+			o:    O{MaxGPUs: 2},
+			held: []*gpupb.Lease{lease(t, "some-request-host", 100, 0, time.Hour)},
+			req:  &gpupb.LeaseRequest{Requestor: "some-request-host", Duration: dpb.New(time.Hour)},
+			n:    8,
+			want: 1,
+			succ: true,
+		},
+		{
+			name: "MaxGPUs/Fractional",
+			o:    O{MaxGPUs: 1},
+			held: []*gpupb.Lease{lease(t, "some-request-host", 100, 0.5, time.Hour)},
+			req:  &gpupb.LeaseRequest{Requestor: "some-request-host", Duration: dpb.New(time.Hour), Fraction: 0.25},
+			n:    8,
+			want: 2,
+			succ: true,
+		},
+		{
+			name: "MaxGPUs/OtherRequestor",
+			o:    O{MaxGPUs: 1},
+			held: []*gpupb.Lease{lease(t, "other-request-host", 100, 0, time.Hour)},
+			req:  &gpupb.LeaseRequest{Requestor: "some-request-host", Duration: dpb.New(time.Hour)},
+			n:    1,
+			want: 1,
+			succ: true,
+		},
+		{
+			name: "MaxGPUs/Expired",
+			o:    O{MaxGPUs: 1},
+			held: []*gpupb.Lease{lease(t, "some-request-host", 100, 0, -time.Hour)},
+			req:  &gpupb.LeaseRequest{Requestor: "some-request-host", Duration: dpb.New(time.Hour)},
+			n:    1,
+			want: 1,
+			succ: true,
+		},
+		{
+			name: "MaxGPUHours",
+			o:    O{MaxGPUHours: 10},
+			held: []*gpupb.Lease{lease(t, "some-request-host", 100, 0, 4*time.Hour)},
+			req:  &gpupb.LeaseRequest{Requestor: "some-request-host", Duration: dpb.New(2 * time.Hour)},
+			n:    8,
+			want: 3,
+			succ: true,
+		},
+		{
+			name: "Exceeded",
+			o:    O{MaxGPUHours: 4},
+			held: []*gpupb.Lease{lease(t, "some-request-host", 100, 0, 4*time.Hour)},
+			req:  &gpupb.LeaseRequest{Requestor: "some-request-host", Duration: dpb.New(time.Hour)},
+			n:    1,
+			succ: false,
+		},
+	}
+
+	for _, c := range configs {
+		t.Run(c.name, func(t *testing.T) {
+			q, err := New(c.o)
+			if err != nil {
+				t.Fatalf("New() unexpectedly failed: %v", err)
+			}
+			for _, l := range c.held {
+				q.Charge(l)
+			}
+
+			got, err := q.Admit(c.req, c.n)
+			if !c.succ {
+				if err == nil {
+					t.Errorf("Admit() unexpectedly succeeded: %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Admit() unexpectedly failed: %v", err)
+			}
+			if got != c.want {
+				t.Errorf("Admit() = %v, want = %v", got, c.want)
+			}
+		})
+	}
+}
+
+// TestClock checks that leases are held and charged according to the
+// injected clock rather than the wall clock.
+func TestClock(t *testing.T) {
+	start := time.Unix(0, 0)
+	v := clock.NewVirtual(start)
+	q, err := New(O{MaxGPUs: 1, Clock: v})
+	if err != nil {
+		t.Fatalf("New() unexpectedly failed: %v", err)
+	}
+
+	l := lease(t, "some-request-host", 100, 0, 0)
+	l.Expiration = tpb.New(start.Add(time.Hour))
+	if err := q.Charge(l); err != nil {
+		t.Fatalf("Charge() unexpectedly failed: %v", err)
+	}
+	if got, want := q.Consumed("some-request-host"), 1.0; got != want {
+		t.Errorf("Consumed() = %v, want = %v", got, want)
+	}
+
+	req := &gpupb.LeaseRequest{Requestor: "some-request-host"}
+	if n, err := q.Admit(req, 1); err == nil {
+		t.Errorf("Admit() = %v, want an error", n)
+	}
+	v.Advance(2 * time.Hour)
+	if _, err := q.Admit(req, 1); err != nil {
+		t.Errorf("Admit() unexpectedly failed: %v", err)
+	}
+}
+
+func TestRelease(t *testing.T) {
+	q, err := New(O{})
+	if err != nil {
+		t.Fatalf("New() unexpectedly failed: %v", err)
+	}
+
+	l := lease(t, "some-request-host", 100, 0, 4*time.Hour)
+	q.Charge(l)
+	q.Release(l)
+	if got := q.Consumed("some-request-host"); got > 0.01 {
+		t.Errorf("Consumed() = %v, want = 0", got)
+	}
+
+	// Releasing twice does not refund twice.
+	q.Charge(l)
+	q.Release(l)
+	q.Release(l)
+	if got := q.Consumed("some-request-host"); got < 0 || got > 0.01 {
+		t.Errorf("Consumed() = %v, want = 0", got)
+	}
+}
+
+func TestShare(t *testing.T) {
+	q, err := New(O{})
+	if err != nil {
+		t.Fatalf("New() unexpectedly failed: %v", err)
+	}
+
+	q.Contribute("some-request-host", 8)
+	q.Charge(lease(t, "other-request-host", 100, 0, 8*time.Hour))
+
+	if q.Share("some-request-host") <= q.Share("unknown-request-host") {
+		t.Errorf("Share() did not favour a contributing peer")
+	}
+	if q.Share("other-request-host") >= q.Share("unknown-request-host") {
+		t.Errorf("Share() did not penalize a consuming peer")
+	}
+}
+
+func TestPersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger")
+
+	q, err := New(O{Path: path, MaxGPUs: 1})
+	if err != nil {
+		t.Fatalf("New() unexpectedly failed: %v", err)
+	}
+	if err := q.Charge(lease(t, "some-request-host", 100, 0, 2*time.Hour)); err != nil {
+		t.Fatalf("Charge() unexpectedly failed: %v", err)
+	}
+	if err := q.Contribute("other-request-host", 4); err != nil {
+		t.Fatalf("Contribute() unexpectedly failed: %v", err)
+	}
+
+	q, err = New(O{Path: path, MaxGPUs: 1})
+	if err != nil {
+		t.Fatalf("New() unexpectedly failed: %v", err)
+	}
+	if got := q.Consumed("some-request-host"); got < 1.99 || got > 2.01 {
+		t.Errorf("Consumed() = %v, want = 2", got)
+	}
+	if got, want := q.Share("other-request-host"), 5.0; got != want {
+		t.Errorf("Share() = %v, want = %v", got, want)
+	}
+
+	// The restored lease still counts against the GPU quota.
+	if n, err := q.Admit(&gpupb.LeaseRequest{
+		Requestor: "some-request-host",
+		Duration:  dpb.New(time.Hour),
+	}, 1); err == nil {
+		t.Errorf("Admit() unexpectedly succeeded: %v", n)
+	}
+}
@@ -1,55 +1,189 @@
 package remote
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 	"sync"
 	"time"
 
-	"github.com/kevmo314/fedtorch/governor/pubsub/local"
+	"github.com/kevmo314/fedtorch/governor/pubsub/clock"
+	"github.com/kevmo314/fedtorch/governor/pubsub/expiry"
+	"github.com/kevmo314/fedtorch/governor/pubsub/ledger"
+	"github.com/kevmo314/fedtorch/governor/pubsub/quota"
+	"github.com/kevmo314/fedtorch/governor/pubsub/token"
+	"google.golang.org/protobuf/proto"
 
 	gpupb "github.com/kevmo314/fedtorch/governor/api/go/gpu"
+	tpb "google.golang.org/protobuf/types/known/timestamppb"
 )
 
+// Leaser grants GPU leases out of the local inventory.
+type Leaser interface {
+	Lease(ctx context.Context, req *gpupb.LeaseRequest) (*gpupb.LeaseResponse, error)
+}
+
+// Reserver places tentative holds on local GPUs on behalf of gang lease
+// requests.
+type Reserver interface {
+	Reserve(req *gpupb.LeaseRequest, n int, hold time.Duration) ([]*gpupb.Lease, error)
+	Commit(token string, ids []int32, d time.Duration) ([]*gpupb.Lease, error)
+	Abort(token string) int
+}
+
+// Releaser allows leases granted out of the local inventory to be returned
+// early or extended.
+type Releaser interface {
+	Release(l *gpupb.Lease) error
+	Renew(l *gpupb.Lease, d time.Duration) (*gpupb.Lease, error)
+}
+
+// Preempter reports the leases out of the local inventory which have been
+// preempted by higher priority requests.
+type Preempter interface {
+	Preemptions() <-chan *gpupb.Lease
+}
+
+// Watcher reports when GPUs in the local inventory may have been freed, and
+// estimates when currently leased GPUs will be freed.
+type Watcher interface {
+	Freed() <-chan struct{}
+	Expirations() []time.Time
+}
+
+// Pricer returns the asking price per GPU-hour of leasing the input GPU.
+type Pricer func(g *gpupb.GPU) float64
+
 type Allocator struct {
-	ambient <-chan *gpupb.LeaseResponse
-	returns chan *gpupb.LeaseResponse
+	ambient  <-chan *gpupb.LeaseResponse
+	releases <-chan *gpupb.LeaseRelease
+	renewals <-chan *gpupb.LeaseRenew
 
 	l         sync.Mutex
 	fulfilled map[string]*gpupb.LeaseResponse
 
-	local *local.Allocator
+	local  Leaser
+	price  Pricer
+	quota  *quota.Q
+	ledger *ledger.L
+
+	wait  time.Duration
+	clock clock.Clock
 
-	wait time.Duration
+	// expiry drops fulfilled requests from the cache once their leases
+	// expire, keyed by lease token.
+	expiry *expiry.S[string]
+
+	done chan struct{}
+	once sync.Once
+	wg   sync.WaitGroup
 }
 
 type O struct {
 	AmbientTraffic <-chan *gpupb.LeaseResponse
 
-	LocalAllocator *local.Allocator
+	// AmbientReleases and AmbientRenewals are used to keep the fulfilled
+	// request cache in sync with the lifetime of leases on the network.
+	// Either may be nil.
+	AmbientReleases <-chan *gpupb.LeaseRelease
+	AmbientRenewals <-chan *gpupb.LeaseRenew
+
+	LocalAllocator Leaser
+
+	// Pricer prices the offers made for remote requests. If nil, GPUs
+	// are offered for free.
+	Pricer Pricer
+
+	// Quota limits the GPUs and GPU time each remote requestor may lease
+	// out of the local inventory. If nil, requests are unlimited.
+	Quota *quota.Q
+
+	// Ledger records the GPU time lent to each remote requestor, and
+	// refuses requestors which owe too much GPU time. May be nil.
+	Ledger *ledger.L
+
+	// Clock times the backoff fuzzing and the expiry of fulfilled
+	// requests, and replaces the clocks of Quota and Ledger, so that
+	// admission decisions agree with the allocator on the time. Defaults
+	// to the wall clock.
+	Clock clock.Clock
 }
 
 func New(o O, wait time.Duration) *Allocator {
+	if o.Clock == nil {
+		o.Clock = clock.Real
+	}
+	if o.Quota != nil {
+		o.Quota.UseClock(o.Clock)
+	}
+	if o.Ledger != nil {
+		o.Ledger.UseClock(o.Clock)
+	}
 	a := &Allocator{
-		ambient: o.AmbientTraffic,
+		ambient:  o.AmbientTraffic,
+		releases: o.AmbientReleases,
+		renewals: o.AmbientRenewals,
 
-		returns:   make(chan *gpupb.LeaseResponse),
 		fulfilled: make(map[string]*gpupb.LeaseResponse),
 		local:     o.LocalAllocator,
+		price:     o.Pricer,
+		quota:     o.Quota,
+		ledger:    o.Ledger,
 		wait:      wait,
+		clock:     o.Clock,
+		expiry:    expiry.New[string](o.Clock),
+		done:      make(chan struct{}),
 	}
 
+	a.wg.Add(3)
 	go a.listener()
-	go a.cleaner()
+	go a.releaser()
+	go a.renewer()
 
 	return a
 }
 
+// Close stops listening for ambient traffic, cancels all pending expirations
+// of the fulfilled request cache, and blocks until all goroutines have exited.
+// Close does not close the local inventory.
+func (a *Allocator) Close() {
+	a.once.Do(func() {
+		close(a.done)
+		a.wg.Wait()
+		a.expiry.Close()
+	})
+}
+
 // Lease attempts to reserve a GPU for the incoming remote lease request.
-func (a *Allocator) Lease(req *gpupb.LeaseRequest) (*gpupb.LeaseResponse, error) {
+//
+// The fuzzed backoff only makes it less likely that several governors lease a
+// GPU for the same request; the losing leases are held until they expire.
+// Reserve and Commit should be preferred, as the requestor then accepts
+// exactly one offer and declines the rest, which releases the declined
+// reservations immediately.
+//
+// Lease returns early with an error if ctx is done before the GPU is
+// reserved.
+func (a *Allocator) Lease(ctx context.Context, req *gpupb.LeaseRequest) (*gpupb.LeaseResponse, error) {
+	if err := token.Check(req.GetToken(), req.GetRequestor()); err != nil {
+		return nil, err
+	}
+
+	if _, err := a.admit(req, 1); err != nil {
+		return nil, err
+	}
+
 	// Fuzz sleep for a bit in case someone else responds to the same
 	// request.
-	time.Sleep(time.Duration((1 + rand.Float64()) * float64(a.wait)))
+	t := a.clock.NewTimer(time.Duration((1 + rand.Float64()) * float64(a.wait)))
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-a.done:
+		return nil, fmt.Errorf("allocator is closed")
+	case <-t.C():
+	}
 
 	a.l.Lock()
 	defer a.l.Unlock()
@@ -60,51 +194,299 @@ func (a *Allocator) Lease(req *gpupb.LeaseRequest) (*gpupb.LeaseResponse, error)
 	}
 
 	// Attempt to reserve local GPU.
-	resp, err := a.local.Lease(req)
+	resp, err := a.local.Lease(ctx, req)
 	if err != nil {
 		return nil, err
 	}
+	if a.quota != nil {
+		// A failure to persist the usage ledger does not affect the
+		// in-memory accounting.
+		a.quota.Charge(resp.GetLease())
+	}
+	if a.ledger != nil {
+		a.ledger.Lend(req.GetRequestor(), resp.GetLease())
+	}
 
-	go func(resp *gpupb.LeaseResponse) {
-		time.Sleep(time.Until(resp.GetLease().GetExpiration().AsTime()))
-		a.returns <- resp
-	}(resp)
+	a.expire(resp)
 
 	return resp, nil
 }
 
+// Reserve places tentative holds on local GPUs for the incoming remote
+// request, and returns an offer for the reserved GPUs. Unlike Lease, Reserve
+// does not check if another governor has already responded, as the requestor
+// chooses between competing offers, and a gang may be spread across several
+// governors. The holds expire after the input duration unless the requestor
+// commits them.
+//
+// Reserve returns an error if the local inventory does not support
+// reservations.
+func (a *Allocator) Reserve(req *gpupb.LeaseRequest, hold time.Duration) (*gpupb.LeaseResponse, error) {
+	if err := token.Check(req.GetToken(), req.GetRequestor()); err != nil {
+		return nil, err
+	}
+
+	r, ok := a.local.(Reserver)
+	if !ok {
+		return nil, fmt.Errorf("local inventory does not support reservations")
+	}
+
+	n := int(req.GetCount())
+	if n < 1 {
+		n = 1
+	}
+	// Offer as much of the request as the quota allows; the requestor
+	// may fill the rest of a gang elsewhere.
+	n, err := a.admit(req, n)
+	if err != nil {
+		return nil, err
+	}
+	leases, err := r.Reserve(req, n, hold)
+	if err != nil {
+		return nil, err
+	}
+	if a.quota != nil {
+		a.quota.Hold(leases)
+	}
+
+	var price float64
+	if a.price != nil {
+		for _, l := range leases {
+			price += a.price(l.GetGpu())
+		}
+		price /= float64(len(leases))
+	}
+	return &gpupb.LeaseResponse{
+		Requestor: req.GetRequestor(),
+		Leases:    leases,
+		Offer: &gpupb.Offer{
+			Price:      price,
+			Expiration: leases[0].GetExpiration(),
+		},
+	}, nil
+}
+
+// admit returns the number of GPUs, up to n, which may be leased to the
+// requestor of the input request, or an error if the requestor should not be
+// served at all.
+func (a *Allocator) admit(req *gpupb.LeaseRequest, n int) (int, error) {
+	if a.ledger != nil {
+		if err := a.ledger.Serve(req.GetRequestor()); err != nil {
+			return 0, err
+		}
+	}
+	if a.quota != nil {
+		return a.quota.Admit(req, n)
+	}
+	return n, nil
+}
+
+// Commit finalizes or aborts the tentative holds made by Reserve. The
+// requestor sends each responder exactly one commit per request -- a commit
+// without GPU IDs declines the offer, and releases all holds for the token.
+//
+// Commit returns the committed leases, which should be acknowledged to the
+// requestor. Leases may be returned along with an error if only some of the
+// holds could be committed.
+func (a *Allocator) Commit(c *gpupb.LeaseCommit) ([]*gpupb.Lease, error) {
+	if err := token.Check(c.GetToken(), c.GetRequestor()); err != nil {
+		return nil, err
+	}
+
+	r, ok := a.local.(Reserver)
+	if !ok {
+		return nil, fmt.Errorf("local inventory does not support reservations")
+	}
+
+	if len(c.GetIds()) == 0 {
+		r.Abort(c.GetToken())
+		if a.quota != nil {
+			a.quota.Abort(c.GetToken())
+		}
+		return nil, nil
+	}
+	leases, err := r.Commit(c.GetToken(), c.GetIds(), c.GetDuration().AsDuration())
+	if a.quota != nil {
+		a.quota.Commit(c.GetToken(), leases)
+	}
+	if a.ledger != nil {
+		for _, l := range leases {
+			a.ledger.Lend(c.GetRequestor(), l)
+		}
+	}
+	return leases, err
+}
+
+// Release returns a GPU which was leased out of the local inventory to a
+// remote requestor.
+func (a *Allocator) Release(r *gpupb.LeaseRelease) error {
+	if err := token.Check(r.GetLease().GetToken(), r.GetRequestor()); err != nil {
+		return err
+	}
+
+	l, ok := a.local.(Releaser)
+	if !ok {
+		return fmt.Errorf("local inventory does not support releasing leases")
+	}
+	if err := l.Release(r.GetLease()); err != nil {
+		return err
+	}
+	if a.quota != nil {
+		a.quota.Release(r.GetLease())
+	}
+	if a.ledger != nil {
+		a.ledger.Return(r.GetLease())
+	}
+	return nil
+}
+
+// Renew extends a lease which was granted out of the local inventory to a
+// remote requestor.
+func (a *Allocator) Renew(r *gpupb.LeaseRenew) (*gpupb.Lease, error) {
+	if err := token.Check(r.GetLease().GetToken(), r.GetRequestor()); err != nil {
+		return nil, err
+	}
+
+	l, ok := a.local.(Releaser)
+	if !ok {
+		return nil, fmt.Errorf("local inventory does not support renewing leases")
+	}
+	m, err := l.Renew(r.GetLease(), r.GetDuration().AsDuration())
+	if err != nil {
+		return nil, err
+	}
+	if a.quota != nil {
+		a.quota.Renew(m)
+	}
+	if a.ledger != nil {
+		a.ledger.Update(m)
+	}
+	return m, nil
+}
+
+// expire drops the input fulfilled request from the cache once its lease
+// expires, replacing any previously scheduled expiration for the lease token.
+// The caller must hold the allocator lock.
+func (a *Allocator) expire(resp *gpupb.LeaseResponse) {
+	a.expiry.Schedule(resp.GetLease().GetToken(), resp.GetLease().GetExpiration().AsTime(), func() {
+		a.clean(resp)
+	})
+}
+
 func (a *Allocator) listener() {
-	for resp := range a.ambient {
+	defer a.wg.Done()
+	for {
+		var resp *gpupb.LeaseResponse
+		select {
+		case <-a.done:
+			return
+		case m, ok := <-a.ambient:
+			if !ok {
+				return
+			}
+			resp = m
+		}
+
+		// Gang reservations are tracked by the requestor, and
+		// multiple governors may respond to the same gang request.
+		if resp.GetLease() == nil {
+			continue
+		}
+
 		a.l.Lock()
 
 		a.fulfilled[resp.GetLease().GetToken()] = resp
+		a.expire(resp)
 
 		a.l.Unlock()
-
-		go func(resp *gpupb.LeaseResponse) {
-			time.Sleep(time.Until(resp.GetLease().GetExpiration().AsTime()))
-			a.returns <- resp
-		}(resp)
 	}
 }
 
-func (a *Allocator) cleaner() {
-	for resp := range a.returns {
+// releaser drops fulfilled requests whose leases have been released early.
+func (a *Allocator) releaser() {
+	defer a.wg.Done()
+	if a.releases == nil {
+		return
+	}
+	for {
+		var r *gpupb.LeaseRelease
+		select {
+		case <-a.done:
+			return
+		case m, ok := <-a.releases:
+			if !ok {
+				return
+			}
+			r = m
+		}
 		func() {
 			a.l.Lock()
 			defer a.l.Unlock()
 
-			m, ok := a.fulfilled[resp.GetLease().GetToken()]
+			m, ok := a.fulfilled[r.GetLease().GetToken()]
+			if !ok || m.GetRequestor() != r.GetRequestor() {
+				return
+			}
+			delete(a.fulfilled, r.GetLease().GetToken())
+			a.expiry.Cancel(r.GetLease().GetToken())
+		}()
+	}
+}
+
+// renewer extends the lifetime of fulfilled requests whose leases have been
+// renewed.
+func (a *Allocator) renewer() {
+	defer a.wg.Done()
+	if a.renewals == nil {
+		return
+	}
+	for {
+		var r *gpupb.LeaseRenew
+		select {
+		case <-a.done:
+			return
+		case m, ok := <-a.renewals:
 			if !ok {
 				return
 			}
+			r = m
+		}
+		func() {
+			a.l.Lock()
+			defer a.l.Unlock()
 
-			if resp.GetRequestor() != m.GetRequestor() {
+			m, ok := a.fulfilled[r.GetLease().GetToken()]
+			if !ok || m.GetRequestor() != r.GetRequestor() {
 				return
 			}
 
-			// Do not check expiration time.
-			delete(a.fulfilled, resp.GetLease().GetToken())
+			resp := proto.Clone(m).(*gpupb.LeaseResponse)
+			resp.GetLease().Expiration = tpb.New(a.clock.Now().Add(r.GetDuration().AsDuration()))
+			a.fulfilled[r.GetLease().GetToken()] = resp
+			a.expire(resp)
 		}()
 	}
 }
+
+// clean drops the input fulfilled request from the cache, unless the lease
+// has since been renewed or replaced.
+func (a *Allocator) clean(resp *gpupb.LeaseResponse) {
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	m, ok := a.fulfilled[resp.GetLease().GetToken()]
+	if !ok {
+		return
+	}
+
+	if resp.GetRequestor() != m.GetRequestor() {
+		return
+	}
+
+	// The lease may have been renewed since this expiration was
+	// scheduled.
+	if a.clock.Now().Before(m.GetLease().GetExpiration().AsTime()) {
+		return
+	}
+	delete(a.fulfilled, resp.GetLease().GetToken())
+}
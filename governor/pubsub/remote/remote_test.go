@@ -1,16 +1,30 @@
 package remote
 
 import (
+	"context"
 	"testing"
 	"time"
 
+	"github.com/kevmo314/fedtorch/governor/pubsub/clock"
+	"github.com/kevmo314/fedtorch/governor/pubsub/ledger"
 	"github.com/kevmo314/fedtorch/governor/pubsub/local"
+	"github.com/kevmo314/fedtorch/governor/pubsub/quota"
+	"github.com/kevmo314/fedtorch/governor/pubsub/token"
+	"go.uber.org/goleak"
 
 	gpupb "github.com/kevmo314/fedtorch/governor/api/go/gpu"
 	dpb "google.golang.org/protobuf/types/known/durationpb"
 	tpb "google.golang.org/protobuf/types/known/timestamppb"
 )
 
+var someToken = func() string {
+	t, err := token.New("some-request-host")
+	if err != nil {
+		panic(err)
+	}
+	return t
+}()
+
 func TestLease(t *testing.T) {
 	configs := []struct {
 		name string
@@ -26,7 +40,7 @@ func TestLease(t *testing.T) {
 			}, 0),
 			req: &gpupb.LeaseRequest{
 				Requestor: "some-request-host",
-				Token:     "some-token",
+				Token:     someToken,
 				Duration:  dpb.New(time.Second),
 			},
 			want: false,
@@ -43,16 +57,38 @@ func TestLease(t *testing.T) {
 			}, 0),
 			req: &gpupb.LeaseRequest{
 				Requestor: "some-request-host",
-				Token:     "some-token",
+				Token:     someToken,
 				Duration:  dpb.New(time.Second),
 			},
 			want: true,
 		},
+		{
+			name: "Unsatisfiable",
+			a: New(O{
+				AmbientTraffic: make(chan *gpupb.LeaseResponse),
+				LocalAllocator: local.New([]*gpupb.GPU{
+					&gpupb.GPU{
+						Id:     100,
+						Memory: 16 << 30,
+					},
+				}, 0),
+			}, 0),
+			req: &gpupb.LeaseRequest{
+				Requestor: "some-request-host",
+				Token:     someToken,
+				Duration:  dpb.New(time.Second),
+				Constraints: &gpupb.Constraints{
+					MinMemory: 32 << 30,
+				},
+			},
+			want: false,
+		},
 		{
 			name: "AlreadyLeased",
 			a: &Allocator{
+				clock: clock.Real,
 				fulfilled: map[string]*gpupb.LeaseResponse{
-					"some-token": &gpupb.LeaseResponse{
+					someToken: &gpupb.LeaseResponse{
 						Requestor: "some-request-host",
 					},
 				},
@@ -64,7 +100,7 @@ func TestLease(t *testing.T) {
 			},
 			req: &gpupb.LeaseRequest{
 				Requestor: "some-request-host",
-				Token:     "some-token",
+				Token:     someToken,
 				Duration:  dpb.New(time.Second),
 			},
 			want: false,
@@ -73,7 +109,7 @@ func TestLease(t *testing.T) {
 
 	for _, c := range configs {
 		t.Run(c.name, func(t *testing.T) {
-			resp, err := c.a.Lease(c.req)
+			resp, err := c.a.Lease(context.Background(), c.req)
 			if c.want && err != nil {
 				t.Errorf("Lease() unexpectedly failed: %v", err)
 			} else if !c.want && err == nil {
@@ -96,18 +132,18 @@ func TestListener(t *testing.T) {
 
 	// Emulate a fulfillment request from some other node.
 	ch <- &gpupb.LeaseResponse{
-		Requestor: "some-originator",
+		Requestor: "some-request-host",
 		Lease: &gpupb.Lease{
-			Token:      "some-token",
+			Token:      someToken,
 			Expiration: tpb.New(time.Now().Add(time.Hour)),
 		},
 	}
 
 	time.Sleep(time.Second)
 
-	resp, err := a.Lease(&gpupb.LeaseRequest{
-		Requestor: "some-originator",
-		Token:     "some-token",
+	resp, err := a.Lease(context.Background(), &gpupb.LeaseRequest{
+		Requestor: "some-request-host",
+		Token:     someToken,
 		Duration:  dpb.New(time.Hour),
 	})
 
@@ -115,3 +151,451 @@ func TestListener(t *testing.T) {
 		t.Errorf("Lease() unexpectedly succeeded: %v", resp)
 	}
 }
+
+func TestCommit(t *testing.T) {
+	l := local.New([]*gpupb.GPU{
+		&gpupb.GPU{
+			Id: 100,
+		},
+		&gpupb.GPU{
+			Id: 101,
+		},
+	}, 0)
+	a := New(O{
+		AmbientTraffic: make(chan *gpupb.LeaseResponse),
+		LocalAllocator: l,
+		Pricer: func(g *gpupb.GPU) float64 {
+			return float64(g.GetId())
+		},
+	}, 0)
+
+	resp, err := a.Reserve(&gpupb.LeaseRequest{
+		Requestor: "some-request-host",
+		Token:     someToken,
+		Count:     3,
+	}, time.Hour)
+	if err != nil {
+		t.Fatalf("Reserve() unexpectedly failed: %v", err)
+	}
+	if got := len(resp.GetLeases()); got != 2 {
+		t.Errorf("len(GetLeases()) = %v, want = 2", got)
+	}
+	if got := resp.GetOffer().GetPrice(); got != 100.5 {
+		t.Errorf("GetPrice() = %v, want = 100.5", got)
+	}
+
+	if _, err := a.Commit(&gpupb.LeaseCommit{
+		Requestor: "some-request-host",
+		Token:     someToken,
+		Ids:       []int32{100},
+		Duration:  dpb.New(time.Hour),
+	}); err != nil {
+		t.Errorf("Commit() unexpectedly failed: %v", err)
+	}
+
+	// The uncommitted reservation should have been released.
+	if _, err := l.Lease(context.Background(), &gpupb.LeaseRequest{
+		Duration: dpb.New(time.Hour),
+	}); err != nil {
+		t.Errorf("Lease() unexpectedly failed: %v", err)
+	}
+}
+
+func TestDecline(t *testing.T) {
+	type responder struct {
+		l *local.Allocator
+		a *Allocator
+	}
+	var responders []responder
+	for i := 0; i < 2; i++ {
+		l := local.New([]*gpupb.GPU{
+			&gpupb.GPU{
+				Id: 100,
+			},
+		}, 0)
+		defer l.Close()
+		a := New(O{
+			AmbientTraffic: make(chan *gpupb.LeaseResponse),
+			LocalAllocator: l,
+		}, 0)
+		defer a.Close()
+		responders = append(responders, responder{l: l, a: a})
+	}
+
+	// Both responders reserve a GPU for the same request.
+	for _, r := range responders {
+		if _, err := r.a.Reserve(&gpupb.LeaseRequest{
+			Requestor: "some-request-host",
+			Token:     someToken,
+		}, time.Hour); err != nil {
+			t.Fatalf("Reserve() unexpectedly failed: %v", err)
+		}
+	}
+
+	// The requestor accepts the first offer and declines the second.
+	for i, r := range responders {
+		c := &gpupb.LeaseCommit{
+			Requestor: "some-request-host",
+			Token:     someToken,
+			Duration:  dpb.New(time.Hour),
+		}
+		if i == 0 {
+			c.Ids = []int32{100}
+		}
+		if _, err := r.a.Commit(c); err != nil {
+			t.Fatalf("Commit() unexpectedly failed: %v", err)
+		}
+	}
+
+	for i, r := range responders {
+		_, err := r.l.Lease(context.Background(), &gpupb.LeaseRequest{
+			Duration: dpb.New(time.Hour),
+		})
+		if accepted := i == 0; accepted && err == nil {
+			t.Errorf("Lease() unexpectedly succeeded on the accepted responder")
+		} else if !accepted && err != nil {
+			t.Errorf("Lease() unexpectedly failed on the declined responder: %v", err)
+		}
+	}
+}
+
+func TestAmbientRelease(t *testing.T) {
+	ch := make(chan *gpupb.LeaseResponse, 1)
+	releases := make(chan *gpupb.LeaseRelease, 1)
+	a := New(O{
+		AmbientTraffic:  ch,
+		AmbientReleases: releases,
+		LocalAllocator: local.New([]*gpupb.GPU{
+			&gpupb.GPU{
+				Id: 100,
+			},
+		}, 0),
+	}, 0)
+
+	lease := &gpupb.Lease{
+		Token:      someToken,
+		Expiration: tpb.New(time.Now().Add(time.Hour)),
+	}
+	ch <- &gpupb.LeaseResponse{
+		Requestor: "some-request-host",
+		Lease:     lease,
+	}
+	time.Sleep(time.Second)
+
+	// Once the remote lease has been released, the request may be
+	// fulfilled again.
+	releases <- &gpupb.LeaseRelease{
+		Requestor: "some-request-host",
+		Lease:     lease,
+	}
+	time.Sleep(time.Second)
+
+	if _, err := a.Lease(context.Background(), &gpupb.LeaseRequest{
+		Requestor: "some-request-host",
+		Token:     someToken,
+		Duration:  dpb.New(time.Hour),
+	}); err != nil {
+		t.Errorf("Lease() unexpectedly failed: %v", err)
+	}
+}
+
+func TestReleaseGuessedToken(t *testing.T) {
+	l := local.New([]*gpupb.GPU{
+		&gpupb.GPU{
+			Id: 100,
+		},
+	}, 0)
+	a := New(O{
+		AmbientTraffic: make(chan *gpupb.LeaseResponse),
+		LocalAllocator: l,
+	}, 0)
+
+	resp, err := a.Reserve(&gpupb.LeaseRequest{
+		Requestor: "some-request-host",
+		Token:     someToken,
+	}, time.Hour)
+	if err != nil {
+		t.Fatalf("Reserve() unexpectedly failed: %v", err)
+	}
+	if _, err := a.Commit(&gpupb.LeaseCommit{
+		Requestor: "some-request-host",
+		Token:     someToken,
+		Ids:       []int32{100},
+		Duration:  dpb.New(time.Hour),
+	}); err != nil {
+		t.Fatalf("Commit() unexpectedly failed: %v", err)
+	}
+
+	guess, err := token.New("other-request-host")
+	if err != nil {
+		t.Fatalf("New() unexpectedly failed: %v", err)
+	}
+
+	configs := []struct {
+		name      string
+		requestor string
+		token     string
+	}{
+		// Another peer which learns the token cannot use it, as the
+		// token is bound to the original requestor.
+		{name: "Stolen", requestor: "other-request-host", token: someToken},
+		{name: "Guessed", requestor: "other-request-host", token: guess},
+		{name: "Unbound", requestor: "other-request-host", token: "some-token"},
+	}
+
+	for _, c := range configs {
+		t.Run(c.name, func(t *testing.T) {
+			lease := &gpupb.Lease{
+				Gpu:   resp.GetLeases()[0].GetGpu(),
+				Token: c.token,
+			}
+			if err := a.Release(&gpupb.LeaseRelease{
+				Requestor: c.requestor,
+				Lease:     lease,
+			}); err == nil {
+				t.Errorf("Release() unexpectedly succeeded")
+			}
+			if l, err := a.Renew(&gpupb.LeaseRenew{
+				Requestor: c.requestor,
+				Lease:     lease,
+				Duration:  dpb.New(time.Hour),
+			}); err == nil {
+				t.Errorf("Renew() unexpectedly succeeded: %v", l)
+			}
+		})
+	}
+
+	// The GPU should still be held by the original requestor.
+	if resp, err := l.Lease(context.Background(), &gpupb.LeaseRequest{
+		Duration: dpb.New(time.Hour),
+	}); err == nil {
+		t.Errorf("Lease() unexpectedly succeeded: %v", resp)
+	}
+}
+
+func TestQuota(t *testing.T) {
+	q, err := quota.New(quota.O{
+		MaxGPUs:     2,
+		MaxGPUHours: 2.5,
+	})
+	if err != nil {
+		t.Fatalf("New() unexpectedly failed: %v", err)
+	}
+	a := New(O{
+		AmbientTraffic: make(chan *gpupb.LeaseResponse),
+		LocalAllocator: local.New([]*gpupb.GPU{
+			&gpupb.GPU{Id: 100},
+			&gpupb.GPU{Id: 101},
+			&gpupb.GPU{Id: 102},
+			&gpupb.GPU{Id: 103},
+		}, 0),
+		Quota: q,
+	}, 0)
+
+	// Only two GPUs may be held at once.
+	resp, err := a.Reserve(&gpupb.LeaseRequest{
+		Requestor: "some-request-host",
+		Token:     someToken,
+		Duration:  dpb.New(time.Hour),
+		Count:     4,
+	}, time.Hour)
+	if err != nil {
+		t.Fatalf("Reserve() unexpectedly failed: %v", err)
+	}
+	if got := len(resp.GetLeases()); got != 2 {
+		t.Errorf("len(GetLeases()) = %v, want = 2", got)
+	}
+
+	other, err := token.New("some-request-host")
+	if err != nil {
+		t.Fatalf("New() unexpectedly failed: %v", err)
+	}
+	if resp, err := a.Reserve(&gpupb.LeaseRequest{
+		Requestor: "some-request-host",
+		Token:     other,
+		Duration:  dpb.New(time.Hour),
+	}, time.Hour); err == nil {
+		t.Errorf("Reserve() unexpectedly succeeded: %v", resp)
+	}
+
+	if _, err := a.Commit(&gpupb.LeaseCommit{
+		Requestor: "some-request-host",
+		Token:     someToken,
+		Ids:       []int32{resp.GetLeases()[0].GetGpu().GetId(), resp.GetLeases()[1].GetGpu().GetId()},
+		Duration:  dpb.New(time.Hour),
+	}); err != nil {
+		t.Fatalf("Commit() unexpectedly failed: %v", err)
+	}
+	if got := q.Consumed("some-request-host"); got < 1.99 || got > 2.01 {
+		t.Errorf("Consumed() = %v, want = 2", got)
+	}
+
+	// Releasing a GPU frees up a slot and refunds the unused GPU-hour, but
+	// the GPU-hour quota still caps the next lease.
+	if err := a.Release(&gpupb.LeaseRelease{
+		Requestor: "some-request-host",
+		Lease:     resp.GetLeases()[0],
+	}); err != nil {
+		t.Fatalf("Release() unexpectedly failed: %v", err)
+	}
+	if resp, err := a.Reserve(&gpupb.LeaseRequest{
+		Requestor: "some-request-host",
+		Token:     other,
+		Duration:  dpb.New(2 * time.Hour),
+	}, time.Hour); err == nil {
+		t.Errorf("Reserve() unexpectedly succeeded: %v", resp)
+	}
+	if _, err := a.Reserve(&gpupb.LeaseRequest{
+		Requestor: "some-request-host",
+		Token:     other,
+		Duration:  dpb.New(time.Hour),
+	}, time.Hour); err != nil {
+		t.Errorf("Reserve() unexpectedly failed: %v", err)
+	}
+}
+
+func TestLedger(t *testing.T) {
+	l, err := ledger.New(ledger.O{MaxDebt: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("New() unexpectedly failed: %v", err)
+	}
+	a := New(O{
+		AmbientTraffic: make(chan *gpupb.LeaseResponse),
+		LocalAllocator: local.New([]*gpupb.GPU{
+			&gpupb.GPU{Id: 100},
+		}, 0),
+		Ledger: l,
+	}, 0)
+
+	resp, err := a.Reserve(&gpupb.LeaseRequest{
+		Requestor: "some-request-host",
+		Token:     someToken,
+		Duration:  dpb.New(200 * time.Millisecond),
+	}, time.Hour)
+	if err != nil {
+		t.Fatalf("Reserve() unexpectedly failed: %v", err)
+	}
+	if _, err := a.Commit(&gpupb.LeaseCommit{
+		Requestor: "some-request-host",
+		Token:     someToken,
+		Ids:       []int32{resp.GetLeases()[0].GetGpu().GetId()},
+		Duration:  dpb.New(200 * time.Millisecond),
+	}); err != nil {
+		t.Fatalf("Commit() unexpectedly failed: %v", err)
+	}
+
+	// The requestor has since used more GPU time than it is allowed to
+	// owe.
+	time.Sleep(300 * time.Millisecond)
+	if got := l.Balance("some-request-host").GetLentSeconds(); got < 0.1 {
+		t.Errorf("GetLentSeconds() = %v, want >= 0.1", got)
+	}
+
+	other, err := token.New("some-request-host")
+	if err != nil {
+		t.Fatalf("New() unexpectedly failed: %v", err)
+	}
+	if resp, err := a.Reserve(&gpupb.LeaseRequest{
+		Requestor: "some-request-host",
+		Token:     other,
+		Duration:  dpb.New(time.Hour),
+	}, time.Hour); err == nil {
+		t.Errorf("Reserve() unexpectedly succeeded: %v", resp)
+	}
+}
+
+func TestClose(t *testing.T) {
+	opt := goleak.IgnoreCurrent()
+
+	l := local.New([]*gpupb.GPU{
+		&gpupb.GPU{Id: 100},
+	}, 0)
+	defer l.Close()
+
+	ambient := make(chan *gpupb.LeaseResponse)
+	a := New(O{
+		AmbientTraffic:  ambient,
+		AmbientReleases: make(chan *gpupb.LeaseRelease),
+		AmbientRenewals: make(chan *gpupb.LeaseRenew),
+		LocalAllocator:  l,
+	}, time.Hour)
+
+	ambient <- &gpupb.LeaseResponse{
+		Requestor: "other-request-host",
+		Lease: &gpupb.Lease{
+			Token:      "other-token",
+			Expiration: tpb.New(time.Now().Add(time.Hour)),
+		},
+	}
+
+	// Lease waits for competing responses before reserving a GPU.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if resp, err := a.Lease(ctx, &gpupb.LeaseRequest{
+		Requestor: "some-request-host",
+		Token:     someToken,
+		Duration:  dpb.New(time.Hour),
+	}); err != context.DeadlineExceeded {
+		t.Errorf("Lease() = %v, %v, want = %v", resp, err, context.DeadlineExceeded)
+	}
+
+	a.Close()
+	l.Close()
+	goleak.VerifyNone(t, opt)
+}
+
+func TestClock(t *testing.T) {
+	c := clock.NewVirtual(time.Unix(0, 0))
+
+	l := local.New([]*gpupb.GPU{
+		&gpupb.GPU{Id: 100},
+	}, 0)
+	l.UseClock(c)
+	defer l.Close()
+
+	a := New(O{
+		AmbientTraffic: make(chan *gpupb.LeaseResponse),
+		LocalAllocator: l,
+		Clock:          c,
+	}, time.Hour)
+	defer a.Close()
+
+	type result struct {
+		resp *gpupb.LeaseResponse
+		err  error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		resp, err := a.Lease(context.Background(), &gpupb.LeaseRequest{
+			Requestor: "some-request-host",
+			Token:     someToken,
+			Duration:  dpb.New(time.Hour),
+		})
+		ch <- result{resp: resp, err: err}
+	}()
+
+	// Wait for the backoff fuzzing timer to be scheduled alongside the
+	// timers of the expiry schedulers.
+	for c.Len() < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case r := <-ch:
+		t.Fatalf("Lease() = %v, %v, want to wait for the backoff", r.resp, r.err)
+	default:
+	}
+
+	// The backoff is at most twice the wait time.
+	c.Advance(2 * time.Hour)
+	select {
+	case r := <-ch:
+		if r.err != nil {
+			t.Fatalf("Lease() unexpectedly failed: %v", r.err)
+		}
+		if got, want := r.resp.GetLease().GetExpiration().AsTime(), c.Now().Add(time.Hour); got.After(want) {
+			t.Errorf("GetExpiration() = %v, want <= %v", got, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Lease() did not return after the backoff elapsed in virtual time")
+	}
+}
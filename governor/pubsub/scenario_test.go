@@ -0,0 +1,221 @@
+package pubsub
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kevmo314/fedtorch/governor/pubsub/token"
+
+	gpupb "github.com/kevmo314/fedtorch/governor/api/go/gpu"
+	dpb "google.golang.org/protobuf/types/known/durationpb"
+)
+
+// lease issues a single GPU lease request from the input governor, and gives
+// up after the input timeout.
+func (n *network) lease(i int, d time.Duration, timeout time.Duration) (*gpupb.LeaseResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return n.governors[i].a.Lease(ctx, &gpupb.LeaseRequest{
+		Duration: dpb.New(d),
+	})
+}
+
+func TestScenarioContention(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Two requestors compete for the single GPU on the network.
+	n := newNetwork(t, ctx, [][]*gpupb.GPU{
+		nil,
+		nil,
+		[]*gpupb.GPU{&gpupb.GPU{Id: 200}},
+	}, O{})
+
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = n.lease(i, time.Hour, 5*time.Second)
+		}(i)
+	}
+	wg.Wait()
+
+	var granted int
+	for _, err := range errs {
+		if err == nil {
+			granted++
+		}
+	}
+	if granted != 1 {
+		t.Errorf("Lease() succeeded %v times, want = 1: %v", granted, errs)
+	}
+	if got := n.free(t, 2); got != 0 {
+		t.Errorf("free() = %v, want = 0", got)
+	}
+}
+
+func TestScenarioDuplicateFulfillment(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// All responders offer a GPU for the same request; only one offer
+	// may be accepted.
+	n := newNetwork(t, ctx, [][]*gpupb.GPU{
+		nil,
+		[]*gpupb.GPU{&gpupb.GPU{Id: 100}},
+		[]*gpupb.GPU{&gpupb.GPU{Id: 200}},
+		[]*gpupb.GPU{&gpupb.GPU{Id: 300}},
+	}, O{})
+
+	resp, err := n.lease(0, time.Hour, 10*time.Second)
+	if err != nil {
+		t.Fatalf("Lease() unexpectedly failed: %v", err)
+	}
+
+	// Wait for the rejected offers to be aborted.
+	time.Sleep(time.Second)
+
+	var free int
+	for i := 1; i < len(n.governors); i++ {
+		m := n.free(t, i)
+		if n.governors[i].host.ID().String() == resp.GetResponder() && m != 0 {
+			t.Errorf("free() = %v on the responder, want = 0", m)
+		}
+		free += m
+	}
+	if free != 2 {
+		t.Errorf("free() = %v in total, want = 2", free)
+	}
+}
+
+func TestScenarioPartition(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	n := newNetwork(t, ctx, [][]*gpupb.GPU{
+		nil,
+		[]*gpupb.GPU{&gpupb.GPU{Id: 100}},
+	}, O{})
+
+	n.partition(t, []int{0}, []int{1})
+	if resp, err := n.lease(0, time.Hour, 3*time.Second); err == nil {
+		t.Fatalf("Lease() unexpectedly succeeded across a partition: %v", resp)
+	}
+	if got := n.free(t, 1); got != 1 {
+		t.Errorf("free() = %v, want = 1", got)
+	}
+
+	n.heal(t)
+	if _, err := n.lease(0, time.Hour, 10*time.Second); err != nil {
+		t.Fatalf("Lease() unexpectedly failed after healing the partition: %v", err)
+	}
+}
+
+func TestScenarioExpiry(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	n := newNetwork(t, ctx, [][]*gpupb.GPU{
+		nil,
+		nil,
+		[]*gpupb.GPU{&gpupb.GPU{Id: 200}},
+	}, O{})
+
+	if _, err := n.lease(0, time.Second, 10*time.Second); err != nil {
+		t.Fatalf("Lease() unexpectedly failed: %v", err)
+	}
+	if got := n.free(t, 2); got != 0 {
+		t.Errorf("free() = %v, want = 0", got)
+	}
+
+	// Once the lease expires, the GPU may be leased by another governor.
+	time.Sleep(1500 * time.Millisecond)
+	if got := n.free(t, 2); got != 1 {
+		t.Errorf("free() = %v, want = 1", got)
+	}
+	if _, err := n.lease(1, time.Hour, 10*time.Second); err != nil {
+		t.Fatalf("Lease() unexpectedly failed after the lease expired: %v", err)
+	}
+}
+
+func TestScenarioStates(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Responders hold their offered GPUs tentatively for the duration of
+	// the offer window, after which exactly one hold is committed.
+	n := newNetwork(t, ctx, [][]*gpupb.GPU{
+		nil,
+		[]*gpupb.GPU{&gpupb.GPU{Id: 100}},
+		[]*gpupb.GPU{&gpupb.GPU{Id: 200}},
+	}, O{Window: 2 * time.Second})
+
+	tk, err := token.New(n.governors[0].host.ID().String())
+	if err != nil {
+		t.Fatalf("New() unexpectedly failed: %v", err)
+	}
+
+	type result struct {
+		resp *gpupb.LeaseResponse
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		resp, err := n.governors[0].a.Lease(ctx, &gpupb.LeaseRequest{
+			Token:    tk,
+			Duration: dpb.New(time.Hour),
+		})
+		done <- result{resp: resp, err: err}
+	}()
+
+	// Wait for both offers to be placed while the requestor collects
+	// them.
+	deadline := time.Now().Add(5 * time.Second)
+	for i := 1; i < len(n.governors); i++ {
+		for len(n.governors[i].local.Leases(tk)) == 0 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		leases := n.governors[i].local.Leases(tk)
+		if len(leases) != 1 {
+			t.Fatalf("len(Leases()) = %v on governor %v, want = 1", len(leases), i)
+		}
+		if got := leases[0].GetState(); got != gpupb.Lease_STATE_TENTATIVE {
+			t.Errorf("GetState() = %v on governor %v, want = %v", got, i, gpupb.Lease_STATE_TENTATIVE)
+		}
+	}
+
+	r := <-done
+	if r.err != nil {
+		t.Fatalf("Lease() unexpectedly failed: %v", r.err)
+	}
+	if got := r.resp.GetLease().GetState(); got != gpupb.Lease_STATE_COMMITTED {
+		t.Errorf("GetState() = %v, want = %v", got, gpupb.Lease_STATE_COMMITTED)
+	}
+
+	// Wait for the commit and the decline to reach the responders.
+	time.Sleep(time.Second)
+
+	for i := 1; i < len(n.governors); i++ {
+		leases := n.governors[i].local.Leases(tk)
+		if n.governors[i].host.ID().String() != r.resp.GetResponder() {
+			if len(leases) != 0 {
+				t.Errorf("Leases() = %v on the declined responder, want = []", leases)
+			}
+			continue
+		}
+		if len(leases) != 1 {
+			t.Fatalf("len(Leases()) = %v on the responder, want = 1", len(leases))
+		}
+		if got := leases[0].GetState(); got != gpupb.Lease_STATE_COMMITTED {
+			t.Errorf("GetState() = %v on the responder, want = %v", got, gpupb.Lease_STATE_COMMITTED)
+		}
+	}
+}
@@ -0,0 +1,660 @@
+// Package sim is a deterministic discrete-event simulator of GPU leasing
+// between governors.
+//
+// Synthetic workloads are replayed in virtual time against a cluster of
+// governors, so that the parameters of the lease protocol, e.g. the offer
+// window, the tentative hold and the request timeout, may be tuned without
+// waiting on the wall clock.
+//
+// Each governor runs a local.Allocator and a remote.Allocator on a shared
+// virtual clock, and the network is modelled as a fixed one-way message
+// latency. Requestors follow the offer and commit protocol of
+// pubsub.Allocator. A single GPU is leased out of the local inventory if
+// possible, and a gang is reserved locally first. Otherwise, the request is
+// broadcast, and each remote governor reserves what it can and replies with
+// an offer. The requestor collects offers for the offer window, or until
+// enough GPUs have been offered, whichever is later. It then commits the
+// offers preferred by the policy and declines the rest. The request fails if
+// not enough GPUs are offered before the timeout, or if any commit is not
+// acknowledged before the timeout, e.g. because the hold expired in transit.
+//
+// The simulator does not run pubsub.Allocator itself. It models the requestor
+// side of Lease and LeaseN, i.e. the offer window, the policy and the commit
+// and acknowledgement of the chosen offers, and the responder side of the
+// daemon and committer, by calling remote.Allocator.Reserve and Commit
+// directly. The libp2p transport and message loss, queued requests issued by
+// LeaseQueued, renewals, preemption, and the quota and ledger checks of remote
+// governors are not modelled.
+package sim
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/kevmo314/fedtorch/governor/pubsub"
+	"github.com/kevmo314/fedtorch/governor/pubsub/clock"
+	"github.com/kevmo314/fedtorch/governor/pubsub/local"
+	"github.com/kevmo314/fedtorch/governor/pubsub/remote"
+	"github.com/kevmo314/fedtorch/governor/pubsub/token"
+	"google.golang.org/protobuf/proto"
+
+	gpupb "github.com/kevmo314/fedtorch/governor/api/go/gpu"
+	dpb "google.golang.org/protobuf/types/known/durationpb"
+)
+
+type O struct {
+	// Governors is the number of governors in the cluster, and GPUs is
+	// the number of GPUs in the inventory of each governor.
+	Governors int
+	GPUs      int
+
+	// Requests is the number of lease requests in the workload. Requests
+	// arrive at uniformly random governors, with exponentially
+	// distributed interarrival times and lease durations of the input
+	// means.
+	Requests     int
+	Interarrival time.Duration
+	Duration     time.Duration
+
+	// Gang is the largest number of GPUs in a single request. Each
+	// request asks for a uniformly random number of GPUs between one and
+	// Gang. Defaults to one.
+	Gang int
+
+	// Latency is the one-way delay of each message between governors.
+	Latency time.Duration
+
+	// Policy chooses between competing offers. Defaults to
+	// pubsub.Cheapest.
+	Policy pubsub.Policy
+
+	// Window is how long a requestor collects offers before choosing
+	// between them, and must be shorter than Timeout. Timeout bounds how
+	// long a requestor waits for enough offers, and then for its commits
+	// to be acknowledged. Hold is how long remote governors keep the
+	// tentative holds backing their offers.
+	Window  time.Duration
+	Timeout time.Duration
+	Hold    time.Duration
+
+	// Seed seeds the workload. Runs with the same options produce the
+	// same report, as lease tokens only identify requests.
+	Seed int64
+}
+
+type Report struct {
+	Requests int
+
+	// Local and Remote are the number of requests granted entirely out of
+	// the inventory of the requestor, and in part by remote governors,
+	// respectively. Failed requests were not granted.
+	Local  int
+	Remote int
+	Failed int
+
+	// Declined is the number of offers declined by requestors, either in
+	// favour of another offer, or because the request was no longer
+	// pending. Expired is the number of accepted offers whose holds had
+	// already expired when the commit reached the responder.
+	Declined int
+	Expired  int
+
+	// Unused is the number of GPUs committed for a request which the
+	// requestor did not use, e.g. because the commit was acknowledged
+	// after the timeout, or because another part of the gang could not be
+	// committed. These leases are released by the requestor.
+	Unused int
+
+	// Utilization is the fraction of the GPU time of the cluster used by
+	// requestors, and Waste is the fraction held idle by tentative holds
+	// and unused commits, both over the elapsed time.
+	Utilization float64
+	Waste       float64
+
+	// MeanWait and MaxWait are the time granted requests waited for their
+	// GPUs, from arrival until the last commit was acknowledged.
+	MeanWait time.Duration
+	MaxWait  time.Duration
+
+	// Elapsed is the virtual time until the last lease or hold ends.
+	Elapsed time.Duration
+}
+
+func (r Report) String() string {
+	return fmt.Sprintf(
+		"requests=%v local=%v remote=%v failed=%v declined=%v expired=%v unused=%v utilization=%.3f waste=%.3f wait=%v/%v elapsed=%v",
+		r.Requests, r.Local, r.Remote, r.Failed, r.Declined, r.Expired, r.Unused,
+		r.Utilization, r.Waste, r.MeanWait, r.MaxWait, r.Elapsed)
+}
+
+type governor struct {
+	id     string
+	local  *local.Allocator
+	remote *remote.Allocator
+}
+
+// hold identifies the tentative holds placed by a governor for a request.
+type hold struct {
+	governor int
+	token    string
+}
+
+type request struct {
+	req       *gpupb.LeaseRequest
+	requestor int
+	arrival   time.Time
+
+	// reserved are the GPUs reserved for a gang out of the inventory of
+	// the requestor.
+	reserved []*gpupb.Lease
+
+	// gathering is set while the requestor collects offers, and elapsed
+	// is set once the offer window has elapsed.
+	gathering bool
+	elapsed   bool
+	start     time.Time
+	offers    []*pubsub.Offer
+	timeout   clock.Timer
+
+	// committing is set while the requestor waits for its commits to be
+	// acknowledged, and maps each accepted responder to the number of
+	// GPUs committed.
+	committing bool
+	accepted   map[int]int
+	failed     bool
+	leases     []*gpupb.Lease
+	responders []int
+}
+
+type sim struct {
+	o         O
+	clock     *clock.Virtual
+	rand      *rand.Rand
+	start     time.Time
+	governors []*governor
+
+	report Report
+
+	// holds are the outstanding tentative holds placed by each governor.
+	holds map[hold][]*gpupb.Lease
+
+	// used and wasted are the total GPU time leased for requestors, and
+	// held idle, respectively.
+	used   time.Duration
+	wasted time.Duration
+	waited time.Duration
+
+	// end is when the last lease or hold ends.
+	end time.Time
+}
+
+// Run replays a synthetic workload generated from the input options, and
+// reports the resulting allocation statistics.
+func Run(o O) (Report, error) {
+	if o.Governors <= 0 {
+		return Report{}, fmt.Errorf("invalid number of governors %v", o.Governors)
+	}
+	if o.GPUs < 0 {
+		return Report{}, fmt.Errorf("invalid number of GPUs %v", o.GPUs)
+	}
+	if o.Requests < 0 {
+		return Report{}, fmt.Errorf("invalid number of requests %v", o.Requests)
+	}
+	if o.Gang < 0 {
+		return Report{}, fmt.Errorf("invalid gang size %v", o.Gang)
+	}
+	if o.Interarrival <= 0 || o.Duration <= 0 || o.Timeout <= 0 || o.Hold <= 0 {
+		return Report{}, fmt.Errorf("interarrival time, duration, timeout and hold must be positive")
+	}
+	if o.Latency < 0 || o.Window < 0 {
+		return Report{}, fmt.Errorf("latency and offer window must be non-negative")
+	}
+	if o.Window >= o.Timeout {
+		return Report{}, fmt.Errorf("offer window %v must be shorter than the request timeout %v", o.Window, o.Timeout)
+	}
+	if o.Gang == 0 {
+		o.Gang = 1
+	}
+	if o.Policy == nil {
+		o.Policy = pubsub.Cheapest
+	}
+
+	start := time.Unix(0, 0)
+	s := &sim{
+		o:     o,
+		clock: clock.NewVirtual(start),
+		rand:  rand.New(rand.NewSource(o.Seed)),
+		start: start,
+		holds: map[hold][]*gpupb.Lease{},
+		end:   start,
+	}
+	for i := 0; i < o.Governors; i++ {
+		var gpus []*gpupb.GPU
+		for j := 0; j < o.GPUs; j++ {
+			gpus = append(gpus, &gpupb.GPU{Id: int32(j)})
+		}
+		l := local.New(gpus, 0)
+		l.UseClock(s.clock)
+		defer l.Close()
+
+		r := remote.New(remote.O{
+			LocalAllocator: l,
+			Clock:          s.clock,
+		}, 0)
+		defer r.Close()
+
+		s.governors = append(s.governors, &governor{
+			id:     fmt.Sprintf("governor-%v", i),
+			local:  l,
+			remote: r,
+		})
+	}
+
+	// Arrivals are generated up front, so that the workload does not
+	// depend on the protocol parameters.
+	var at time.Duration
+	for i := 0; i < o.Requests; i++ {
+		at += time.Duration(s.rand.ExpFloat64() * float64(o.Interarrival))
+		g := s.rand.Intn(o.Governors)
+		d := time.Duration(s.rand.ExpFloat64() * float64(o.Duration))
+		if d <= 0 {
+			d = time.Nanosecond
+		}
+
+		t, err := token.New(s.governors[g].id)
+		if err != nil {
+			return Report{}, err
+		}
+		r := &request{
+			req: &gpupb.LeaseRequest{
+				Requestor: s.governors[g].id,
+				Token:     t,
+				Duration:  dpb.New(d),
+				Count:     int32(1 + s.rand.Intn(o.Gang)),
+			},
+			requestor: g,
+			accepted:  map[int]int{},
+		}
+		s.clock.AfterFunc(at, func() { s.arrive(r) })
+	}
+
+	for s.clock.Step() {
+	}
+
+	return s.summarize(), nil
+}
+
+// arrive issues the input request from its requestor. As in pubsub.Allocator,
+// a single GPU is leased out of the local inventory if possible, and a gang is
+// reserved locally first; the remainder is requested from the network.
+func (s *sim) arrive(r *request) {
+	s.report.Requests++
+	r.arrival = s.clock.Now()
+
+	g := s.governors[r.requestor]
+	n := int(r.req.GetCount())
+	if n == 1 {
+		if resp, err := g.local.Lease(context.Background(), r.req); err == nil {
+			s.report.Local++
+			s.grant(r, []*gpupb.Lease{resp.GetLease()})
+			return
+		}
+	} else if leases, err := g.local.Reserve(r.req, n, s.o.Hold); err == nil {
+		s.reserve(r.requestor, leases)
+		r.reserved = leases
+		if len(leases) == n {
+			if leases, ok := s.commitLocal(r); ok {
+				s.report.Local++
+				s.grant(r, leases)
+			}
+			return
+		}
+	}
+
+	s.gather(r)
+}
+
+// gather broadcasts the remainder of the input request, and collects offers
+// until the offer window has elapsed and enough GPUs have been offered, or
+// until the timeout.
+func (s *sim) gather(r *request) {
+	r.gathering = true
+	r.start = s.clock.Now()
+
+	req := proto.Clone(r.req).(*gpupb.LeaseRequest)
+	req.Count = int32(s.remaining(r))
+	for i := range s.governors {
+		if i == r.requestor {
+			continue
+		}
+		i := i
+		s.clock.AfterFunc(s.o.Latency, func() { s.respond(i, r, req) })
+	}
+
+	s.clock.AfterFunc(s.o.Window, func() {
+		r.elapsed = true
+		s.check(r)
+	})
+	r.timeout = s.clock.AfterFunc(s.o.Timeout, func() {
+		if !r.gathering {
+			return
+		}
+		r.gathering = false
+		for _, o := range r.offers {
+			s.decline(r, o.Response)
+		}
+		s.fail(r)
+	})
+}
+
+// remaining returns the number of GPUs the input request still needs from
+// remote governors.
+func (s *sim) remaining(r *request) int {
+	return int(r.req.GetCount()) - len(r.reserved)
+}
+
+// respond reserves GPUs on the input governor for a remote request, and
+// replies with an offer.
+func (s *sim) respond(i int, r *request, req *gpupb.LeaseRequest) {
+	g := s.governors[i]
+	resp, err := g.remote.Reserve(req, s.o.Hold)
+	if err != nil {
+		return
+	}
+	resp.Responder = g.id
+	s.reserve(i, resp.GetLeases())
+
+	s.clock.AfterFunc(s.o.Latency, func() { s.offer(r, resp) })
+}
+
+// offer delivers an offer to the requestor. Offers which arrive after the
+// requestor has stopped gathering are declined.
+func (s *sim) offer(r *request, resp *gpupb.LeaseResponse) {
+	if !r.gathering {
+		s.decline(r, resp)
+		return
+	}
+	r.offers = append(r.offers, &pubsub.Offer{
+		Response: resp,
+		Latency:  s.clock.Now().Sub(r.start),
+	})
+	s.check(r)
+}
+
+// check accepts the collected offers once the offer window has elapsed and
+// enough GPUs have been offered.
+func (s *sim) check(r *request) {
+	if !r.gathering || !r.elapsed {
+		return
+	}
+	var offered int
+	for _, o := range r.offers {
+		offered += len(o.Response.GetLeases())
+	}
+	if offered < s.remaining(r) {
+		return
+	}
+
+	r.gathering = false
+	r.timeout.Stop()
+
+	sort.SliceStable(r.offers, func(i, j int) bool {
+		return s.o.Policy(r.offers[i], r.offers[j])
+	})
+	s.accept(r)
+}
+
+// accept commits the local reservations and just enough of the preferred
+// offers to fill the request, and declines the rest. The requestor then waits
+// for each accepted responder to acknowledge its commit.
+func (s *sim) accept(r *request) {
+	if len(r.reserved) > 0 {
+		leases, ok := s.commitLocal(r)
+		if !ok {
+			for _, o := range r.offers {
+				s.decline(r, o.Response)
+			}
+			return
+		}
+		r.leases = append(r.leases, leases...)
+	}
+
+	n := s.remaining(r)
+	for _, o := range r.offers {
+		i := s.index(o.Response.GetResponder())
+		c := &gpupb.LeaseCommit{
+			Requestor: r.req.GetRequestor(),
+			Responder: o.Response.GetResponder(),
+			Token:     r.req.GetToken(),
+			Duration:  r.req.GetDuration(),
+		}
+		for _, l := range o.Response.GetLeases() {
+			if n == 0 {
+				break
+			}
+			c.Ids = append(c.Ids, l.GetGpu().GetId())
+			n--
+		}
+		if len(c.GetIds()) == 0 {
+			s.report.Declined++
+		} else {
+			r.accepted[i] = len(c.GetIds())
+		}
+		s.clock.AfterFunc(s.o.Latency, func() { s.commit(i, r, c) })
+	}
+
+	r.committing = true
+	r.timeout = s.clock.AfterFunc(s.o.Timeout, func() {
+		if !r.committing {
+			return
+		}
+		r.committing = false
+		s.revoke(r)
+		s.fail(r)
+	})
+}
+
+// commitLocal commits the gang reservations made out of the inventory of the
+// requestor. If any of the holds have expired, the committed leases are
+// released and the request fails.
+func (s *sim) commitLocal(r *request) ([]*gpupb.Lease, bool) {
+	var ids []int32
+	for _, l := range r.reserved {
+		ids = append(ids, l.GetGpu().GetId())
+	}
+	leases, err := s.governors[r.requestor].local.Commit(r.req.GetToken(), ids, r.req.GetDuration().AsDuration())
+	s.resolve(r.requestor, r.req.GetToken())
+	if err != nil {
+		s.report.Expired++
+		s.unwind(r, leases)
+		s.fail(r)
+		return nil, false
+	}
+	return leases, true
+}
+
+// commit delivers a commit to the input responder, which finalizes or
+// declines its holds, and acknowledges accepted commits.
+func (s *sim) commit(i int, r *request, c *gpupb.LeaseCommit) {
+	leases, err := s.governors[i].remote.Commit(c)
+	s.resolve(i, c.GetToken())
+	if len(c.GetIds()) == 0 {
+		return
+	}
+	if err != nil {
+		s.report.Expired++
+	}
+	s.clock.AfterFunc(s.o.Latency, func() { s.ack(i, r, leases, err) })
+}
+
+// ack delivers an acknowledgement to the requestor. Leases acknowledged after
+// the request has failed or timed out are revoked.
+func (s *sim) ack(i int, r *request, leases []*gpupb.Lease, err error) {
+	if !r.committing {
+		s.release(i, r, leases)
+		return
+	}
+
+	if err != nil || len(leases) != r.accepted[i] {
+		r.failed = true
+	}
+	delete(r.accepted, i)
+	for range leases {
+		r.responders = append(r.responders, i)
+	}
+	r.leases = append(r.leases, leases...)
+	if len(r.accepted) > 0 {
+		return
+	}
+
+	r.committing = false
+	r.timeout.Stop()
+	if r.failed {
+		s.revoke(r)
+		s.fail(r)
+		return
+	}
+	s.report.Remote++
+	s.grant(r, r.leases)
+}
+
+// revoke releases all leases committed for the input request.
+func (s *sim) revoke(r *request) {
+	local := r.leases[:len(r.leases)-len(r.responders)]
+	s.unwind(r, local)
+	for j, l := range r.leases[len(local):] {
+		s.release(r.responders[j], r, []*gpupb.Lease{l})
+	}
+	r.leases, r.responders = nil, nil
+}
+
+// unwind releases the input leases committed out of the inventory of the
+// requestor.
+func (s *sim) unwind(r *request, leases []*gpupb.Lease) {
+	for _, l := range leases {
+		s.report.Unused++
+		s.governors[r.requestor].local.Release(l)
+		s.waste(r, l)
+	}
+}
+
+// release sends a release for the input leases to the input responder.
+func (s *sim) release(i int, r *request, leases []*gpupb.Lease) {
+	for _, l := range leases {
+		l := l
+		s.report.Unused++
+		s.clock.AfterFunc(s.o.Latency, func() {
+			s.governors[i].remote.Release(&gpupb.LeaseRelease{
+				Requestor: r.req.GetRequestor(),
+				Responder: s.governors[i].id,
+				Lease:     l,
+			})
+			s.waste(r, l)
+		})
+	}
+}
+
+// decline sends a decline for the input offer to its responder.
+func (s *sim) decline(r *request, resp *gpupb.LeaseResponse) {
+	s.report.Declined++
+	i := s.index(resp.GetResponder())
+	s.clock.AfterFunc(s.o.Latency, func() {
+		s.commit(i, r, &gpupb.LeaseCommit{
+			Requestor: r.req.GetRequestor(),
+			Responder: resp.GetResponder(),
+			Token:     r.req.GetToken(),
+		})
+	})
+}
+
+// fail records the input request as failed, and aborts any gang reservations
+// still held out of the inventory of the requestor.
+func (s *sim) fail(r *request) {
+	s.report.Failed++
+	if len(r.reserved) > 0 {
+		s.governors[r.requestor].local.Abort(r.req.GetToken())
+		s.resolve(r.requestor, r.req.GetToken())
+	}
+}
+
+// grant records the input leases as used by the requestor.
+func (s *sim) grant(r *request, leases []*gpupb.Lease) {
+	wait := s.clock.Now().Sub(r.arrival)
+	s.waited += wait
+	if wait > s.report.MaxWait {
+		s.report.MaxWait = wait
+	}
+	for _, l := range leases {
+		exp := l.GetExpiration().AsTime()
+		s.used += exp.Sub(s.clock.Now())
+		s.extend(exp)
+	}
+}
+
+// waste adds the GPU time of the input lease from when it was committed until
+// now, i.e. when it was released unused.
+func (s *sim) waste(r *request, l *gpupb.Lease) {
+	committed := l.GetExpiration().AsTime().Add(-r.req.GetDuration().AsDuration())
+	s.wasted += s.clock.Now().Sub(committed)
+	s.extend(s.clock.Now())
+}
+
+// reserve records the input tentative holds placed by the input governor.
+func (s *sim) reserve(i int, leases []*gpupb.Lease) {
+	k := hold{governor: i, token: leases[0].GetToken()}
+	s.holds[k] = append(s.holds[k], leases...)
+}
+
+// resolve adds the GPU time held idle by the tentative holds placed by the
+// input governor for the input token, which have just been committed or
+// declined, or which have expired.
+func (s *sim) resolve(i int, token string) {
+	k := hold{governor: i, token: token}
+	for _, l := range s.holds[k] {
+		exp := l.GetExpiration().AsTime()
+		end := s.clock.Now()
+		if exp.Before(end) {
+			end = exp
+		}
+		s.wasted += end.Sub(exp.Add(-s.o.Hold))
+		s.extend(end)
+	}
+	delete(s.holds, k)
+}
+
+// extend records that a lease or hold ends at the input time.
+func (s *sim) extend(t time.Time) {
+	if t.After(s.end) {
+		s.end = t
+	}
+}
+
+// index returns the index of the governor with the input ID.
+func (s *sim) index(id string) int {
+	for i, g := range s.governors {
+		if g.id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *sim) summarize() Report {
+	for k := range s.holds {
+		s.resolve(k.governor, k.token)
+	}
+
+	r := s.report
+	r.Elapsed = s.end.Sub(s.start)
+	if granted := r.Local + r.Remote; granted > 0 {
+		r.MeanWait = s.waited / time.Duration(granted)
+	}
+	if capacity := float64(s.o.Governors*s.o.GPUs) * float64(r.Elapsed); capacity > 0 {
+		r.Utilization = float64(s.used) / capacity
+		r.Waste = float64(s.wasted) / capacity
+	}
+	return r
+}
@@ -0,0 +1,206 @@
+package sim
+
+import (
+	"testing"
+	"time"
+)
+
+// base is a small cluster under moderate load.
+var base = O{
+	Governors:    4,
+	GPUs:         2,
+	Requests:     200,
+	Interarrival: 5 * time.Second,
+	Duration:     30 * time.Second,
+	Latency:      20 * time.Millisecond,
+	Window:       time.Second,
+	Timeout:      time.Minute,
+	Hold:         2 * time.Minute,
+	Seed:         1,
+}
+
+func TestRun(t *testing.T) {
+	configs := []struct {
+		name  string
+		o     func(o O) O
+		check func(t *testing.T, r Report)
+	}{
+		{
+			name: "Empty",
+			o:    func(o O) O { o.Requests = 0; return o },
+			check: func(t *testing.T, r Report) {
+				if r != (Report{}) {
+					t.Errorf("Run() = %v, want = %v", r, Report{})
+				}
+			},
+		},
+		{
+			name: "Local",
+			o:    func(o O) O { o.Governors = 1; o.GPUs = 1000; return o },
+			check: func(t *testing.T, r Report) {
+				if r.Local != r.Requests {
+					t.Errorf("Local = %v, want = %v", r.Local, r.Requests)
+				}
+				if r.MaxWait != 0 {
+					t.Errorf("MaxWait = %v, want = 0", r.MaxWait)
+				}
+			},
+		},
+		{
+			name: "NoGPUs",
+			o:    func(o O) O { o.GPUs = 0; return o },
+			check: func(t *testing.T, r Report) {
+				if r.Failed != r.Requests {
+					t.Errorf("Failed = %v, want = %v", r.Failed, r.Requests)
+				}
+			},
+		},
+		{
+			name: "Remote",
+			o:    func(o O) O { return o },
+			check: func(t *testing.T, r Report) {
+				if got := r.Local + r.Remote + r.Failed; got != r.Requests {
+					t.Errorf("Local + Remote + Failed = %v, want = %v", got, r.Requests)
+				}
+				if r.Remote == 0 {
+					t.Errorf("Remote = 0, want > 0")
+				}
+				// Remote requests wait for the offer window
+				// and the commit round trip.
+				if min := base.Window + 2*base.Latency; r.MaxWait < min {
+					t.Errorf("MaxWait = %v, want >= %v", r.MaxWait, min)
+				}
+				if r.Utilization <= 0 || r.Utilization+r.Waste > 1 {
+					t.Errorf("Utilization, Waste = %v, %v, want in (0, 1]", r.Utilization, r.Waste)
+				}
+			},
+		},
+	}
+
+	for _, c := range configs {
+		t.Run(c.name, func(t *testing.T) {
+			r, err := Run(c.o(base))
+			if err != nil {
+				t.Fatalf("Run() unexpectedly failed: %v", err)
+			}
+			c.check(t, r)
+		})
+	}
+}
+
+func TestRunDeterministic(t *testing.T) {
+	want, err := Run(base)
+	if err != nil {
+		t.Fatalf("Run() unexpectedly failed: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		got, err := Run(base)
+		if err != nil {
+			t.Fatalf("Run() unexpectedly failed: %v", err)
+		}
+		if got != want {
+			t.Errorf("Run() = %v, want = %v", got, want)
+		}
+	}
+}
+
+func TestRunGang(t *testing.T) {
+	o := base
+	o.Gang = 4
+
+	r, err := Run(o)
+	if err != nil {
+		t.Fatalf("Run() unexpectedly failed: %v", err)
+	}
+	if got := r.Local + r.Remote + r.Failed; got != r.Requests {
+		t.Errorf("Local + Remote + Failed = %v, want = %v", got, r.Requests)
+	}
+	if r.Remote == 0 {
+		t.Errorf("Remote = 0, want > 0")
+	}
+	if r.Expired != 0 || r.Unused != 0 {
+		t.Errorf("Expired, Unused = %v, %v, want = 0, 0", r.Expired, r.Unused)
+	}
+}
+
+// TestWindow checks that a longer offer window trades off wait time for
+// more offers to choose from, which are held idle until declined.
+func TestWindow(t *testing.T) {
+	o := base
+	o.Governors = 8
+	o.GPUs = 1
+
+	o.Window = 0
+	short, err := Run(o)
+	if err != nil {
+		t.Fatalf("Run() unexpectedly failed: %v", err)
+	}
+
+	o.Window = 10 * time.Second
+	long, err := Run(o)
+	if err != nil {
+		t.Fatalf("Run() unexpectedly failed: %v", err)
+	}
+
+	if long.MeanWait <= short.MeanWait {
+		t.Errorf("MeanWait = %v with a long window, want > %v", long.MeanWait, short.MeanWait)
+	}
+	if long.Waste <= short.Waste {
+		t.Errorf("Waste = %v with a long window, want > %v", long.Waste, short.Waste)
+	}
+}
+
+// TestHold checks that remote offers cannot be committed if the tentative
+// holds expire before the commit reaches the responder.
+func TestHold(t *testing.T) {
+	configs := []struct {
+		name    string
+		hold    time.Duration
+		expired bool
+	}{
+		{name: "Short", hold: base.Window / 2, expired: true},
+		{name: "Long", hold: base.Timeout + base.Window, expired: false},
+	}
+
+	for _, c := range configs {
+		t.Run(c.name, func(t *testing.T) {
+			o := base
+			o.Hold = c.hold
+
+			r, err := Run(o)
+			if err != nil {
+				t.Fatalf("Run() unexpectedly failed: %v", err)
+			}
+			if got := r.Expired > 0; got != c.expired {
+				t.Errorf("Expired = %v, want > 0 = %v", r.Expired, c.expired)
+			}
+			if c.expired && r.Remote != 0 {
+				t.Errorf("Remote = %v, want = 0", r.Remote)
+			}
+		})
+	}
+}
+
+func TestRunInvalid(t *testing.T) {
+	configs := []struct {
+		name string
+		o    func(o O) O
+	}{
+		{name: "Governors", o: func(o O) O { o.Governors = 0; return o }},
+		{name: "GPUs", o: func(o O) O { o.GPUs = -1; return o }},
+		{name: "Interarrival", o: func(o O) O { o.Interarrival = 0; return o }},
+		{name: "Timeout", o: func(o O) O { o.Timeout = 0; return o }},
+		{name: "Hold", o: func(o O) O { o.Hold = 0; return o }},
+		{name: "Window", o: func(o O) O { o.Window = o.Timeout; return o }},
+		{name: "Gang", o: func(o O) O { o.Gang = -1; return o }},
+		{name: "Latency", o: func(o O) O { o.Latency = -time.Second; return o }},
+	}
+
+	for _, c := range configs {
+		t.Run(c.name, func(t *testing.T) {
+			if r, err := Run(c.o(base)); err == nil {
+				t.Errorf("Run() = %v, want an error", r)
+			}
+		})
+	}
+}
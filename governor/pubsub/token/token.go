@@ -0,0 +1,61 @@
+// Package token generates and checks lease tokens.
+//
+// A lease token is the only authority a requestor holds over its leases, and
+// is bound to the requestor peer ID, i.e.
+//
+//	<requestor>:<secret>
+//
+// Governors only accept lease operations for a token from the peer the token
+// is bound to. As lease messages are signed by the sending peer, a peer which
+// guesses or observes another peer's token still cannot use it.
+package token
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+const (
+	sep = ":"
+
+	// secretLength is the number of random bytes in a token.
+	secretLength = 32
+)
+
+// New generates a lease token bound to the input requestor peer ID.
+func New(requestor string) (string, error) {
+	if requestor == "" {
+		return "", fmt.Errorf("cannot bind a token to an empty requestor")
+	}
+
+	b := make([]byte, secretLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("cannot generate token: %w", err)
+	}
+	return requestor + sep + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Check returns an error if the input token is malformed, or is not bound to
+// the input requestor.
+func Check(token string, requestor string) error {
+	owner, secret, ok := strings.Cut(token, sep)
+	if !ok || owner == "" {
+		return fmt.Errorf("malformed token %v", token)
+	}
+	if b, err := base64.RawURLEncoding.DecodeString(secret); err != nil || len(b) != secretLength {
+		return fmt.Errorf("malformed token %v", token)
+	}
+	if owner != requestor {
+		return fmt.Errorf("token %v is not bound to requestor %v", token, requestor)
+	}
+	return nil
+}
+
+// Requestor returns the requestor peer ID the input token claims to be bound
+// to. Requestor does not check that the token is well-formed.
+func Requestor(token string) string {
+	owner, _, _ := strings.Cut(token, sep)
+	return owner
+}
@@ -0,0 +1,57 @@
+package token
+
+import (
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	tok, err := New("some-request-host")
+	if err != nil {
+		t.Fatalf("New() unexpectedly failed: %v", err)
+	}
+
+	configs := []struct {
+		name      string
+		token     string
+		requestor string
+		succ      bool
+	}{
+		{name: "Bound", token: tok, requestor: "some-request-host", succ: true},
+		{name: "OtherRequestor", token: tok, requestor: "other-request-host", succ: false},
+		{name: "Empty", token: "", requestor: "some-request-host", succ: false},
+		{name: "Unbound", token: "some-token", requestor: "some-request-host", succ: false},
+		{name: "ShortSecret", token: "some-request-host:abc", requestor: "some-request-host", succ: false},
+	}
+
+	for _, c := range configs {
+		t.Run(c.name, func(t *testing.T) {
+			err := Check(c.token, c.requestor)
+			if c.succ && err != nil {
+				t.Errorf("Check() unexpectedly failed: %v", err)
+			} else if !c.succ && err == nil {
+				t.Errorf("Check() unexpectedly succeeded")
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(""); err == nil {
+		t.Errorf("New() unexpectedly succeeded")
+	}
+
+	a, err := New("some-request-host")
+	if err != nil {
+		t.Fatalf("New() unexpectedly failed: %v", err)
+	}
+	b, err := New("some-request-host")
+	if err != nil {
+		t.Fatalf("New() unexpectedly failed: %v", err)
+	}
+	if a == b {
+		t.Errorf("New() unexpectedly returned the same token twice: %v", a)
+	}
+	if got, want := Requestor(a), "some-request-host"; got != want {
+		t.Errorf("Requestor() = %v, want = %v", got, want)
+	}
+}
@@ -0,0 +1,101 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"google.golang.org/protobuf/proto"
+
+	gpupb "github.com/kevmo314/fedtorch/governor/api/go/gpu"
+	lpb "github.com/kevmo314/fedtorch/governor/api/go/ledger"
+)
+
+// signer returns the peer ID a lease message claims to have been sent by.
+type signer[T proto.Message] func(pb T) string
+
+// validators maps each lease topic to the validator which authenticates the
+// messages published on the topic.
+var validators = map[string]pubsub.ValidatorEx{
+	LeaseRequestTopic:  validator(func(pb *gpupb.LeaseRequest) string { return pb.GetRequestor() }),
+	LeaseResponseTopic: validator(func(pb *gpupb.LeaseResponse) string { return pb.GetResponder() }),
+	LeaseCommitTopic:   validator(func(pb *gpupb.LeaseCommit) string { return pb.GetRequestor() }),
+	LeaseAckTopic:      validator(func(pb *gpupb.LeaseAck) string { return pb.GetResponder() }),
+	LeaseReleaseTopic:  validator(func(pb *gpupb.LeaseRelease) string { return pb.GetRequestor() }),
+	LeaseRenewTopic:    validator(func(pb *gpupb.LeaseRenew) string { return pb.GetRequestor() }),
+
+	LeasePreemptionTopic: validator(func(pb *gpupb.LeasePreemption) string { return pb.GetResponder() }),
+	LedgerTopic:          validator(func(pb *lpb.Summary) string { return pb.GetAuthor() }),
+}
+
+// validator constructs a libp2p topic validator which drops messages that are
+// unsigned, have an invalid signature, or which were signed by a peer other
+// than the one the message claims to be sent by.
+//
+// The signature is checked here regardless of the signing policy of the
+// underlying PubSub instance, so that a misconfigured governor cannot accept
+// forged messages.
+func validator[T proto.Message](f signer[T]) pubsub.ValidatorEx {
+	return func(ctx context.Context, _ peer.ID, msg *pubsub.Message) pubsub.ValidationResult {
+		from, err := verify(msg)
+		if err != nil {
+			return pubsub.ValidationReject
+		}
+
+		var zero T
+		pb := zero.ProtoReflect().New().Interface().(T)
+		if err := proto.Unmarshal(msg.GetData(), pb); err != nil {
+			return pubsub.ValidationReject
+		}
+
+		if f(pb) != from.String() {
+			return pubsub.ValidationReject
+		}
+		return pubsub.ValidationAccept
+	}
+}
+
+// verify checks the libp2p signature of the input message, and returns the
+// peer ID of the signer.
+func verify(msg *pubsub.Message) (peer.ID, error) {
+	if len(msg.GetSignature()) == 0 {
+		return "", fmt.Errorf("message is unsigned")
+	}
+
+	from, err := peer.IDFromBytes(msg.Message.GetFrom())
+	if err != nil {
+		return "", fmt.Errorf("invalid message author: %w", err)
+	}
+
+	var k crypto.PubKey
+	if msg.GetKey() == nil {
+		k, err = from.ExtractPublicKey()
+	} else {
+		k, err = crypto.UnmarshalPublicKey(msg.GetKey())
+	}
+	if err != nil {
+		return "", fmt.Errorf("cannot extract signing key: %w", err)
+	}
+	if !from.MatchesPublicKey(k) {
+		return "", fmt.Errorf("signing key does not match message author %v", from)
+	}
+
+	m := *msg.Message
+	m.Signature = nil
+	m.Key = nil
+	data, err := m.Marshal()
+	if err != nil {
+		return "", err
+	}
+
+	ok, err := k.Verify(append([]byte(pubsub.SignPrefix), data...), msg.GetSignature())
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("invalid message signature")
+	}
+	return from, nil
+}
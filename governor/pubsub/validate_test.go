@@ -0,0 +1,118 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"google.golang.org/protobuf/proto"
+
+	gpupb "github.com/kevmo314/fedtorch/governor/api/go/gpu"
+	pspb "github.com/libp2p/go-libp2p-pubsub/pb"
+)
+
+// sign constructs a libp2p pubsub message carrying the input payload, signed
+// by the input key.
+func sign(t *testing.T, k crypto.PrivKey, payload proto.Message) *pubsub.Message {
+	t.Helper()
+
+	id, err := peer.IDFromPrivateKey(k)
+	if err != nil {
+		t.Fatalf("IDFromPrivateKey() unexpectedly failed: %v", err)
+	}
+	data, err := proto.Marshal(payload)
+	if err != nil {
+		t.Fatalf("Marshal() unexpectedly failed: %v", err)
+	}
+
+	topic := LeaseResponseTopic
+	m := &pspb.Message{
+		From:  []byte(id),
+		Data:  data,
+		Seqno: []byte{1},
+		Topic: &topic,
+	}
+	b, err := m.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal() unexpectedly failed: %v", err)
+	}
+	if m.Signature, err = k.Sign(append([]byte(pubsub.SignPrefix), b...)); err != nil {
+		t.Fatalf("Sign() unexpectedly failed: %v", err)
+	}
+	return &pubsub.Message{Message: m}
+}
+
+func TestValidator(t *testing.T) {
+	k, _, err := crypto.GenerateEd25519Key(nil)
+	if err != nil {
+		t.Fatalf("GenerateEd25519Key() unexpectedly failed: %v", err)
+	}
+	id, err := peer.IDFromPrivateKey(k)
+	if err != nil {
+		t.Fatalf("IDFromPrivateKey() unexpectedly failed: %v", err)
+	}
+
+	configs := []struct {
+		name string
+		msg  func() *pubsub.Message
+		want pubsub.ValidationResult
+	}{
+		{
+			name: "Signed",
+			msg: func() *pubsub.Message {
+				return sign(t, k, &gpupb.LeaseResponse{
+					Requestor: "some-request-host",
+					Responder: id.String(),
+				})
+			},
+			want: pubsub.ValidationAccept,
+		},
+		{
+			name: "Forged",
+			msg: func() *pubsub.Message {
+				return sign(t, k, &gpupb.LeaseResponse{
+					Requestor: "some-request-host",
+					Responder: "some-other-host",
+				})
+			},
+			want: pubsub.ValidationReject,
+		},
+		{
+			name: "Unsigned",
+			msg: func() *pubsub.Message {
+				m := sign(t, k, &gpupb.LeaseResponse{
+					Responder: id.String(),
+				})
+				m.Signature = nil
+				return m
+			},
+			want: pubsub.ValidationReject,
+		},
+		{
+			name: "Tampered",
+			msg: func() *pubsub.Message {
+				m := sign(t, k, &gpupb.LeaseResponse{
+					Responder: id.String(),
+				})
+				data, _ := proto.Marshal(&gpupb.LeaseResponse{
+					Requestor: "some-request-host",
+					Responder: id.String(),
+				})
+				m.Data = data
+				return m
+			},
+			want: pubsub.ValidationReject,
+		},
+	}
+
+	v := validators[LeaseResponseTopic]
+	for _, c := range configs {
+		t.Run(c.name, func(t *testing.T) {
+			if got := v(context.Background(), id, c.msg()); got != c.want {
+				t.Errorf("validator() = %v, want = %v", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,231 @@
+// Package gpu tracks the local GPU inventory of a governor.
+package gpu
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/kevmo314/fedtorch/governor/metadata/telemetry"
+	"github.com/kevmo314/fedtorch/governor/p2p"
+	"github.com/kevmo314/fedtorch/governor/pubsub/journal"
+	"github.com/kevmo314/fedtorch/governor/pubsub/local"
+
+	gpupb "github.com/kevmo314/fedtorch/governor/api/go/gpu"
+)
+
+// L is the list of GPUs attached to the local host, along with the tasks and
+// leases which currently hold each device.
+//
+// GPUs allocated to local tasks and GPUs leased or reserved by remote
+// governors are drawn from the same local.Allocator, so that the inventory
+// may back a pubsub.Allocator.
+type L struct {
+	p2p *p2p.Store
+
+	leases *local.Allocator
+
+	// l serializes announcements, so that a stale announcement does not
+	// overwrite a more recent one.
+	l sync.Mutex
+
+	// freed forwards the signals of leases.Freed once the freed capacity
+	// has been announced.
+	freed chan struct{}
+
+	done chan struct{}
+	once sync.Once
+	wg   sync.WaitGroup
+}
+
+// New constructs a GPU inventory over the input devices, e.g. as returned by
+// a metadata/gpu.Discoverer.
+//
+// All devices are initially free, and are immediately announced to the
+// network. The input p2p store does not need to have been started yet, as
+// the store will only serve announcements after it starts.
+func New(s *p2p.Store, gpus []*gpupb.GPU) *L {
+	l := &L{
+		p2p:    s,
+		leases: local.New(gpus, 0),
+		freed:  make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	l.announce()
+
+	l.wg.Add(1)
+	go l.watch()
+	return l
+}
+
+// watch announces the free capacity whenever a lease is released, aborted or
+// expires.
+func (l *L) watch() {
+	defer l.wg.Done()
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-l.leases.Freed():
+		}
+		l.announce()
+		select {
+		case l.freed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// AllocateGPU reserves up to n free GPUs for the input task. Fewer than n GPUs
+// are returned if the local host does not have enough free capacity; the
+// caller is responsible for finding the remainder elsewhere.
+func (l *L) AllocateGPU(task string, n int) []*gpupb.GPU {
+	var gpus []*gpupb.GPU
+	for _, m := range l.leases.Claim(task, n) {
+		gpus = append(gpus, m.GetGpu())
+	}
+
+	if len(gpus) > 0 {
+		l.announce()
+	}
+	return gpus
+}
+
+// Restore replays the input lease journal, restoring the unexpired leases
+// lent to remote governors, and records all subsequent lease changes to the
+// journal. GPUs allocated to local tasks are not restored. Restore should be
+// called once on startup, before any GPUs are allocated.
+func (l *L) Restore(j *journal.J) error {
+	if err := l.leases.Restore(j); err != nil {
+		return err
+	}
+	l.announce()
+	return nil
+}
+
+// Monitor attaches the current telemetry of the local GPUs to subsequent
+// allocations, and excludes unhealthy GPUs from being allocated. Monitor
+// should be called once on startup, before any GPUs are allocated.
+func (l *L) Monitor(m *telemetry.M) { l.leases.Monitor(m) }
+
+// Preemptible allows lease requests issued by the input owner, i.e. the local
+// governor, to preempt lower priority leases lent to remote governors, with
+// the input notice period. GPUs allocated to local tasks are never preempted.
+// Preemptible should be called once on startup, before any GPUs are
+// allocated.
+func (l *L) Preemptible(owner string, notice time.Duration) { l.leases.Preemptible(owner, notice) }
+
+// Preemptions returns the leases which have been preempted. The channel is
+// nil unless Preemptible was called.
+func (l *L) Preemptions() <-chan *gpupb.Lease { return l.leases.Preemptions() }
+
+// FreeGPU returns all GPUs held by the input task to the free pool, and
+// returns the number of devices released.
+func (l *L) FreeGPU(task string) int {
+	var n int
+	for _, m := range l.leases.Leases(task) {
+		if l.leases.Release(m) == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// Free returns the input GPUs to the free pool if they are held by the input
+// task, and returns the number of devices released. Other GPUs held by the
+// task are unaffected.
+func (l *L) Free(task string, gpus []*gpupb.GPU) int {
+	var n int
+	for _, g := range gpus {
+		if l.leases.Release(&gpupb.Lease{Gpu: g, Token: task}) == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// Lease leases the free GPU which best fits the input lease request
+// constraints, using the lease token as the task ID. Fractional requests may
+// share a GPU with other fractional leases, but not with local tasks. The GPU
+// is returned to the free pool once the lease expires.
+func (l *L) Lease(ctx context.Context, req *gpupb.LeaseRequest) (*gpupb.LeaseResponse, error) {
+	resp, err := l.leases.Lease(ctx, req)
+	if err == nil {
+		l.announce()
+	}
+	return resp, err
+}
+
+// Reserve places a tentative hold on up to n free GPUs for a remote gang
+// request. See local.Allocator.Reserve.
+func (l *L) Reserve(req *gpupb.LeaseRequest, n int, hold time.Duration) ([]*gpupb.Lease, error) {
+	leases, err := l.leases.Reserve(req, n, hold)
+	if err == nil {
+		l.announce()
+	}
+	return leases, err
+}
+
+// Commit converts the tentative holds on the input devices into leases of
+// duration d. See local.Allocator.Commit.
+func (l *L) Commit(token string, ids []int32, d time.Duration) ([]*gpupb.Lease, error) {
+	return l.leases.Commit(token, ids, d)
+}
+
+// Abort releases all tentative holds under the input token, and returns the
+// number of devices released.
+func (l *L) Abort(token string) int { return l.leases.Abort(token) }
+
+// Release returns a leased GPU to the free pool before the lease expires. The
+// lease token must match the token of a lease currently held on the GPU.
+func (l *L) Release(lease *gpupb.Lease) error { return l.leases.Release(lease) }
+
+// Renew extends a lease by duration d from now. The lease token must match the
+// token of a lease currently held on the GPU. GPUs allocated to local tasks
+// cannot be renewed.
+func (l *L) Renew(lease *gpupb.Lease, d time.Duration) (*gpupb.Lease, error) {
+	return l.leases.Renew(lease, d)
+}
+
+// Freed returns a channel which is signalled whenever a GPU may have been
+// freed. Signals are coalesced if the channel is not drained.
+func (l *L) Freed() <-chan struct{} { return l.freed }
+
+// Expirations returns the expiration times of the current leases, in
+// ascending order. GPUs allocated to local tasks do not expire.
+func (l *L) Expirations() []time.Time { return l.leases.Expirations() }
+
+// Close stops all pending lease expirations. Leased GPUs remain allocated.
+func (l *L) Close() {
+	l.once.Do(func() {
+		close(l.done)
+		l.wg.Wait()
+		l.leases.Close()
+	})
+}
+
+// Task returns the task which currently holds the input device, or false if
+// the device is free. If the device is shared by several fractional leases,
+// the first task in sorted order is returned.
+func (l *L) Task(id int32) (string, bool) {
+	tasks := l.leases.Holders(id)
+	if len(tasks) == 0 {
+		return "", false
+	}
+	return tasks[0], true
+}
+
+// Available returns the list of currently unallocated, healthy GPUs.
+func (l *L) Available() []*gpupb.GPU { return l.leases.Unused() }
+
+// announce advertises the current free capacity to the network.
+func (l *L) announce() {
+	if l.p2p == nil {
+		return
+	}
+
+	l.l.Lock()
+	defer l.l.Unlock()
+
+	l.p2p.Announce(l.leases.Unused())
+}
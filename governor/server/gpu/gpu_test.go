@@ -0,0 +1,415 @@
+package gpu
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/kevmo314/fedtorch/governor/p2p"
+	"github.com/kevmo314/fedtorch/governor/pubsub"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	gpupb "github.com/kevmo314/fedtorch/governor/api/go/gpu"
+	ps "github.com/libp2p/go-libp2p-pubsub"
+	dpb "google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestAllocateGPU(t *testing.T) {
+	configs := []struct {
+		name string
+		gpus []*gpupb.GPU
+		n    int
+		want int
+	}{
+		{
+			name: "Empty",
+			gpus: nil,
+			n:    1,
+			want: 0,
+		},
+		{
+			name: "Partial",
+			gpus: []*gpupb.GPU{
+				&gpupb.GPU{
+					Id: 100,
+				},
+			},
+			n:    2,
+			want: 1,
+		},
+		{
+			name: "Full",
+			gpus: []*gpupb.GPU{
+				&gpupb.GPU{
+					Id: 100,
+				},
+				&gpupb.GPU{
+					Id: 101,
+				},
+			},
+			n:    2,
+			want: 2,
+		},
+	}
+
+	for _, c := range configs {
+		t.Run(c.name, func(t *testing.T) {
+			l := New(nil, c.gpus)
+			if got := len(l.AllocateGPU("some-task", c.n)); got != c.want {
+				t.Errorf("len(AllocateGPU()) = %v, want = %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestFreeGPU(t *testing.T) {
+	l := New(nil, []*gpupb.GPU{
+		&gpupb.GPU{
+			Id: 100,
+		},
+		&gpupb.GPU{
+			Id: 101,
+		},
+	})
+
+	l.AllocateGPU("some-task", 1)
+	l.AllocateGPU("other-task", 1)
+
+	if task, ok := l.Task(100); !ok || task != "some-task" {
+		t.Errorf("Task() = %v, %v, want = %v, %v", task, ok, "some-task", true)
+	}
+	if got := l.AllocateGPU("some-task", 1); len(got) != 0 {
+		t.Errorf("AllocateGPU() unexpectedly succeeded: %v", got)
+	}
+
+	if got := l.FreeGPU("some-task"); got != 1 {
+		t.Errorf("FreeGPU() = %v, want = 1", got)
+	}
+	if _, ok := l.Task(100); ok {
+		t.Errorf("Task() unexpectedly found an allocation")
+	}
+	if got := len(l.Available()); got != 1 {
+		t.Errorf("len(Available()) = %v, want = 1", got)
+	}
+}
+
+func TestFree(t *testing.T) {
+	l := New(nil, []*gpupb.GPU{
+		&gpupb.GPU{
+			Id: 100,
+		},
+		&gpupb.GPU{
+			Id: 101,
+		},
+	})
+
+	a := l.AllocateGPU("some-task", 1)
+	b := l.AllocateGPU("some-task", 1)
+
+	if got := l.Free("other-task", b); got != 0 {
+		t.Errorf("Free() = %v, want = 0", got)
+	}
+	if got := l.Free("some-task", b); got != 1 {
+		t.Errorf("Free() = %v, want = 1", got)
+	}
+	if task, ok := l.Task(a[0].GetId()); !ok || task != "some-task" {
+		t.Errorf("Task() = %v, %v, want = %v, %v", task, ok, "some-task", true)
+	}
+	if _, ok := l.Task(b[0].GetId()); ok {
+		t.Errorf("Task() unexpectedly found an allocation")
+	}
+}
+
+func TestLease(t *testing.T) {
+	l := New(nil, []*gpupb.GPU{
+		&gpupb.GPU{
+			Id: 100,
+		},
+	})
+
+	resp, err := l.Lease(context.Background(), &gpupb.LeaseRequest{
+		Requestor: "some-request-host",
+		Token:     "some-token",
+		Duration:  dpb.New(time.Second),
+	})
+	if err != nil {
+		t.Fatalf("Lease() unexpectedly failed: %v", err)
+	}
+	if task, ok := l.Task(100); !ok || task != "some-token" {
+		t.Errorf("Task() = %v, %v, want = %v, %v", task, ok, "some-token", true)
+	}
+
+	if resp, err := l.Lease(context.Background(), &gpupb.LeaseRequest{
+		Token:    "other-token",
+		Duration: dpb.New(time.Second),
+	}); err == nil {
+		t.Errorf("Lease() unexpectedly succeeded: %v", resp)
+	}
+
+	time.Sleep(time.Until(resp.GetLease().GetExpiration().AsTime()) + 100*time.Millisecond)
+
+	if _, ok := l.Task(100); ok {
+		t.Errorf("Task() unexpectedly found an expired lease")
+	}
+}
+
+func TestLeaseFraction(t *testing.T) {
+	configs := []struct {
+		name      string
+		fractions []float64
+		succ      bool
+	}{
+		{name: "Shared", fractions: []float64{0.5, 0.5}, succ: true},
+		{name: "Oversubscribed", fractions: []float64{0.5, 0.75}, succ: false},
+		{name: "Exclusive", fractions: []float64{0.5, 0}, succ: false},
+	}
+
+	for _, c := range configs {
+		t.Run(c.name, func(t *testing.T) {
+			l := New(nil, []*gpupb.GPU{
+				&gpupb.GPU{
+					Id: 100,
+				},
+			})
+			defer l.Close()
+
+			var err error
+			for i, f := range c.fractions {
+				_, err = l.Lease(context.Background(), &gpupb.LeaseRequest{
+					Token:    fmt.Sprintf("some-token-%v", i),
+					Duration: dpb.New(time.Hour),
+					Fraction: f,
+				})
+				if err != nil {
+					break
+				}
+			}
+			if got := err == nil; got != c.succ {
+				t.Errorf("Lease() = %v, want success = %v", err, c.succ)
+			}
+			if got := len(l.Available()); got != 0 {
+				t.Errorf("len(Available()) = %v, want = 0", got)
+			}
+		})
+	}
+}
+
+// TestPreempt checks that the local governor may preempt GPUs lent to remote
+// governors, but not GPUs allocated to local tasks.
+func TestPreempt(t *testing.T) {
+	configs := []struct {
+		name  string
+		local bool
+		succ  bool
+	}{
+		{name: "Remote", local: false, succ: true},
+		{name: "Local", local: true, succ: false},
+	}
+
+	for _, c := range configs {
+		t.Run(c.name, func(t *testing.T) {
+			l := New(nil, []*gpupb.GPU{
+				&gpupb.GPU{
+					Id: 100,
+				},
+			})
+			l.Preemptible("some-owner", 0)
+			defer l.Close()
+
+			if c.local {
+				l.AllocateGPU("some-task", 1)
+			} else if _, err := l.Lease(context.Background(), &gpupb.LeaseRequest{
+				Requestor: "some-request-host",
+				Token:     "some-request-host:token",
+				Duration:  dpb.New(time.Hour),
+			}); err != nil {
+				t.Fatalf("Lease() unexpectedly failed: %v", err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			resp, err := l.Lease(ctx, &gpupb.LeaseRequest{
+				Requestor: "some-owner",
+				Token:     "some-owner:token",
+				Duration:  dpb.New(time.Hour),
+				Priority:  1,
+			})
+			if !c.succ {
+				if err == nil {
+					t.Errorf("Lease() unexpectedly succeeded: %v", resp)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Lease() unexpectedly failed: %v", err)
+			}
+			select {
+			case n := <-l.Preemptions():
+				if got := n.GetToken(); got != "some-request-host:token" {
+					t.Errorf("GetToken() = %v, want = %v", got, "some-request-host:token")
+				}
+			default:
+				t.Errorf("Preemptions() unexpectedly empty")
+			}
+		})
+	}
+}
+
+// TestAnnounce checks that GPUs announced before the p2p store starts are
+// visible to peers once it does.
+func TestAnnounce(t *testing.T) {
+	a := p2p.New(p2p.O{Address: "127.0.0.1"})
+	l := New(a, []*gpupb.GPU{
+		&gpupb.GPU{
+			Id: 100,
+		},
+		&gpupb.GPU{
+			Id: 101,
+		},
+	})
+	l.AllocateGPU("some-task", 1)
+
+	b := p2p.New(p2p.O{Address: "127.0.0.1"})
+	for _, s := range []*p2p.Store{a, b} {
+		if err := s.Start(); err != nil {
+			t.Fatalf("Start() unexpectedly failed: %v", err)
+		}
+		defer s.Stop()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	p, err := b.Connect(ctx, fmt.Sprintf("127.0.0.1:%v", a.Port()))
+	if err != nil {
+		t.Fatalf("Connect() unexpectedly failed: %v", err)
+	}
+	if got := p.GetGpus(); len(got) != 1 || got[0].GetId() != 101 {
+		t.Errorf("GetGpus() = %v, want a single free GPU 101", got)
+	}
+}
+
+func TestRelease(t *testing.T) {
+	configs := []struct {
+		name  string
+		token string
+		want  bool
+	}{
+		{name: "Release", token: "some-token", want: true},
+		{name: "WrongToken", token: "other-token", want: false},
+	}
+
+	for _, c := range configs {
+		t.Run(c.name, func(t *testing.T) {
+			l := New(nil, []*gpupb.GPU{
+				&gpupb.GPU{
+					Id: 100,
+				},
+			})
+			resp, err := l.Lease(context.Background(), &gpupb.LeaseRequest{
+				Token:    "some-token",
+				Duration: dpb.New(time.Hour),
+			})
+			if err != nil {
+				t.Fatalf("Lease() unexpectedly failed: %v", err)
+			}
+
+			lease := resp.GetLease()
+			lease.Token = c.token
+			err = l.Release(lease)
+			if got := err == nil; got != c.want {
+				t.Errorf("Release() = %v, want success = %v", err, c.want)
+			}
+			if _, ok := l.Task(100); ok == c.want {
+				t.Errorf("Task() = _, %v, want = _, %v", ok, !c.want)
+			}
+		})
+	}
+}
+
+func TestRenew(t *testing.T) {
+	l := New(nil, []*gpupb.GPU{
+		&gpupb.GPU{
+			Id: 100,
+		},
+	})
+
+	resp, err := l.Lease(context.Background(), &gpupb.LeaseRequest{
+		Token:    "some-token",
+		Duration: dpb.New(time.Second),
+	})
+	if err != nil {
+		t.Fatalf("Lease() unexpectedly failed: %v", err)
+	}
+	if _, err := l.Renew(resp.GetLease(), time.Hour); err != nil {
+		t.Fatalf("Renew() unexpectedly failed: %v", err)
+	}
+
+	// The original expiration should no longer free the GPU.
+	time.Sleep(time.Until(resp.GetLease().GetExpiration().AsTime()) + 100*time.Millisecond)
+	if _, ok := l.Task(100); !ok {
+		t.Errorf("Task() unexpectedly found the renewed lease expired")
+	}
+}
+
+// TestAllocator checks that the inventory may back a pubsub.Allocator which
+// lends local GPUs to remote governors.
+func TestAllocator(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	l := New(nil, []*gpupb.GPU{
+		&gpupb.GPU{
+			Id: 100,
+		},
+	})
+	defer l.Close()
+
+	var hosts []host.Host
+	var allocators []*pubsub.Allocator
+	for _, inventory := range []*L{l, New(nil, nil)} {
+		h, err := p2p.NewHost("/ip4/127.0.0.1/tcp/0")
+		if err != nil {
+			t.Fatalf("NewHost() unexpectedly failed: %v", err)
+		}
+		defer h.Close()
+
+		g, err := ps.NewGossipSub(ctx, h)
+		if err != nil {
+			t.Fatalf("NewGossipSub() unexpectedly failed: %v", err)
+		}
+		a := pubsub.New(ctx, pubsub.O{
+			PubSub: g,
+			PeerID: h.ID(),
+			Local:  inventory,
+			Window: time.Second,
+		}, time.Minute)
+		defer a.Close()
+
+		hosts = append(hosts, h)
+		allocators = append(allocators, a)
+	}
+
+	if err := hosts[1].Connect(ctx, peer.AddrInfo{ID: hosts[0].ID(), Addrs: hosts[0].Addrs()}); err != nil {
+		t.Fatalf("Connect() unexpectedly failed: %v", err)
+	}
+
+	// Wait for the subscriptions to propagate.
+	time.Sleep(time.Second)
+
+	resp, err := allocators[1].Lease(ctx, &gpupb.LeaseRequest{
+		Duration: dpb.New(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("Lease() unexpectedly failed: %v", err)
+	}
+	if got := resp.GetLease().GetGpu().GetId(); got != 100 {
+		t.Errorf("GetId() = %v, want = 100", got)
+	}
+	if task, ok := l.Task(100); !ok || task != resp.GetLease().GetToken() {
+		t.Errorf("Task() = %v, %v, want = %v, %v", task, ok, resp.GetLease().GetToken(), true)
+	}
+}
@@ -2,37 +2,195 @@ package server
 
 import (
 	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
 
+	"github.com/kevmo314/fedtorch/governor/metadata/telemetry"
 	"github.com/kevmo314/fedtorch/governor/p2p"
+	"github.com/kevmo314/fedtorch/governor/pkg/hypervisor"
+	"github.com/kevmo314/fedtorch/governor/pubsub"
+	"github.com/kevmo314/fedtorch/governor/pubsub/journal"
 	"github.com/kevmo314/fedtorch/governor/server/gpu"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	gpb "github.com/kevmo314/fedtorch/governor/api/go/api"
+	gpupb "github.com/kevmo314/fedtorch/governor/api/go/gpu"
+	mgpu "github.com/kevmo314/fedtorch/governor/metadata/gpu"
+	ps "github.com/libp2p/go-libp2p-pubsub"
+	dpb "google.golang.org/protobuf/types/known/durationpb"
 )
 
+const (
+	defaultTimeout  = time.Minute
+	defaultDuration = time.Hour
+
+	// window is how long the market collects offers for a remote lease,
+	// and must be shorter than the request timeout.
+	window = 5 * time.Second
+
+	// keySuffix is appended to the journal path to form the path of the
+	// host identity key.
+	keySuffix = ".key"
+)
+
+// leaser requests GPU leases from the network.
+type leaser interface {
+	LeaseN(ctx context.Context, req *gpupb.LeaseRequest) ([]*gpupb.Lease, error)
+	Preemptions() <-chan *gpupb.LeasePreemption
+	Close()
+}
+
+// device identifies a GPU across hosts.
+type device struct {
+	host string
+	id   int32
+}
+
 type S struct {
+	gpb.UnimplementedGovernorServer
+
+	o   O
 	p2p *p2p.Store
 
 	gpus *gpu.L
+
+	// journal persists the leases lent out of gpus. May be nil.
+	journal *journal.J
+
+	// monitor samples the local GPU telemetry. May be nil.
+	monitor *telemetry.M
+
+	// market is the pubsub lease market used to fill local capacity gaps.
+	// The market is only available after Start is called.
+	market leaser
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	l sync.Mutex
+
+	// hypervisors maps a GPU to the hypervisor running a task on the GPU,
+	// which is preempted along with the lease on the GPU.
+	hypervisors map[device]*hypervisor.Hypervisor
+
+	grpc *grpc.Server
 }
 
 type O struct {
 	Address string
-	Port    int
+
+	// Port is the port of the DHT node.
+	Port int
+
+	// GRPCPort is the port of the governor gRPC service. Peers learn of
+	// this port through the identity record served on the DHT port, so
+	// the two do not need to be related.
+	GRPCPort int
+
+	// Timeout is how long InternalAllocateGPU will wait for remote
+	// governors to fill a local capacity gap. Must be longer than the 5s
+	// offer window. Defaults to one minute.
+	Timeout time.Duration
+
+	// Discoverer lists the local GPUs. Defaults to the default backend
+	// of metadata/gpu.New.
+	Discoverer mgpu.Discoverer
+
+	// Sampler samples the local GPU telemetry, which is attached to
+	// leases and used to avoid leasing unhealthy GPUs. If nil, telemetry
+	// is disabled.
+	Sampler telemetry.Sampler
+
+	// PreemptionNotice is how long remote governors have to vacate local
+	// GPUs preempted by the local governor. If set, requests for local
+	// tasks may preempt the GPUs lent to remote governors at a lower
+	// priority than Priority. GPUs allocated to local tasks are never
+	// preempted.
+	PreemptionNotice time.Duration
+
+	// Priority is the priority of the leases requested for local tasks.
+	Priority int32
+
+	// Journal is the path of the lease journal, so that GPUs lent to
+	// remote governors are not handed out again after a restart. The
+	// host identity key is persisted alongside the journal, so that the
+	// governor keeps its peer ID, and with it ownership of its leases. If
+	// empty, leases are not persisted, and a fresh peer ID is generated
+	// on every start.
+	Journal string
 }
 
-func New(o O) *S {
-	// N.B.: We will need two ports, one for DHT and one for gRPC. Need to
-	// think of a way to map these.
+func New(o O) (*S, error) {
+	if o.Timeout == 0 {
+		o.Timeout = defaultTimeout
+	}
+	if o.Timeout <= window {
+		return nil, fmt.Errorf("timeout %v must be longer than the offer window %v", o.Timeout, window)
+	}
+	if o.Discoverer == nil {
+		d, err := mgpu.New(mgpu.O{})
+		if err != nil {
+			return nil, err
+		}
+		o.Discoverer = d
+	}
+
+	gpus, err := o.Discoverer.Discover(o.Address)
+	if err != nil {
+		return nil, fmt.Errorf("cannot discover local GPUs: %w", err)
+	}
+
+	// The journal records the leases made for local tasks under the
+	// local peer ID, which must therefore survive a restart along with
+	// the journal.
+	var key crypto.PrivKey
+	if o.Journal != "" {
+		if key, err = p2p.LoadKey(o.Journal + keySuffix); err != nil {
+			return nil, err
+		}
+	}
+
 	dht := p2p.New(p2p.O{
 		Address: o.Address,
 		Port:    o.Port,
+		Key:     key,
 	})
-	return &S{
+	s := &S{
+		o:   o,
 		p2p: dht,
-		// N.B.: This will break, as gpu.New will attempt to call
-		// p2p.Announce. Need to be clearer on when p2p.Store starts.
-		gpus: gpu.New(dht),
+		// gpu.New announces the local GPUs immediately; p2p.Store
+		// buffers the announcement until Start is called.
+		gpus:        gpu.New(dht, gpus),
+		grpc:        grpc.NewServer(),
+		hypervisors: make(map[device]*hypervisor.Hypervisor),
 	}
+	gpb.RegisterGovernorServer(s.grpc, s)
+
+	if o.Journal != "" {
+		j, err := journal.Open(journal.O{Path: o.Journal})
+		if err != nil {
+			s.gpus.Close()
+			return nil, err
+		}
+		if err := s.gpus.Restore(j); err != nil {
+			s.gpus.Close()
+			j.Close()
+			return nil, fmt.Errorf("cannot restore lease journal %v: %w", o.Journal, err)
+		}
+		s.journal = j
+	}
+
+	if o.Sampler != nil {
+		s.monitor = telemetry.New(telemetry.O{Sampler: o.Sampler})
+		s.gpus.Monitor(s.monitor)
+	}
+
+	return s, nil
 }
 
 // TODO(minkezhang): Use a reservation pipeline architecture instead.
@@ -42,19 +200,168 @@ func New(o O) *S {
 //    DeviceID int
 //  }
 
-func (s *S) InternalAllocateGPU(ctx context.Context, req *gpb.InternalAllocateGPURequest) *gpb.InternalAllocateGPUResponse {
-	resp := &gpb.InternalAllocateGPUResponse{}
-	resp.Gpus = append(resp.Gpus, s.gpus.AllocateGPU(int(req.GetCapacity()))...)
+// InternalAllocateGPU allocates the requested capacity, preferring local GPUs
+// and leasing the remainder from remote governors. The allocation is
+// all-or-nothing -- if the full capacity cannot be met before the deadline,
+// all partial allocations are rolled back.
+func (s *S) InternalAllocateGPU(ctx context.Context, req *gpb.InternalAllocateGPURequest) (*gpb.InternalAllocateGPUResponse, error) {
+	n := int(req.GetCapacity())
 
-	// TODO(minkezhang): Call DHT to fulfil gap.
-	return resp
+	gpus := s.gpus.AllocateGPU(req.GetTaskId(), n)
+	if gap := n - len(gpus); gap > 0 {
+		leases, err := s.lease(ctx, req.GetDuration(), gap)
+		if err != nil {
+			// The task may already hold GPUs from earlier calls,
+			// which must not be rolled back.
+			s.gpus.Free(req.GetTaskId(), gpus)
+			return nil, status.Errorf(codes.ResourceExhausted, "cannot allocate %v GPUs: %v", n, err)
+		}
+		for _, l := range leases {
+			gpus = append(gpus, l.GetGpu())
+		}
+	}
+
+	return &gpb.InternalAllocateGPUResponse{
+		Gpus: gpus,
+	}, nil
+}
+
+// lease requests n GPUs from the lease market as a single gang, which is
+// either leased in full, or not at all.
+func (s *S) lease(ctx context.Context, d *dpb.Duration, n int) ([]*gpupb.Lease, error) {
+	if s.market == nil {
+		return nil, fmt.Errorf("lease market is unavailable")
+	}
+	if d == nil {
+		d = dpb.New(defaultDuration)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.o.Timeout)
+	defer cancel()
+
+	return s.market.LeaseN(ctx, &gpupb.LeaseRequest{
+		Duration: d,
+		Priority: s.o.Priority,
+		Count:    int32(n),
+	})
+}
+
+// Attach registers the hypervisor running a task on the input GPU, e.g. a GPU
+// returned by InternalAllocateGPU, so that the container is asked to
+// checkpoint and exit if the lease on the GPU is preempted. Attach returns a
+// function which unregisters the hypervisor, e.g. once the container exits.
+func (s *S) Attach(g *gpupb.GPU, h *hypervisor.Hypervisor) func() {
+	k := device{host: g.GetHost(), id: g.GetId()}
+
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	s.hypervisors[k] = h
+	return func() {
+		s.l.Lock()
+		defer s.l.Unlock()
+
+		if s.hypervisors[k] == h {
+			delete(s.hypervisors, k)
+		}
+	}
+}
+
+// preempter preempts the hypervisors attached to GPUs whose leases have been
+// preempted by their responders, until ctx is done. The containers are killed
+// if they are still running at the lease expiration.
+func (s *S) preempter(ctx context.Context, ch <-chan *gpupb.LeasePreemption) {
+	for {
+		var n *gpupb.LeasePreemption
+		select {
+		case <-ctx.Done():
+			return
+		case n = <-ch:
+		}
+
+		g := n.GetLease().GetGpu()
+		s.l.Lock()
+		h, ok := s.hypervisors[device{host: g.GetHost(), id: g.GetId()}]
+		s.l.Unlock()
+
+		if ok {
+			// A container which has not been started or has
+			// already exited does not need to be preempted.
+			h.Preempt(n.GetLease().GetExpiration().AsTime())
+		}
+	}
 }
 
+// Start binds the gRPC listener and starts the p2p store. The gRPC address is
+// advertised to peers as part of the governor identity record.
 func (s *S) Start() error {
+	lis, err := net.Listen("tcp", net.JoinHostPort(s.o.Address, strconv.Itoa(s.o.GRPCPort)))
+	if err != nil {
+		return fmt.Errorf("cannot listen for gRPC requests: %w", err)
+	}
+	s.p2p.AnnounceAddr(lis.Addr().String())
+
 	if err := s.p2p.Start(); err != nil {
+		lis.Close()
 		return err
 	}
+
+	if s.o.PreemptionNotice > 0 {
+		s.gpus.Preemptible(s.p2p.Host().ID().String(), s.o.PreemptionNotice)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	g, err := ps.NewGossipSub(ctx, s.p2p.Host())
+	if err != nil {
+		cancel()
+		s.p2p.Stop()
+		lis.Close()
+		return fmt.Errorf("cannot start gossipsub: %w", err)
+	}
+	s.market = pubsub.New(ctx, pubsub.O{
+		GovernorAddress: lis.Addr().String(),
+		PubSub:          g,
+		PeerID:          s.p2p.Host().ID(),
+		Local:           s.gpus,
+		Window:          window,
+	}, s.o.Timeout)
+	s.cancel = cancel
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.preempter(ctx, s.market.Preemptions())
+	}()
+
+	if s.monitor != nil {
+		// A failed initial sample only means leases will not carry
+		// telemetry until the next successful sample.
+		s.monitor.Start()
+	}
+
+	go s.Serve(lis)
 	return nil
 }
 
-func (s *S) Stop() { s.p2p.Stop() }
+// Serve accepts gRPC requests on the input listener. Serve blocks until Stop
+// is called.
+func (s *S) Serve(lis net.Listener) error { return s.grpc.Serve(lis) }
+
+func (s *S) Stop() {
+	s.grpc.GracefulStop()
+	if s.monitor != nil {
+		s.monitor.Stop()
+	}
+	if s.market != nil {
+		s.market.Close()
+	}
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.wg.Wait()
+	s.gpus.Close()
+	if s.journal != nil {
+		s.journal.Close()
+	}
+	s.p2p.Stop()
+}
@@ -0,0 +1,406 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kevmo314/fedtorch/governor/client"
+	"github.com/kevmo314/fedtorch/governor/pkg/hypervisor"
+	"github.com/kevmo314/fedtorch/governor/server/gpu"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+
+	gpb "github.com/kevmo314/fedtorch/governor/api/go/api"
+	gpupb "github.com/kevmo314/fedtorch/governor/api/go/gpu"
+	mgpu "github.com/kevmo314/fedtorch/governor/metadata/gpu"
+	dpb "google.golang.org/protobuf/types/known/durationpb"
+	tpb "google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// market is a fake lease market which hands out a fixed set of remote GPUs.
+type market struct {
+	l    sync.Mutex
+	gpus []*gpupb.GPU
+
+	// block causes all lease requests to hang.
+	block bool
+}
+
+func (m *market) LeaseN(ctx context.Context, req *gpupb.LeaseRequest) ([]*gpupb.Lease, error) {
+	if m.block {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	n := int(req.GetCount())
+	if len(m.gpus) < n {
+		return nil, fmt.Errorf("could not find %v free GPUs on the network", n)
+	}
+	var leases []*gpupb.Lease
+	for _, g := range m.gpus[:n] {
+		leases = append(leases, &gpupb.Lease{Gpu: g})
+	}
+	m.gpus = m.gpus[n:]
+	return leases, nil
+}
+
+func (m *market) Preemptions() <-chan *gpupb.LeasePreemption { return nil }
+
+func (m *market) Close() {}
+
+func serve(t *testing.T, s *S) *client.C {
+	t.Helper()
+
+	s.grpc = grpc.NewServer()
+	gpb.RegisterGovernorServer(s.grpc, s)
+
+	lis := bufconn.Listen(1 << 20)
+	go s.Serve(lis)
+	t.Cleanup(s.grpc.Stop)
+
+	c, err := client.New(context.Background(), client.O{
+		Address: "bufnet",
+		DialOptions: []grpc.DialOption{
+			grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+				return lis.DialContext(ctx)
+			}),
+		},
+	})
+	if err != nil {
+		t.Fatalf("New() unexpectedly failed: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+
+	return c
+}
+
+func TestInternalAllocateGPU(t *testing.T) {
+	configs := []struct {
+		name     string
+		local    []*gpupb.GPU
+		market   *market
+		capacity int
+		want     int
+		succ     bool
+	}{
+		{
+			name: "Local",
+			local: []*gpupb.GPU{
+				&gpupb.GPU{
+					Id: 100,
+				},
+				&gpupb.GPU{
+					Id: 101,
+				},
+			},
+			market:   nil,
+			capacity: 2,
+			want:     2,
+			succ:     true,
+		},
+		{
+			name: "NoMarket",
+			local: []*gpupb.GPU{
+				&gpupb.GPU{
+					Id: 100,
+				},
+			},
+			market:   nil,
+			capacity: 2,
+			succ:     false,
+		},
+		{
+			name: "Mixed",
+			local: []*gpupb.GPU{
+				&gpupb.GPU{
+					Id: 100,
+				},
+			},
+			market: &market{
+				gpus: []*gpupb.GPU{
+					&gpupb.GPU{
+						Host: "some-remote-host",
+						Id:   100,
+					},
+				},
+			},
+			capacity: 2,
+			want:     2,
+			succ:     true,
+		},
+		{
+			name: "Gang",
+			local: []*gpupb.GPU{
+				&gpupb.GPU{
+					Id: 100,
+				},
+			},
+			market: &market{
+				gpus: []*gpupb.GPU{
+					&gpupb.GPU{
+						Host: "some-remote-host",
+						Id:   100,
+					},
+					&gpupb.GPU{
+						Host: "some-remote-host",
+						Id:   101,
+					},
+				},
+			},
+			capacity: 3,
+			want:     3,
+			succ:     true,
+		},
+		{
+			name: "Insufficient",
+			local: []*gpupb.GPU{
+				&gpupb.GPU{
+					Id: 100,
+				},
+			},
+			market: &market{
+				gpus: []*gpupb.GPU{
+					&gpupb.GPU{
+						Host: "some-remote-host",
+						Id:   100,
+					},
+				},
+			},
+			capacity: 3,
+			succ:     false,
+		},
+		{
+			name: "Timeout",
+			local: []*gpupb.GPU{
+				&gpupb.GPU{
+					Id: 100,
+				},
+			},
+			market: &market{
+				block: true,
+			},
+			capacity: 2,
+			succ:     false,
+		},
+	}
+
+	for _, c := range configs {
+		t.Run(c.name, func(t *testing.T) {
+			s := &S{
+				o: O{
+					Timeout: 100 * time.Millisecond,
+				},
+				gpus: gpu.New(nil, c.local),
+			}
+			var remote int
+			if c.market != nil {
+				s.market = c.market
+				remote = len(c.market.gpus)
+			}
+			cl := serve(t, s)
+
+			gpus, err := cl.InternalAllocateGPU(context.Background(), "some-task", c.capacity)
+			if !c.succ {
+				if err == nil {
+					t.Errorf("InternalAllocateGPU() unexpectedly succeeded: %v", gpus)
+				}
+
+				// Ensure local allocations were rolled back.
+				if got := len(s.gpus.Available()); got != len(c.local) {
+					t.Errorf("len(Available()) = %v, want = %v", got, len(c.local))
+				}
+
+				// Ensure remote leases were released.
+				if c.market != nil {
+					c.market.l.Lock()
+					defer c.market.l.Unlock()
+					if got := len(c.market.gpus); got != remote {
+						t.Errorf("len(gpus) = %v, want = %v", got, remote)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("InternalAllocateGPU() unexpectedly failed: %v", err)
+			}
+			if got := len(gpus); got != c.want {
+				t.Errorf("len(InternalAllocateGPU()) = %v, want = %v", got, c.want)
+			}
+		})
+	}
+}
+
+// TestInternalAllocateGPURollback checks that a failed allocation does not
+// roll back GPUs held by the task from earlier allocations.
+func TestInternalAllocateGPURollback(t *testing.T) {
+	s := &S{
+		o: O{
+			Timeout: 100 * time.Millisecond,
+		},
+		gpus: gpu.New(nil, []*gpupb.GPU{
+			&gpupb.GPU{
+				Id: 100,
+			},
+			&gpupb.GPU{
+				Id: 101,
+			},
+		}),
+		market: &market{},
+	}
+	cl := serve(t, s)
+
+	if _, err := cl.InternalAllocateGPU(context.Background(), "some-task", 1); err != nil {
+		t.Fatalf("InternalAllocateGPU() unexpectedly failed: %v", err)
+	}
+	if gpus, err := cl.InternalAllocateGPU(context.Background(), "some-task", 2); err == nil {
+		t.Fatalf("InternalAllocateGPU() unexpectedly succeeded: %v", gpus)
+	}
+	if got := len(s.gpus.Available()); got != 1 {
+		t.Errorf("len(Available()) = %v, want = 1", got)
+	}
+}
+
+func TestNewTimeout(t *testing.T) {
+	configs := []struct {
+		name    string
+		timeout time.Duration
+		succ    bool
+	}{
+		{name: "Default", timeout: 0, succ: true},
+		{name: "Short", timeout: 10 * time.Second, succ: true},
+		{name: "Window", timeout: 5 * time.Second, succ: false},
+	}
+
+	for _, c := range configs {
+		t.Run(c.name, func(t *testing.T) {
+			s, err := New(O{
+				Address:    "127.0.0.1",
+				Timeout:    c.timeout,
+				Discoverer: &mgpu.Fake{},
+			})
+			if !c.succ {
+				if err == nil {
+					s.Stop()
+					t.Errorf("New() unexpectedly succeeded")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("New() unexpectedly failed: %v", err)
+			}
+			s.Stop()
+		})
+	}
+}
+
+// TestNewJournal checks that GPUs lent to remote governors are restored from
+// the lease journal when the server restarts.
+func TestNewJournal(t *testing.T) {
+	o := O{
+		Address: "127.0.0.1",
+		Discoverer: &mgpu.Fake{
+			GPUs: []*gpupb.GPU{
+				&gpupb.GPU{
+					Id: 100,
+				},
+			},
+		},
+		Journal: filepath.Join(t.TempDir(), "journal"),
+	}
+
+	s, err := New(o)
+	if err != nil {
+		t.Fatalf("New() unexpectedly failed: %v", err)
+	}
+	resp, err := s.gpus.Lease(context.Background(), &gpupb.LeaseRequest{
+		Token:    "some-token",
+		Duration: dpb.New(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("Lease() unexpectedly failed: %v", err)
+	}
+	s.Stop()
+
+	s, err = New(o)
+	if err != nil {
+		t.Fatalf("New() unexpectedly failed: %v", err)
+	}
+	defer s.Stop()
+
+	if task, ok := s.gpus.Task(100); !ok || task != resp.GetLease().GetToken() {
+		t.Errorf("Task() = %v, %v, want = %v, %v", task, ok, resp.GetLease().GetToken(), true)
+	}
+}
+
+// TestPreempter checks that preemption notices stop the containers running on
+// the preempted GPUs.
+func TestPreempter(t *testing.T) {
+	configs := []struct {
+		name   string
+		detach bool
+		want   bool
+	}{
+		{name: "Attached", detach: false, want: true},
+		{name: "Detached", detach: true, want: false},
+	}
+
+	for _, c := range configs {
+		t.Run(c.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			s := &S{
+				hypervisors: make(map[device]*hypervisor.Hypervisor),
+			}
+			ch := make(chan *gpupb.LeasePreemption)
+			go s.preempter(ctx, ch)
+
+			cmd := exec.Command("sleep", "60")
+			if err := cmd.Start(); err != nil {
+				t.Fatalf("Start() unexpectedly failed: %v", err)
+			}
+			defer cmd.Process.Kill()
+
+			exited := make(chan struct{})
+			go func() {
+				cmd.Wait()
+				close(exited)
+			}()
+
+			g := &gpupb.GPU{
+				Host: "some-remote-host",
+				Id:   100,
+			}
+			detach := s.Attach(g, (*hypervisor.Hypervisor)(cmd))
+			if c.detach {
+				detach()
+			}
+
+			ch <- &gpupb.LeasePreemption{
+				Lease: &gpupb.Lease{
+					Gpu:        g,
+					Expiration: tpb.New(time.Now().Add(time.Minute)),
+				},
+			}
+
+			var got bool
+			select {
+			case <-exited:
+				got = true
+			case <-time.After(time.Second):
+			}
+			if got != c.want {
+				t.Errorf("exited = %v, want = %v", got, c.want)
+			}
+		})
+	}
+}
@@ -1,10 +1,13 @@
 import argparse
+import os
 import pickle
 import io
 import torch
 import requests
 
-torch.cuda.set_per_process_memory_fraction(0.1, 0)
+# The governor grants each container a fraction of a (possibly shared) GPU.
+torch.cuda.set_per_process_memory_fraction(
+    float(os.environ.get('FEDTORCH_MEMORY_FRACTION', 0.1)), 0)
 
 class FederatedLearning:
     def __init__(self, host = 'localhost:5000'):